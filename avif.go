@@ -0,0 +1,45 @@
+//go:build heif || darwin
+// +build heif darwin
+
+package imagecoding
+
+import (
+	"bytes"
+	"image"
+)
+
+// #include <libheif/heif.h>
+import "C"
+
+// AVIF is AV1 in a HEIF container, libheif reads and writes it when it is built with an AV1 codec
+
+// ConfigAvif returns the size of the primary image of an AVIF file
+func ConfigAvif(data []byte) (image.Config, string, error) {
+	return configHeifFile(data, Avif)
+}
+
+// DecodeAvif decodes the primary image of an AVIF file
+func DecodeAvif(data []byte) (image.Image, error) {
+	return DecodeHeif(data)
+}
+
+// TransformAvif is like TransformHeif for AVIF files
+func TransformAvif(data []byte, grayscale bool, scale ScaleFunc) (out image.Image, width, height int, scaleFactor float64, err error) {
+	res, err := TransformAvifWithOptions(data, legacyOptions(grayscale, scale))
+	if err != nil {
+		return nil, 0, 0, 0, err
+	}
+	return res.Image, res.Width, res.Height, res.ScaleFactor, nil
+}
+
+// TransformAvifWithOptions is like TransformAvif but configured through TransformOptions
+func TransformAvifWithOptions(data []byte, opts TransformOptions) (TransformResult, error) {
+	return transformHeifFile(data, opts, Avif)
+}
+
+// EncodeAvif will encode an image to AVIF bytes using libheif's AV1 encoder
+func EncodeAvif(buf *bytes.Buffer, img image.Image, opts AvifEncodeOptions) ([]byte, error) {
+	opts = opts.withDefaults()
+	chroma := opts.Chroma.encoderParameter(opts.Lossless)
+	return encodeHeifFile(buf, img, C.heif_compression_AV1, opts.Quality, opts.Lossless, chroma, 0, opts.Metadata)
+}
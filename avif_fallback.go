@@ -0,0 +1,29 @@
+//go:build !heif && !darwin
+// +build !heif,!darwin
+
+package imagecoding
+
+import (
+	"bytes"
+	"image"
+)
+
+func ConfigAvif(data []byte) (image.Config, string, error) {
+	return image.Config{}, "", image.ErrFormat
+}
+
+func DecodeAvif(data []byte) (image.Image, error) {
+	return nil, image.ErrFormat
+}
+
+func TransformAvif(data []byte, grayscale bool, scale ScaleFunc) (out image.Image, width, height int, scaleFactor float64, err error) {
+	return nil, 0, 0, 0, image.ErrFormat
+}
+
+func TransformAvifWithOptions(data []byte, opts TransformOptions) (TransformResult, error) {
+	return TransformResult{}, image.ErrFormat
+}
+
+func EncodeAvif(buf *bytes.Buffer, img image.Image, opts AvifEncodeOptions) ([]byte, error) {
+	return nil, image.ErrFormat
+}
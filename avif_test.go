@@ -0,0 +1,69 @@
+//go:build heif || darwin
+// +build heif darwin
+
+package imagecoding
+
+import (
+	"bytes"
+	"image"
+	"testing"
+
+	"github.com/Nr90/imgsim"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodeAvif(t *testing.T) {
+	var buf bytes.Buffer
+	imgbytes, err := EncodeAvif(&buf, img, AvifEncodeOptions{Quality: 80})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, buf.Len(), len(imgbytes))
+
+	cfg, format, err := DecodeConfig(imgbytes)
+	if assert.NoError(t, err) {
+		assert.Equal(t, string(Avif), format)
+		assert.Equal(t, img.Bounds().Dx(), cfg.Width)
+		assert.Equal(t, img.Bounds().Dy(), cfg.Height)
+	}
+
+	output, err := DecodeAvif(imgbytes)
+	if assert.NoError(t, err) {
+		assert.Equal(t, ref, imgsim.AverageHash(output))
+	}
+
+	res, err := TransformWithOptions(imgbytes, TransformOptions{ColorMode: ColorModeGray})
+	if assert.NoError(t, err) {
+		assert.Equal(t, Avif, res.Format)
+		assert.IsType(t, &image.Gray{}, res.Image)
+	}
+}
+
+func TestEncodeAvifImageTypes(t *testing.T) {
+	for name, src := range makeConvertTestImages() {
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+			imgbytes, err := EncodeAvif(&buf, src, AvifEncodeOptions{Lossless: true})
+			if !assert.NoError(t, err) {
+				return
+			}
+			output, err := DecodeAvif(imgbytes)
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, src.Bounds().Size(), output.Bounds().Size())
+			expected := src
+			if !isOpaque(src) {
+				// The alpha is stored as an auxiliary image, decoding returns the straight colors
+				straight := toNRGBA(src)
+				opaque := image.NewNRGBA(straight.Rect)
+				copy(opaque.Pix, straight.Pix)
+				for i := 3; i < len(opaque.Pix); i += 4 {
+					opaque.Pix[i] = 0xff
+				}
+				expected = opaque
+			}
+			assertSimilar(t, expected, output, 0)
+		})
+	}
+}
@@ -0,0 +1,354 @@
+package imagecoding
+
+import (
+	"encoding/binary"
+	"image"
+	"image/color"
+	"math"
+)
+
+// CMYK ICC profiles describe the inks through a lookup table from device values to the profile
+// connection space, the A2B tags, which are evaluated here to convert CMYK images to sRGB
+
+// iccLut is the pipeline of an lut8, lut16 or lutAtoB tag: curves on the inputs, a multidimensional
+// table and curves on the outputs, for lutAtoB with a matrix between further output curves
+type iccLut struct {
+	inputs, outputs int
+	a               []func(float64) float64
+	grid            []int
+	// clut holds the outputs of the grid points, the first input varies slowest
+	clut   []float64
+	m      []func(float64) float64
+	matrix []float64
+	b      []func(float64) float64
+	// legacyLab is set for lut16 tags, which encode Lab with 0xff00 as the maximum
+	legacyLab bool
+}
+
+// eval runs the pipeline on values between 0 and 1
+func (l *iccLut) eval(in []float64) []float64 {
+	v := make([]float64, len(in))
+	for i, x := range in {
+		v[i] = l.a[i](x)
+	}
+	if l.clut != nil {
+		v = l.interpolate(v)
+	}
+	if l.m != nil {
+		for i := range v {
+			v[i] = l.m[i](v[i])
+		}
+	}
+	if l.matrix != nil {
+		m := l.matrix
+		v = []float64{
+			m[0]*v[0] + m[1]*v[1] + m[2]*v[2] + m[9],
+			m[3]*v[0] + m[4]*v[1] + m[5]*v[2] + m[10],
+			m[6]*v[0] + m[7]*v[1] + m[8]*v[2] + m[11],
+		}
+	}
+	for i := range v {
+		v[i] = l.b[i](math.Max(0, math.Min(1, v[i])))
+	}
+	return v
+}
+
+// interpolate looks up the table with multilinear interpolation between the surrounding grid points
+func (l *iccLut) interpolate(in []float64) []float64 {
+	n := len(in)
+	base := make([]int, n)
+	frac := make([]float64, n)
+	strides := make([]int, n)
+	stride := l.outputs
+	for i := n - 1; i >= 0; i-- {
+		strides[i] = stride
+		stride *= l.grid[i]
+		pos := math.Max(0, math.Min(1, in[i])) * float64(l.grid[i]-1)
+		base[i] = int(pos)
+		if base[i] >= l.grid[i]-1 {
+			base[i] = l.grid[i] - 2
+		}
+		frac[i] = pos - float64(base[i])
+	}
+
+	out := make([]float64, l.outputs)
+	for corner := 0; corner < 1<<n; corner++ {
+		w, offset := 1.0, 0
+		for i := 0; i < n; i++ {
+			if corner>>i&1 == 1 {
+				w *= frac[i]
+				offset += strides[i] * (base[i] + 1)
+			} else {
+				w *= 1 - frac[i]
+				offset += strides[i] * base[i]
+			}
+		}
+		if w == 0 {
+			continue
+		}
+		for o := range out {
+			out[o] += w * l.clut[offset+o]
+		}
+	}
+	return out
+}
+
+// parseICCLut reads an lut8 (mft1), lut16 (mft2) or lutAtoB (mAB) tag
+func parseICCLut(tag []byte) (*iccLut, error) {
+	if len(tag) < 32 {
+		return nil, errUnsupportedProfile
+	}
+	l := &iccLut{inputs: int(tag[8]), outputs: int(tag[9])}
+	if l.inputs < 1 || l.inputs > 8 || l.outputs != 3 {
+		return nil, errUnsupportedProfile
+	}
+	grid := make([]int, l.inputs)
+	switch string(tag[:4]) {
+	case "mft1", "mft2":
+		// The matrix only applies to XYZ inputs
+		for i := range grid {
+			grid[i] = int(tag[10])
+		}
+		size, inEntries, outEntries, offset := 1, 256, 256, 48
+		if string(tag[:4]) == "mft2" {
+			if len(tag) < 52 {
+				return nil, errUnsupportedProfile
+			}
+			size, offset = 2, 52
+			inEntries, outEntries = int(binary.BigEndian.Uint16(tag[48:])), int(binary.BigEndian.Uint16(tag[50:]))
+			l.legacyLab = true
+		}
+		if inEntries < 2 || outEntries < 2 {
+			return nil, errUnsupportedProfile
+		}
+		var ok bool
+		if l.a, offset, ok = lutTables(tag, offset, l.inputs, inEntries, size); !ok {
+			return nil, errUnsupportedProfile
+		}
+		if offset, ok = l.readClut(tag, offset, grid, size); !ok {
+			return nil, errUnsupportedProfile
+		}
+		if l.b, _, ok = lutTables(tag, offset, l.outputs, outEntries, size); !ok {
+			return nil, errUnsupportedProfile
+		}
+	case "mAB ":
+		offsets := make([]int, 5)
+		for i := range offsets {
+			offsets[i] = int(binary.BigEndian.Uint32(tag[12+4*i:]))
+		}
+		bOffset, matrixOffset, mOffset, clutOffset, aOffset := offsets[0], offsets[1], offsets[2], offsets[3], offsets[4]
+		var err error
+		if l.b, err = iccCurveSequence(tag, bOffset, l.outputs); err != nil || bOffset == 0 {
+			return nil, errUnsupportedProfile
+		}
+		if l.a, err = iccCurveSequence(tag, aOffset, l.inputs); err != nil {
+			return nil, err
+		}
+		if l.m, err = iccCurveSequence(tag, mOffset, l.outputs); err != nil {
+			return nil, err
+		}
+		if matrixOffset != 0 {
+			if matrixOffset+48 > len(tag) {
+				return nil, errUnsupportedProfile
+			}
+			l.matrix = make([]float64, 12)
+			for i := range l.matrix {
+				l.matrix[i] = s15Fixed16(tag[matrixOffset+4*i:])
+			}
+		}
+		if clutOffset != 0 {
+			if clutOffset+20 > len(tag) {
+				return nil, errUnsupportedProfile
+			}
+			for i := range grid {
+				grid[i] = int(tag[clutOffset+i])
+			}
+			if _, ok := l.readClut(tag, clutOffset+20, grid, int(tag[clutOffset+16])); !ok {
+				return nil, errUnsupportedProfile
+			}
+		}
+		// Without a table the inputs map straight to the outputs
+		if l.clut == nil && l.inputs != l.outputs {
+			return nil, errUnsupportedProfile
+		}
+		if l.a == nil {
+			l.a = make([]func(float64) float64, l.inputs)
+			for i := range l.a {
+				l.a[i] = func(v float64) float64 { return v }
+			}
+		}
+	default:
+		return nil, errUnsupportedProfile
+	}
+	return l, nil
+}
+
+// readClut reads the grid points of 1 or 2 bytes and returns the offset after them
+func (l *iccLut) readClut(tag []byte, offset int, grid []int, size int) (int, bool) {
+	count := l.outputs
+	for _, g := range grid {
+		if g < 2 {
+			return 0, false
+		}
+		count *= g
+	}
+	if (size != 1 && size != 2) || offset+count*size > len(tag) {
+		return 0, false
+	}
+	l.grid = grid
+	l.clut = make([]float64, count)
+	for i := range l.clut {
+		if size == 1 {
+			l.clut[i] = float64(tag[offset+i]) / 255
+		} else {
+			l.clut[i] = float64(binary.BigEndian.Uint16(tag[offset+2*i:])) / 65535
+		}
+	}
+	return offset + count*size, true
+}
+
+// lutTables reads the curve tables of an lut8 or lut16 tag and returns the offset after them
+func lutTables(tag []byte, offset, channels, entries, size int) ([]func(float64) float64, int, bool) {
+	if offset+channels*entries*size > len(tag) {
+		return nil, 0, false
+	}
+	curves := make([]func(float64) float64, channels)
+	for i := range curves {
+		curves[i] = tableCurve(tag[offset:], entries, size)
+		offset += entries * size
+	}
+	return curves, offset, true
+}
+
+// iccCurveSequence reads the curves of a lutAtoB tag, nil when the offset is zero
+func iccCurveSequence(tag []byte, offset, channels int) ([]func(float64) float64, error) {
+	if offset == 0 {
+		return nil, nil
+	}
+	curves := make([]func(float64) float64, channels)
+	for i := range curves {
+		if offset >= len(tag) {
+			return nil, errUnsupportedProfile
+		}
+		size := iccCurveSize(tag[offset:])
+		if size == 0 || offset+size > len(tag) {
+			return nil, errUnsupportedProfile
+		}
+		curve, err := iccCurve(tag[offset : offset+size])
+		if err != nil {
+			return nil, err
+		}
+		curves[i] = curve
+		// Curves are padded to 4 bytes
+		offset += (size + 3) &^ 3
+	}
+	return curves, nil
+}
+
+// d50 is the XYZ of the white point of the profile connection space
+var d50 = [3]float64{0.9642, 1, 0.8249}
+
+// labToXYZ converts CIELAB relative to D50 to XYZ
+func labToXYZ(l, a, b float64) [3]float64 {
+	fy := (l + 16) / 116
+	f := [3]float64{fy + a/500, fy, fy - b/200}
+	var xyz [3]float64
+	for i, t := range f {
+		if t > 6.0/29 {
+			xyz[i] = t * t * t * d50[i]
+		} else {
+			xyz[i] = 3 * (6.0 / 29) * (6.0 / 29) * (t - 4.0/29) * d50[i]
+		}
+	}
+	return xyz
+}
+
+// cmykCacheSize limits the memory of the cache for photos with many colors
+const cmykCacheSize = 1 << 18
+
+// cmykTransform converts ink values to sRGB through the A2B table of a CMYK profile
+type cmykTransform struct {
+	lut *iccLut
+	lab bool
+	// cache holds the converted colors, print graphics repeat few of them
+	cache map[uint32][3]uint8
+}
+
+// cmykColorTransform reads the table of the perceptual rendering intent of a CMYK profile,
+// the colorimetric ones when it is missing
+func cmykColorTransform(profile []byte) (*cmykTransform, error) {
+	tags, err := iccTags(profile)
+	if err != nil {
+		return nil, err
+	}
+	pcs := string(profile[20:24])
+	if string(profile[16:20]) != "CMYK" || (pcs != "Lab " && pcs != "XYZ ") {
+		return nil, errUnsupportedProfile
+	}
+	for _, name := range []string{"A2B0", "A2B1", "A2B2"} {
+		if tag, ok := tags[name]; ok {
+			lut, err := parseICCLut(tag)
+			if err != nil {
+				return nil, err
+			}
+			if lut.inputs != 4 {
+				return nil, errUnsupportedProfile
+			}
+			return &cmykTransform{lut: lut, lab: pcs == "Lab ", cache: map[uint32][3]uint8{}}, nil
+		}
+	}
+	return nil, errUnsupportedProfile
+}
+
+// rgb converts ink values, where 0 is no ink, to sRGB
+func (t *cmykTransform) rgb(c, m, y, k uint8) (uint8, uint8, uint8) {
+	key := uint32(c)<<24 | uint32(m)<<16 | uint32(y)<<8 | uint32(k)
+	if rgb, ok := t.cache[key]; ok {
+		return rgb[0], rgb[1], rgb[2]
+	}
+	v := t.lut.eval([]float64{float64(c) / 255, float64(m) / 255, float64(y) / 255, float64(k) / 255})
+	var xyz [3]float64
+	switch {
+	case t.lab && t.lut.legacyLab:
+		scale := 65535.0 / 65280
+		xyz = labToXYZ(v[0]*100*scale, v[1]*255*scale-128, v[2]*255*scale-128)
+	case t.lab:
+		xyz = labToXYZ(v[0]*100, v[1]*255-128, v[2]*255-128)
+	default:
+		// XYZ is encoded with 1 at 0x8000
+		scale := 65535.0 / 32768
+		xyz = [3]float64{v[0] * scale, v[1] * scale, v[2] * scale}
+	}
+	var rgb [3]uint8
+	for i, l := range xyzD50ToSRGB.apply(xyz) {
+		rgb[i] = srgbEncode[int(math.Round(math.Max(0, math.Min(1, l))*srgbEncodeSize))]
+	}
+	if len(t.cache) < cmykCacheSize {
+		t.cache[key] = rgb
+	}
+	return rgb[0], rgb[1], rgb[2]
+}
+
+// cmykToRGB converts CMYK pixels as libjpeg decodes them to sRGB. Adobe applications store
+// inverted ink values, where 0 is full ink. Without a profile the inks are taken as ideal.
+func cmykToRGB(img *image.CMYK, inverted bool, t *cmykTransform) *RGBImage {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	out := NewRGBImage(image.Rect(0, 0, w, h))
+	var flip uint8
+	if inverted {
+		flip = 0xff
+	}
+	for y := 0; y < h; y++ {
+		src := img.Pix[img.PixOffset(img.Rect.Min.X, img.Rect.Min.Y+y):]
+		dst := out.Pix[y*out.Stride:]
+		for x := 0; x < w; x++ {
+			c, m, ye, k := src[x*4+0]^flip, src[x*4+1]^flip, src[x*4+2]^flip, src[x*4+3]^flip
+			if t != nil {
+				dst[x*3+0], dst[x*3+1], dst[x*3+2] = t.rgb(c, m, ye, k)
+			} else {
+				dst[x*3+0], dst[x*3+1], dst[x*3+2] = color.CMYKToRGB(c, m, ye, k)
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,139 @@
+package imagecoding
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/color"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// swappedInks is the color of ideal inks with red and blue swapped, to tell profile conversions apart
+func swappedInks(c, m, y, k uint8) [3]uint8 {
+	r, g, b := color.CMYKToRGB(c, m, y, k)
+	return [3]uint8{b, g, r}
+}
+
+// srgbToLab converts an 8-bit sRGB color to CIELAB relative to D50
+func srgbToLab(rgb [3]uint8) [3]float64 {
+	linear := [3]float64{srgbDecode(float64(rgb[0]) / 255), srgbDecode(float64(rgb[1]) / 255), srgbDecode(float64(rgb[2]) / 255)}
+	xyz := xyzD50ToSRGB.invert().apply(linear)
+	var f [3]float64
+	for i := range xyz {
+		t := xyz[i] / d50[i]
+		if t > math.Pow(6.0/29, 3) {
+			f[i] = math.Cbrt(t)
+		} else {
+			f[i] = t/(3*(6.0/29)*(6.0/29)) + 4.0/29
+		}
+	}
+	return [3]float64{116*f[1] - 16, 500 * (f[0] - f[1]), 200 * (f[1] - f[2])}
+}
+
+// makeCMYKProfile writes a CMYK profile whose A2B0 tag of the given type maps the corners of the
+// ink cube to the colors of swappedInks, with identity curves
+func makeCMYKProfile(kind string) []byte {
+	u16 := func(buf *bytes.Buffer, v float64) {
+		binary.Write(buf, binary.BigEndian, uint16(math.Round(math.Max(0, math.Min(65535, v)))))
+	}
+	var clut bytes.Buffer
+	for i := 0; i < 16; i++ {
+		// The first input varies slowest
+		ink := func(bit int) uint8 { return uint8((i >> (3 - bit) & 1) * 255) }
+		lab := srgbToLab(swappedInks(ink(0), ink(1), ink(2), ink(3)))
+		if kind == "mft2" {
+			u16(&clut, lab[0]/100*65280)
+			u16(&clut, (lab[1]+128)*256)
+			u16(&clut, (lab[2]+128)*256)
+		} else {
+			u16(&clut, lab[0]/100*65535)
+			u16(&clut, (lab[1]+128)/255*65535)
+			u16(&clut, (lab[2]+128)/255*65535)
+		}
+	}
+
+	var tag bytes.Buffer
+	tag.WriteString(kind)
+	tag.Write([]byte{0, 0, 0, 0, 4, 3})
+	if kind == "mft2" {
+		tag.Write([]byte{2, 0})
+		for i := 0; i < 9; i++ {
+			if i%4 == 0 {
+				binary.Write(&tag, binary.BigEndian, int32(65536))
+			} else {
+				binary.Write(&tag, binary.BigEndian, int32(0))
+			}
+		}
+		binary.Write(&tag, binary.BigEndian, []uint16{2, 2})
+		for i := 0; i < 4; i++ {
+			binary.Write(&tag, binary.BigEndian, []uint16{0, 65535})
+		}
+		tag.Write(clut.Bytes())
+		for i := 0; i < 3; i++ {
+			binary.Write(&tag, binary.BigEndian, []uint16{0, 65535})
+		}
+	} else {
+		identity := []byte("curv\x00\x00\x00\x00\x00\x00\x00\x00")
+		const bOffset, clutOffset = 32, 32 + 3*12
+		aOffset := clutOffset + 20 + clut.Len()
+		tag.Write([]byte{0, 0})
+		binary.Write(&tag, binary.BigEndian, []uint32{bOffset, 0, 0, clutOffset, uint32(aOffset)})
+		tag.Write(bytes.Repeat(identity, 3))
+		tag.Write([]byte{2, 2, 2, 2, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2, 0, 0, 0})
+		tag.Write(clut.Bytes())
+		tag.Write(bytes.Repeat(identity, 4))
+	}
+
+	const tagOffset = 128 + 4 + 12
+	header := make([]byte, 128)
+	binary.BigEndian.PutUint32(header, uint32(tagOffset+tag.Len()))
+	copy(header[12:], "prtr")
+	copy(header[16:], "CMYK")
+	copy(header[20:], "Lab ")
+	copy(header[36:], "acsp")
+	var out bytes.Buffer
+	out.Write(header)
+	binary.Write(&out, binary.BigEndian, []uint32{1})
+	out.WriteString("A2B0")
+	binary.Write(&out, binary.BigEndian, []uint32{tagOffset, uint32(tag.Len())})
+	out.Write(tag.Bytes())
+	return out.Bytes()
+}
+
+func assertRGB(t *testing.T, expected [3]uint8, r, g, b uint8, delta int, msgAndArgs ...interface{}) {
+	for i, v := range []uint8{r, g, b} {
+		if !assert.InDelta(t, int(expected[i]), int(v), float64(delta), msgAndArgs...) {
+			return
+		}
+	}
+}
+
+func TestCMYKColorTransform(t *testing.T) {
+	for _, kind := range []string{"mft2", "mAB "} {
+		t.Run(kind, func(t *testing.T) {
+			transform, err := cmykColorTransform(makeCMYKProfile(kind))
+			if !assert.NoError(t, err) {
+				return
+			}
+			for _, ink := range [][4]uint8{{0, 0, 0, 0}, {255, 0, 0, 0}, {0, 255, 0, 0}, {0, 0, 255, 0}, {255, 255, 0, 0}, {0, 0, 0, 255}} {
+				r, g, b := transform.rgb(ink[0], ink[1], ink[2], ink[3])
+				assertRGB(t, swappedInks(ink[0], ink[1], ink[2], ink[3]), r, g, b, 1, "ink %v", ink)
+			}
+			// Half the cyan ink lies between white and the yellow of the profile
+			r, g, b := transform.rgb(128, 0, 0, 0)
+			assert.Equal(t, uint8(255), r)
+			assert.Equal(t, uint8(255), g)
+			assert.True(t, b > 16 && b < 240, "blue %d", b)
+		})
+	}
+
+	_, err := cmykColorTransform(makeICCProfile(primariesSRGB, whiteD65, srgbCurve))
+	assert.Equal(t, errUnsupportedProfile, err)
+	profile := makeCMYKProfile("mft2")
+	_, err = cmykColorTransform(profile[:len(profile)-20])
+	assert.Equal(t, errUnsupportedProfile, err)
+	_, err = cmykColorTransform(profile[:100])
+	assert.Equal(t, errInvalidProfile, err)
+}
@@ -51,6 +51,11 @@ const (
 	Tiff ImgFormat = "tif"
 	Webp ImgFormat = "webp"
 	Heif ImgFormat = "heif"
+	Avif ImgFormat = "avif"
+	Jxl  ImgFormat = "jxl"
+	Jp2  ImgFormat = "jp2"
+	J2k  ImgFormat = "j2k"
+	Pdf  ImgFormat = "pdf"
 )
 
 var ErrEmptyInput = errors.New("empty input data")
@@ -92,10 +97,21 @@ func (p *RGBImage) RGBAAt(x, y int) color.RGBA {
 	if !(image.Point{x, y}.In(p.Rect)) {
 		return color.RGBA{}
 	}
-	i := (y-p.Rect.Min.Y)*p.Stride + (x-p.Rect.Min.X)*3
+	i := p.PixOffset(x, y)
 	return color.RGBA{p.Pix[i+0], p.Pix[i+1], p.Pix[i+2], 0xFF}
 }
 
+// PixOffset returns the index of the first element of Pix that corresponds to
+// the pixel at (x, y).
+func (p *RGBImage) PixOffset(x, y int) int {
+	return (y-p.Rect.Min.Y)*p.Stride + (x-p.Rect.Min.X)*3
+}
+
+// Opaque reports that the image is fully opaque, RGBImage has no alpha channel.
+func (p *RGBImage) Opaque() bool {
+	return true
+}
+
 // RGBModel is RGB color model instance
 var RGBModel = color.ModelFunc(rgbModel)
 
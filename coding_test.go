@@ -12,6 +12,7 @@ import (
 	"testing"
 
 	"github.com/Nr90/imgsim"
+	"github.com/stretchr/testify/assert"
 	_ "golang.org/x/image/bmp"
 	_ "golang.org/x/image/tiff"
 )
@@ -74,3 +75,30 @@ func readImageHash(filename string, t *testing.T, diffhash bool) imgsim.Hash {
 	}
 	return imgsim.AverageHash(img)
 }
+
+// noScale keeps the source size
+func noScale(w, h int) (int, int, float64) {
+	return w, h, 1
+}
+
+// assertSimilar checks that two images have the same size and that every 8-bit channel
+// differs by at most delta, comparing alpha premultiplied colors
+func assertSimilar(t testing.TB, expected, actual image.Image, delta int) bool {
+	eb, ab := expected.Bounds(), actual.Bounds()
+	if !assert.Equal(t, eb.Size(), ab.Size()) {
+		return false
+	}
+	for y := 0; y < eb.Dy(); y++ {
+		for x := 0; x < eb.Dx(); x++ {
+			er, eg, eb2, ea := expected.At(eb.Min.X+x, eb.Min.Y+y).RGBA()
+			ar, ag, ab2, aa := actual.At(ab.Min.X+x, ab.Min.Y+y).RGBA()
+			for i, pair := range [][2]uint32{{er, ar}, {eg, ag}, {eb2, ab2}, {ea, aa}} {
+				if diff := int(pair[0]>>8) - int(pair[1]>>8); diff > delta || diff < -delta {
+					return assert.Fail(t, "images differ", "channel %d of pixel (%d, %d): expected %d, actual %d",
+						i, x, y, pair[0]>>8, pair[1]>>8)
+				}
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,386 @@
+package imagecoding
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// Color conversion of RGB color spaces with a matrix and per channel transfer functions,
+// like Display P3 and Adobe RGB, which covers the ICC profiles that cameras and phones embed
+
+var (
+	errInvalidProfile     = errors.New("invalid icc profile")
+	errUnsupportedProfile = errors.New("unsupported color profile")
+)
+
+// chromaticity is a CIE xy coordinate
+type chromaticity struct{ x, y float64 }
+
+var (
+	whiteD65 = chromaticity{0.3127, 0.3290}
+	whiteD50 = chromaticity{0.3457, 0.3585}
+)
+
+// matrix3 is a 3x3 matrix applied to column vectors
+type matrix3 [3][3]float64
+
+func (m matrix3) mul(n matrix3) matrix3 {
+	var out matrix3
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			for k := 0; k < 3; k++ {
+				out[i][j] += m[i][k] * n[k][j]
+			}
+		}
+	}
+	return out
+}
+
+func (m matrix3) apply(v [3]float64) [3]float64 {
+	var out [3]float64
+	for i := 0; i < 3; i++ {
+		out[i] = m[i][0]*v[0] + m[i][1]*v[1] + m[i][2]*v[2]
+	}
+	return out
+}
+
+func (m matrix3) invert() matrix3 {
+	det := m[0][0]*(m[1][1]*m[2][2]-m[1][2]*m[2][1]) -
+		m[0][1]*(m[1][0]*m[2][2]-m[1][2]*m[2][0]) +
+		m[0][2]*(m[1][0]*m[2][1]-m[1][1]*m[2][0])
+	var inv matrix3
+	inv[0][0] = (m[1][1]*m[2][2] - m[1][2]*m[2][1]) / det
+	inv[0][1] = (m[0][2]*m[2][1] - m[0][1]*m[2][2]) / det
+	inv[0][2] = (m[0][1]*m[1][2] - m[0][2]*m[1][1]) / det
+	inv[1][0] = (m[1][2]*m[2][0] - m[1][0]*m[2][2]) / det
+	inv[1][1] = (m[0][0]*m[2][2] - m[0][2]*m[2][0]) / det
+	inv[1][2] = (m[0][2]*m[1][0] - m[0][0]*m[1][2]) / det
+	inv[2][0] = (m[1][0]*m[2][1] - m[1][1]*m[2][0]) / det
+	inv[2][1] = (m[0][1]*m[2][0] - m[0][0]*m[2][1]) / det
+	inv[2][2] = (m[0][0]*m[1][1] - m[0][1]*m[1][0]) / det
+	return inv
+}
+
+func (c chromaticity) xyz() [3]float64 {
+	return [3]float64{c.x / c.y, 1, (1 - c.x - c.y) / c.y}
+}
+
+// rgbToXYZ derives the matrix from linear RGB to XYZ from the primaries and the white point
+func rgbToXYZ(r, g, b, white chromaticity) matrix3 {
+	pr, pg, pb := r.xyz(), g.xyz(), b.xyz()
+	m := matrix3{{pr[0], pg[0], pb[0]}, {pr[1], pg[1], pb[1]}, {pr[2], pg[2], pb[2]}}
+	s := m.invert().apply(white.xyz())
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			m[i][j] *= s[j]
+		}
+	}
+	return m
+}
+
+// bradford adapts XYZ colors from one white point to another
+func bradford(from, to chromaticity) matrix3 {
+	cone := matrix3{{0.8951, 0.2664, -0.1614}, {-0.7502, 1.7135, 0.0367}, {0.0389, -0.0685, 1.0296}}
+	src, dst := cone.apply(from.xyz()), cone.apply(to.xyz())
+	scale := matrix3{{dst[0] / src[0], 0, 0}, {0, dst[1] / src[1], 0}, {0, 0, dst[2] / src[2]}}
+	return cone.invert().mul(scale).mul(cone)
+}
+
+var (
+	primariesSRGB = [3]chromaticity{{0.640, 0.330}, {0.300, 0.600}, {0.150, 0.060}}
+	// xyzD50ToSRGB maps the profile connection space of ICC profiles to linear sRGB
+	xyzD50ToSRGB = bradford(whiteD65, whiteD50).mul(rgbToXYZ(primariesSRGB[0], primariesSRGB[1], primariesSRGB[2], whiteD65)).invert()
+)
+
+// srgbEncodeSize is the size of the lookup table from linear light to sRGB, fine enough for 8-bit output
+const srgbEncodeSize = 1 << 14
+
+var srgbEncode = func() []uint8 {
+	lut := make([]uint8, srgbEncodeSize+1)
+	for i := range lut {
+		v := float64(i) / srgbEncodeSize
+		if v <= 0.0031308 {
+			v *= 12.92
+		} else {
+			v = 1.055*math.Pow(v, 1/2.4) - 0.055
+		}
+		lut[i] = uint8(math.Round(v * 255))
+	}
+	return lut
+}()
+
+func srgbDecode(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+// colorTransform converts RGB pixels of a color space to sRGB
+type colorTransform struct {
+	// linear decodes the transfer function of each channel
+	linear [3][256]float64
+	// matrix maps linear RGB to linear sRGB
+	matrix matrix3
+}
+
+// newColorTransform builds the conversion from linear RGB to XYZ with a D50 white point,
+// the profile connection space of ICC profiles
+func newColorTransform(toXYZD50 matrix3, curves [3]func(float64) float64) *colorTransform {
+	t := &colorTransform{matrix: xyzD50ToSRGB.mul(toXYZD50)}
+	for c := 0; c < 3; c++ {
+		for v := 0; v < 256; v++ {
+			t.linear[c][v] = curves[c](float64(v) / 255)
+		}
+	}
+	return t
+}
+
+// identity reports whether the transform leaves 8-bit sRGB pixels unchanged
+func (t *colorTransform) identity() bool {
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			want := 0.0
+			if i == j {
+				want = 1
+			}
+			if math.Abs(t.matrix[i][j]-want) > 0.001 {
+				return false
+			}
+		}
+		for v := 0; v < 256; v++ {
+			if math.Abs(t.linear[i][v]-srgbDecode(float64(v)/255)) > 0.001 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// apply converts an RGB image to sRGB, colors outside sRGB are clipped
+func (t *colorTransform) apply(img *RGBImage) *RGBImage {
+	out := NewRGBImage(img.Rect)
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	for y := 0; y < h; y++ {
+		src := img.Pix[img.PixOffset(img.Rect.Min.X, img.Rect.Min.Y+y):]
+		dst := out.Pix[y*out.Stride:]
+		for x := 0; x < w*3; x += 3 {
+			rgb := t.matrix.apply([3]float64{t.linear[0][src[x]], t.linear[1][src[x+1]], t.linear[2][src[x+2]]})
+			for c, v := range rgb {
+				dst[x+c] = srgbEncode[int(math.Round(math.Max(0, math.Min(1, v))*srgbEncodeSize))]
+			}
+		}
+	}
+	return out
+}
+
+// nclx color primaries of ISO/IEC 23091-2
+var nclxPrimaries = map[uint16][4]chromaticity{
+	1:  {{0.640, 0.330}, {0.300, 0.600}, {0.150, 0.060}, whiteD65},       // BT.709
+	5:  {{0.640, 0.330}, {0.290, 0.600}, {0.150, 0.060}, whiteD65},       // BT.470BG
+	6:  {{0.630, 0.340}, {0.310, 0.595}, {0.155, 0.070}, whiteD65},       // BT.601
+	7:  {{0.630, 0.340}, {0.310, 0.595}, {0.155, 0.070}, whiteD65},       // SMPTE 240M
+	9:  {{0.708, 0.292}, {0.170, 0.797}, {0.131, 0.046}, whiteD65},       // BT.2020
+	11: {{0.680, 0.320}, {0.265, 0.690}, {0.150, 0.060}, {0.314, 0.351}}, // DCI-P3
+	12: {{0.680, 0.320}, {0.265, 0.690}, {0.150, 0.060}, whiteD65},       // Display P3
+}
+
+// nclxTransfer returns the decoding of an nclx transfer characteristic
+func nclxTransfer(transfer uint16) func(float64) float64 {
+	switch transfer {
+	case 1, 6, 14, 15:
+		// BT.709
+		return func(v float64) float64 {
+			if v < 0.081 {
+				return v / 4.5
+			}
+			return math.Pow((v+0.099)/1.099, 1/0.45)
+		}
+	case 2, 13:
+		return srgbDecode
+	case 4:
+		return func(v float64) float64 { return math.Pow(v, 2.2) }
+	case 5:
+		return func(v float64) float64 { return math.Pow(v, 2.8) }
+	case 8:
+		return func(v float64) float64 { return v }
+	default:
+		// PQ and HLG need tone mapping
+		return nil
+	}
+}
+
+// nclxColorTransform converts an nclx color space to sRGB, unspecified primaries are taken as sRGB
+func nclxColorTransform(primaries, transfer uint16) (*colorTransform, error) {
+	p, ok := nclxPrimaries[primaries]
+	if primaries == 2 {
+		p, ok = nclxPrimaries[1], true
+	}
+	curve := nclxTransfer(transfer)
+	if !ok || curve == nil {
+		return nil, errUnsupportedProfile
+	}
+	toXYZ := bradford(p[3], whiteD50).mul(rgbToXYZ(p[0], p[1], p[2], p[3]))
+	return newColorTransform(toXYZ, [3]func(float64) float64{curve, curve, curve}), nil
+}
+
+// iccColorTransform converts the color space of a matrix/TRC RGB ICC profile to sRGB
+func iccColorTransform(profile []byte) (*colorTransform, error) {
+	tags, err := iccTags(profile)
+	if err != nil {
+		return nil, err
+	}
+	if string(profile[16:20]) != "RGB " || string(profile[20:24]) != "XYZ " {
+		return nil, errUnsupportedProfile
+	}
+
+	var toXYZ matrix3
+	var curves [3]func(float64) float64
+	for c, name := range []string{"r", "g", "b"} {
+		xyz := tags[name+"XYZ"]
+		if len(xyz) < 20 || string(xyz[:4]) != "XYZ " {
+			return nil, errUnsupportedProfile
+		}
+		for i := 0; i < 3; i++ {
+			toXYZ[i][c] = s15Fixed16(xyz[8+4*i:])
+		}
+		curve, err := iccCurve(tags[name+"TRC"])
+		if err != nil {
+			return nil, err
+		}
+		curves[c] = curve
+	}
+	return newColorTransform(toXYZ, curves), nil
+}
+
+// iccGrayTransform converts the gray levels of a gray ICC profile to sRGB gray levels,
+// neutral colors keep equal channels in sRGB so the tone curve is all that counts
+func iccGrayTransform(profile []byte) (*[256]uint8, error) {
+	tags, err := iccTags(profile)
+	if err != nil {
+		return nil, err
+	}
+	if string(profile[16:20]) != "GRAY" {
+		return nil, errUnsupportedProfile
+	}
+	curve, err := iccCurve(tags["kTRC"])
+	if err != nil {
+		return nil, err
+	}
+	var lut [256]uint8
+	for v := range lut {
+		lut[v] = srgbEncode[int(math.Round(math.Max(0, math.Min(1, curve(float64(v)/255)))*srgbEncodeSize))]
+	}
+	return &lut, nil
+}
+
+// iccTags returns the tags of an ICC profile by signature
+func iccTags(profile []byte) (map[string][]byte, error) {
+	if len(profile) < 132 || string(profile[36:40]) != "acsp" {
+		return nil, errInvalidProfile
+	}
+	tags := map[string][]byte{}
+	count := binary.BigEndian.Uint32(profile[128:])
+	for i := uint32(0); i < count && 132+12*(i+1) <= uint32(len(profile)); i++ {
+		entry := profile[132+12*i:]
+		offset, size := binary.BigEndian.Uint32(entry[4:]), binary.BigEndian.Uint32(entry[8:])
+		if uint64(offset)+uint64(size) <= uint64(len(profile)) {
+			tags[string(entry[:4])] = profile[offset : offset+size]
+		}
+	}
+	return tags, nil
+}
+
+func s15Fixed16(b []byte) float64 {
+	return float64(int32(binary.BigEndian.Uint32(b))) / 65536
+}
+
+// iccParaCounts is the number of parameters of each para function type
+var iccParaCounts = map[uint16]int{0: 1, 1: 3, 2: 4, 3: 5, 4: 7}
+
+// iccCurve returns the decoding of a curv or para tone reproduction curve
+func iccCurve(tag []byte) (func(float64) float64, error) {
+	if len(tag) < 12 {
+		return nil, errUnsupportedProfile
+	}
+	switch string(tag[:4]) {
+	case "curv":
+		n := int(binary.BigEndian.Uint32(tag[8:]))
+		if len(tag) < 12+2*n {
+			return nil, errUnsupportedProfile
+		}
+		switch n {
+		case 0:
+			return func(v float64) float64 { return v }, nil
+		case 1:
+			gamma := float64(binary.BigEndian.Uint16(tag[12:])) / 256
+			return func(v float64) float64 { return math.Pow(v, gamma) }, nil
+		default:
+			return tableCurve(tag[12:], n, 2), nil
+		}
+	case "para":
+		kind := binary.BigEndian.Uint16(tag[8:])
+		n, ok := iccParaCounts[kind]
+		if !ok || len(tag) < 12+4*n {
+			return nil, errUnsupportedProfile
+		}
+		// Missing parameters keep the shape of the simpler functions
+		p := [7]float64{1, 1, 0, 1, 0, 0, 0}
+		for i := 0; i < n; i++ {
+			p[i] = s15Fixed16(tag[12+4*i:])
+		}
+		g, a, b, c, d, e, f := p[0], p[1], p[2], p[3], p[4], p[5], p[6]
+		switch kind {
+		case 1:
+			c, d = 0, -b/a
+		case 2:
+			e, f, d = c, c, -b/a
+			c = 0
+		}
+		return func(v float64) float64 {
+			if kind == 0 {
+				return math.Pow(v, g)
+			}
+			if v >= d {
+				return math.Pow(math.Max(0, a*v+b), g) + e
+			}
+			return c*v + f
+		}, nil
+	}
+	return nil, errUnsupportedProfile
+}
+
+// iccCurveSize returns the size of a curv or para tag, as curves follow each other in lutAtoB tags
+func iccCurveSize(tag []byte) int {
+	if len(tag) < 12 {
+		return 0
+	}
+	switch string(tag[:4]) {
+	case "curv":
+		return 12 + 2*int(binary.BigEndian.Uint32(tag[8:]))
+	case "para":
+		return 12 + 4*iccParaCounts[binary.BigEndian.Uint16(tag[8:])]
+	}
+	return 0
+}
+
+// tableCurve interpolates linearly between n samples of 1 or 2 bytes
+func tableCurve(data []byte, n, size int) func(float64) float64 {
+	table := make([]float64, n)
+	for i := range table {
+		if size == 1 {
+			table[i] = float64(data[i]) / 255
+		} else {
+			table[i] = float64(binary.BigEndian.Uint16(data[2*i:])) / 65535
+		}
+	}
+	return func(v float64) float64 {
+		pos := math.Max(0, v) * float64(n-1)
+		i := int(pos)
+		if i >= n-1 {
+			return table[n-1]
+		}
+		return table[i] + (table[i+1]-table[i])*(pos-float64(i))
+	}
+}
@@ -0,0 +1,105 @@
+package imagecoding
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// srgbCurve is the sRGB transfer function as the parameters of a type 3 ICC para curve
+var srgbCurve = []float64{2.4, 1 / 1.055, 0.055 / 1.055, 1 / 12.92, 0.04045}
+
+// makeICCProfile writes a matrix/TRC RGB profile with the same para curve for each channel
+func makeICCProfile(primaries [3]chromaticity, white chromaticity, curve []float64) []byte {
+	fixed := func(buf *bytes.Buffer, v float64) {
+		binary.Write(buf, binary.BigEndian, int32(math.Round(v*65536)))
+	}
+	toXYZ := bradford(white, whiteD50).mul(rgbToXYZ(primaries[0], primaries[1], primaries[2], white))
+
+	var tags bytes.Buffer
+	type entry struct {
+		sig          string
+		offset, size int
+	}
+	var entries []entry
+	const dataStart = 128 + 4 + 6*12
+	for c, name := range []string{"rXYZ", "gXYZ", "bXYZ"} {
+		start := tags.Len()
+		tags.WriteString("XYZ \x00\x00\x00\x00")
+		for i := 0; i < 3; i++ {
+			fixed(&tags, toXYZ[i][c])
+		}
+		entries = append(entries, entry{name, dataStart + start, tags.Len() - start})
+	}
+	start := tags.Len()
+	tags.WriteString("para\x00\x00\x00\x00")
+	binary.Write(&tags, binary.BigEndian, uint16(3))
+	tags.Write([]byte{0, 0})
+	for _, p := range curve {
+		fixed(&tags, p)
+	}
+	for _, name := range []string{"rTRC", "gTRC", "bTRC"} {
+		entries = append(entries, entry{name, dataStart + start, tags.Len() - start})
+	}
+
+	header := make([]byte, 128)
+	binary.BigEndian.PutUint32(header, uint32(dataStart+tags.Len()))
+	copy(header[12:], "mntr")
+	copy(header[16:], "RGB ")
+	copy(header[20:], "XYZ ")
+	copy(header[36:], "acsp")
+	var out bytes.Buffer
+	out.Write(header)
+	binary.Write(&out, binary.BigEndian, uint32(len(entries)))
+	for _, e := range entries {
+		out.WriteString(e.sig)
+		binary.Write(&out, binary.BigEndian, uint32(e.offset))
+		binary.Write(&out, binary.BigEndian, uint32(e.size))
+	}
+	out.Write(tags.Bytes())
+	return out.Bytes()
+}
+
+var primariesP3 = [3]chromaticity{{0.680, 0.320}, {0.265, 0.690}, {0.150, 0.060}}
+
+func TestColorTransform(t *testing.T) {
+	p3, err := iccColorTransform(makeICCProfile(primariesP3, whiteD65, srgbCurve))
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.False(t, p3.identity())
+	nclx, err := nclxColorTransform(12, 13)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	img := NewRGBImage(image.Rect(0, 0, 3, 1))
+	copy(img.Pix, []uint8{
+		234, 51, 35, // sRGB red
+		255, 255, 255,
+		0, 255, 0, // Outside of sRGB
+	})
+	for _, out := range []*RGBImage{p3.apply(img), nclx.apply(img)} {
+		assertSimilar(t, &RGBImage{Pix: []uint8{255, 0, 0, 255, 255, 255, 0, 255, 0}, Stride: 9, Rect: img.Rect}, out, 2)
+	}
+
+	srgb, err := iccColorTransform(makeICCProfile(primariesSRGB, whiteD65, srgbCurve))
+	if assert.NoError(t, err) {
+		assert.True(t, srgb.identity())
+	}
+	srgb, err = nclxColorTransform(1, 13)
+	if assert.NoError(t, err) {
+		assert.True(t, srgb.identity())
+	}
+
+	_, err = nclxColorTransform(9, 16)
+	assert.Equal(t, errUnsupportedProfile, err)
+	cmyk := makeICCProfile(primariesSRGB, whiteD65, srgbCurve)
+	copy(cmyk[16:], "CMYK")
+	_, err = iccColorTransform(cmyk)
+	assert.Equal(t, errUnsupportedProfile, err)
+}
@@ -31,6 +31,14 @@ func DecodeConfig(content []byte) (image.Config, string, error) {
 		return ConfigJpeg(content)
 	case Heif:
 		return ConfigHeif(content)
+	case Avif:
+		return ConfigAvif(content)
+	case Jxl:
+		return ConfigJxl(content)
+	case Jp2, J2k:
+		return ConfigJp2(content)
+	case Pdf:
+		return ConfigPdf(content)
 	default:
 		c, fmt, err := image.DecodeConfig(bytes.NewReader(content))
 		return c, fmt, err
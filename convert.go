@@ -0,0 +1,249 @@
+package imagecoding
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// toGray converts an image to an *image.Gray with its origin at (0, 0).
+// Alpha is premultiplied, so transparent pixels become black like with color.GrayModel.
+func toGray(img image.Image) *image.Gray {
+	bounds := img.Bounds()
+	if v, ok := img.(*image.Gray); ok && bounds.Min == (image.Point{}) {
+		return v
+	}
+	w, h := bounds.Dx(), bounds.Dy()
+	out := image.NewGray(image.Rect(0, 0, w, h))
+
+	switch v := img.(type) {
+	case *image.YCbCr:
+		// The luma plane is the gray image
+		for y := 0; y < h; y++ {
+			yi := v.YOffset(bounds.Min.X, bounds.Min.Y+y)
+			copy(out.Pix[y*out.Stride:y*out.Stride+w], v.Y[yi:yi+w])
+		}
+	case *image.NRGBA:
+		for y := 0; y < h; y++ {
+			src := v.Pix[v.PixOffset(bounds.Min.X, bounds.Min.Y+y):]
+			dst := out.Pix[y*out.Stride:]
+			for x := 0; x < w; x++ {
+				r, g, b := premultiply(src[x*4+0], src[x*4+1], src[x*4+2], src[x*4+3])
+				dst[x] = grayLevel(r, g, b)
+			}
+		}
+	case *image.RGBA:
+		for y := 0; y < h; y++ {
+			src := v.Pix[v.PixOffset(bounds.Min.X, bounds.Min.Y+y):]
+			dst := out.Pix[y*out.Stride:]
+			for x := 0; x < w; x++ {
+				dst[x] = grayLevel(expand(src[x*4+0]), expand(src[x*4+1]), expand(src[x*4+2]))
+			}
+		}
+	case *RGBImage:
+		for y := 0; y < h; y++ {
+			src := v.Pix[v.PixOffset(bounds.Min.X, bounds.Min.Y+y):]
+			dst := out.Pix[y*out.Stride:]
+			for x := 0; x < w; x++ {
+				dst[x] = grayLevel(expand(src[x*3+0]), expand(src[x*3+1]), expand(src[x*3+2]))
+			}
+		}
+	case *image.Gray16:
+		for y := 0; y < h; y++ {
+			src := v.Pix[v.PixOffset(bounds.Min.X, bounds.Min.Y+y):]
+			dst := out.Pix[y*out.Stride:]
+			for x := 0; x < w; x++ {
+				dst[x] = src[x*2]
+			}
+		}
+	case *image.Paletted:
+		palette := make([]uint8, len(v.Palette))
+		for i, c := range v.Palette {
+			palette[i] = color.GrayModel.Convert(c).(color.Gray).Y
+		}
+		for y := 0; y < h; y++ {
+			src := v.Pix[v.PixOffset(bounds.Min.X, bounds.Min.Y+y):]
+			dst := out.Pix[y*out.Stride:]
+			for x := 0; x < w; x++ {
+				if int(src[x]) < len(palette) {
+					dst[x] = palette[src[x]]
+				}
+			}
+		}
+	default:
+		for y := 0; y < h; y++ {
+			dst := out.Pix[y*out.Stride:]
+			for x := 0; x < w; x++ {
+				dst[x] = color.GrayModel.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.Gray).Y
+			}
+		}
+	}
+	return out
+}
+
+// toRGB converts an image to an *RGBImage with its origin at (0, 0).
+// Alpha is premultiplied, so transparent pixels become black like with RGBModel.
+func toRGB(img image.Image) *RGBImage {
+	bounds := img.Bounds()
+	if v, ok := img.(*RGBImage); ok && bounds.Min == (image.Point{}) {
+		return v
+	}
+	w, h := bounds.Dx(), bounds.Dy()
+	out := NewRGBImage(image.Rect(0, 0, w, h))
+
+	switch v := img.(type) {
+	case *image.YCbCr:
+		for y := 0; y < h; y++ {
+			dst := out.Pix[y*out.Stride:]
+			for x := 0; x < w; x++ {
+				yi := v.YOffset(bounds.Min.X+x, bounds.Min.Y+y)
+				ci := v.COffset(bounds.Min.X+x, bounds.Min.Y+y)
+				dst[x*3+0], dst[x*3+1], dst[x*3+2] = color.YCbCrToRGB(v.Y[yi], v.Cb[ci], v.Cr[ci])
+			}
+		}
+	case *image.NRGBA:
+		for y := 0; y < h; y++ {
+			src := v.Pix[v.PixOffset(bounds.Min.X, bounds.Min.Y+y):]
+			dst := out.Pix[y*out.Stride:]
+			for x := 0; x < w; x++ {
+				r, g, b := premultiply(src[x*4+0], src[x*4+1], src[x*4+2], src[x*4+3])
+				dst[x*3+0], dst[x*3+1], dst[x*3+2] = uint8(r>>8), uint8(g>>8), uint8(b>>8)
+			}
+		}
+	case *image.RGBA:
+		for y := 0; y < h; y++ {
+			src := v.Pix[v.PixOffset(bounds.Min.X, bounds.Min.Y+y):]
+			dst := out.Pix[y*out.Stride:]
+			for x := 0; x < w; x++ {
+				copy(dst[x*3:x*3+3], src[x*4:x*4+3])
+			}
+		}
+	case *image.Gray:
+		for y := 0; y < h; y++ {
+			src := v.Pix[v.PixOffset(bounds.Min.X, bounds.Min.Y+y):]
+			dst := out.Pix[y*out.Stride:]
+			for x := 0; x < w; x++ {
+				dst[x*3+0], dst[x*3+1], dst[x*3+2] = src[x], src[x], src[x]
+			}
+		}
+	case *image.Paletted:
+		palette := make([]RGB, len(v.Palette))
+		for i, c := range v.Palette {
+			palette[i] = RGBModel.Convert(c).(RGB)
+		}
+		for y := 0; y < h; y++ {
+			src := v.Pix[v.PixOffset(bounds.Min.X, bounds.Min.Y+y):]
+			dst := out.Pix[y*out.Stride:]
+			for x := 0; x < w; x++ {
+				if int(src[x]) < len(palette) {
+					c := palette[src[x]]
+					dst[x*3+0], dst[x*3+1], dst[x*3+2] = c.R, c.G, c.B
+				}
+			}
+		}
+	default:
+		for y := 0; y < h; y++ {
+			dst := out.Pix[y*out.Stride:]
+			for x := 0; x < w; x++ {
+				c := RGBModel.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(RGB)
+				dst[x*3+0], dst[x*3+1], dst[x*3+2] = c.R, c.G, c.B
+			}
+		}
+	}
+	return out
+}
+
+// toNRGBA converts an image to an *image.NRGBA with its origin at (0, 0), keeping straight alpha
+func toNRGBA(img image.Image) *image.NRGBA {
+	bounds := img.Bounds()
+	if v, ok := img.(*image.NRGBA); ok && bounds.Min == (image.Point{}) {
+		return v
+	}
+	w, h := bounds.Dx(), bounds.Dy()
+	out := image.NewNRGBA(image.Rect(0, 0, w, h))
+
+	switch v := img.(type) {
+	case *image.Gray:
+		for y := 0; y < h; y++ {
+			src := v.Pix[v.PixOffset(bounds.Min.X, bounds.Min.Y+y):]
+			dst := out.Pix[y*out.Stride:]
+			for x := 0; x < w; x++ {
+				dst[x*4+0], dst[x*4+1], dst[x*4+2], dst[x*4+3] = src[x], src[x], src[x], 0xff
+			}
+		}
+	case *RGBImage:
+		for y := 0; y < h; y++ {
+			src := v.Pix[v.PixOffset(bounds.Min.X, bounds.Min.Y+y):]
+			dst := out.Pix[y*out.Stride:]
+			for x := 0; x < w; x++ {
+				copy(dst[x*4:x*4+3], src[x*3:x*3+3])
+				dst[x*4+3] = 0xff
+			}
+		}
+	case *image.YCbCr:
+		for y := 0; y < h; y++ {
+			dst := out.Pix[y*out.Stride:]
+			for x := 0; x < w; x++ {
+				yi := v.YOffset(bounds.Min.X+x, bounds.Min.Y+y)
+				ci := v.COffset(bounds.Min.X+x, bounds.Min.Y+y)
+				dst[x*4+0], dst[x*4+1], dst[x*4+2] = color.YCbCrToRGB(v.Y[yi], v.Cb[ci], v.Cr[ci])
+				dst[x*4+3] = 0xff
+			}
+		}
+	case *image.RGBA:
+		for y := 0; y < h; y++ {
+			src := v.Pix[v.PixOffset(bounds.Min.X, bounds.Min.Y+y):]
+			dst := out.Pix[y*out.Stride:]
+			for x := 0; x < w; x++ {
+				if src[x*4+3] == 0xff {
+					copy(dst[x*4:x*4+4], src[x*4:x*4+4])
+					continue
+				}
+				c := color.NRGBAModel.Convert(color.RGBA{src[x*4+0], src[x*4+1], src[x*4+2], src[x*4+3]}).(color.NRGBA)
+				dst[x*4+0], dst[x*4+1], dst[x*4+2], dst[x*4+3] = c.R, c.G, c.B, c.A
+			}
+		}
+	default:
+		draw.Draw(out, out.Rect, img, bounds.Min, draw.Src)
+	}
+	return out
+}
+
+// toNRGBA64 converts an image to an *image.NRGBA64 with its origin at (0, 0), keeping straight alpha
+func toNRGBA64(img image.Image) *image.NRGBA64 {
+	bounds := img.Bounds()
+	if v, ok := img.(*image.NRGBA64); ok && bounds.Min == (image.Point{}) {
+		return v
+	}
+	out := image.NewNRGBA64(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(out, out.Rect, img, bounds.Min, draw.Src)
+	return out
+}
+
+// isOpaque reports whether an image is known to be fully opaque
+func isOpaque(img image.Image) bool {
+	if v, ok := img.(interface{ Opaque() bool }); ok {
+		return v.Opaque()
+	}
+	return false
+}
+
+// expand widens an 8-bit sample to 16 bits
+func expand(v uint8) uint32 {
+	return uint32(v) * 0x101
+}
+
+// premultiply returns the 16-bit alpha premultiplied color of a straight alpha pixel, like color.NRGBA.RGBA
+func premultiply(r, g, b, a uint8) (uint32, uint32, uint32) {
+	r16, g16, b16 := expand(r), expand(g), expand(b)
+	if a == 0xff {
+		return r16, g16, b16
+	}
+	a16 := expand(a)
+	return r16 * a16 / 0xffff, g16 * a16 / 0xffff, b16 * a16 / 0xffff
+}
+
+// grayLevel computes the luminance of a 16-bit color with the same coefficients as color.GrayModel
+func grayLevel(r, g, b uint32) uint8 {
+	return uint8((19595*r + 38470*g + 7471*b + 1<<15) >> 24)
+}
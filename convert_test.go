@@ -0,0 +1,108 @@
+package imagecoding
+
+import (
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func makeConvertTestImages() map[string]image.Image {
+	rect := image.Rect(0, 0, 37, 23)
+	nrgba := image.NewNRGBA(rect)
+	for y := 0; y < rect.Dy(); y++ {
+		for x := 0; x < rect.Dx(); x++ {
+			nrgba.SetNRGBA(x, y, color.NRGBA{uint8(x * 7), uint8(y * 11), uint8(x * y), uint8(255 - x)})
+		}
+	}
+	rgba := image.NewRGBA(rect)
+	draw.Draw(rgba, rect, nrgba, image.Point{}, draw.Src)
+	paletted := image.NewPaletted(rect, palette.WebSafe)
+	draw.Draw(paletted, rect, nrgba, image.Point{}, draw.Src)
+	gray16 := image.NewGray16(rect)
+	draw.Draw(gray16, rect, nrgba, image.Point{}, draw.Src)
+	gray := image.NewGray(rect)
+	draw.Draw(gray, rect, nrgba, image.Point{}, draw.Src)
+
+	ycbcr := image.NewYCbCr(rect, image.YCbCrSubsampleRatio444)
+	for y := 0; y < rect.Dy(); y++ {
+		for x := 0; x < rect.Dx(); x++ {
+			yi, ci := ycbcr.YOffset(x, y), ycbcr.COffset(x, y)
+			ycbcr.Y[yi], ycbcr.Cb[ci], ycbcr.Cr[ci] = color.RGBToYCbCr(uint8(x*7), uint8(y*11), uint8(x*y))
+		}
+	}
+	cmyk := image.NewCMYK(rect)
+	draw.Draw(cmyk, rect, ycbcr, image.Point{}, draw.Src)
+
+	return map[string]image.Image{
+		"ycbcr":     ycbcr,
+		"cmyk":      cmyk,
+		"nrgba":     nrgba,
+		"rgba":      rgba,
+		"paletted":  paletted,
+		"gray16":    gray16,
+		"gray":      gray,
+		"subimage":  nrgba.SubImage(image.Rect(5, 3, 20, 17)),
+		"rgb-image": toRGB(rgba),
+	}
+}
+
+func TestToGray(t *testing.T) {
+	for name, src := range makeConvertTestImages() {
+		if _, ok := src.(*image.YCbCr); ok {
+			// Covered by TestToGrayYCbCr, the color math is not bit exact with the color models
+			continue
+		}
+		t.Run(name, func(t *testing.T) {
+			out := toGray(src)
+			b := src.Bounds()
+			assert.Equal(t, image.Rect(0, 0, b.Dx(), b.Dy()), out.Bounds())
+			for y := 0; y < b.Dy(); y++ {
+				for x := 0; x < b.Dx(); x++ {
+					expected := color.GrayModel.Convert(src.At(b.Min.X+x, b.Min.Y+y))
+					if !assert.Equal(t, expected, out.GrayAt(x, y), "pixel (%d, %d)", x, y) {
+						t.FailNow()
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestToRGB(t *testing.T) {
+	for name, src := range makeConvertTestImages() {
+		if _, ok := src.(*image.YCbCr); ok {
+			// Covered by TestToGrayYCbCr, the color math is not bit exact with the color models
+			continue
+		}
+		t.Run(name, func(t *testing.T) {
+			out := toRGB(src)
+			b := src.Bounds()
+			assert.Equal(t, image.Rect(0, 0, b.Dx(), b.Dy()), out.Bounds())
+			for y := 0; y < b.Dy(); y++ {
+				for x := 0; x < b.Dx(); x++ {
+					expected := RGBModel.Convert(src.At(b.Min.X+x, b.Min.Y+y))
+					if !assert.Equal(t, expected, RGBModel.Convert(out.At(x, y)), "pixel (%d, %d)", x, y) {
+						t.FailNow()
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestToGrayYCbCr(t *testing.T) {
+	src := image.NewYCbCr(image.Rect(0, 0, 16, 8), image.YCbCrSubsampleRatio420)
+	for i := range src.Y {
+		src.Y[i] = uint8(i)
+	}
+	out := toGray(src)
+	assert.Equal(t, src.Y, out.Pix)
+
+	rgb := toRGB(src)
+	r, g, b := color.YCbCrToRGB(src.Y[17], src.Cb[0], src.Cr[0])
+	assert.Equal(t, color.RGBA{r, g, b, 0xff}, rgb.RGBAAt(1, 1))
+}
@@ -0,0 +1,48 @@
+package imagecoding
+
+import (
+	"bytes"
+
+	"github.com/h2non/filetype"
+	"github.com/h2non/filetype/matchers/isobmff"
+)
+
+// Register the formats that filetype does not detect by itself, the extension is the ImgFormat
+func init() {
+	filetype.AddMatcher(filetype.NewType(string(Avif), "image/avif"), isAvif)
+	filetype.AddMatcher(filetype.NewType(string(Jxl), "image/jxl"), isJxl)
+	filetype.AddMatcher(filetype.NewType(string(J2k), "image/j2k"), isJ2k)
+}
+
+// isAvif matches the ISOBMFF brands of AVIF still images and image sequences
+func isAvif(buf []byte) bool {
+	if !isobmff.IsISOBMFF(buf) {
+		return false
+	}
+	majorBrand, _, compatibleBrands := isobmff.GetFtyp(buf)
+	if majorBrand == "avif" || majorBrand == "avis" {
+		return true
+	}
+	if majorBrand == "mif1" || majorBrand == "msf1" {
+		for _, brand := range compatibleBrands {
+			if brand == "avif" || brand == "avis" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// jxlContainer is the signature box that starts a JPEG XL container
+var jxlContainer = []byte{0x00, 0x00, 0x00, 0x0c, 'J', 'X', 'L', ' ', 0x0d, 0x0a, 0x87, 0x0a}
+
+// isJxl matches a bare JPEG XL codestream or the JPEG XL container
+func isJxl(buf []byte) bool {
+	return bytes.HasPrefix(buf, []byte{0xff, 0x0a}) || bytes.HasPrefix(buf, jxlContainer)
+}
+
+// isJ2k matches a bare JPEG 2000 codestream, the SOC marker followed by the SIZ marker.
+// filetype detects JP2 files by itself.
+func isJ2k(buf []byte) bool {
+	return bytes.HasPrefix(buf, []byte{0xff, 0x4f, 0xff, 0x51})
+}
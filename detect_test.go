@@ -0,0 +1,82 @@
+package imagecoding
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/h2non/filetype"
+	"github.com/stretchr/testify/assert"
+)
+
+// ftyp builds an ISOBMFF file type box
+func ftyp(major string, compatible ...string) []byte {
+	box := make([]byte, 16, 16+4*len(compatible))
+	binary.BigEndian.PutUint32(box, uint32(16+4*len(compatible)))
+	copy(box[4:], "ftyp")
+	copy(box[8:], major)
+	for _, brand := range compatible {
+		box = append(box, brand...)
+	}
+	return box
+}
+
+func TestDetectAvif(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     []byte
+		expected string
+	}{
+		{"avif", ftyp("avif", "mif1", "miaf"), string(Avif)},
+		{"avis", ftyp("avis", "msf1", "avif"), string(Avif)},
+		{"mif1", ftyp("mif1", "avif", "miaf"), string(Avif)},
+		{"heic", ftyp("heic", "mif1"), string(Heif)},
+		{"mp4", ftyp("isom", "iso2", "mp41"), "mp4"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kind, err := filetype.Match(tt.data)
+			if assert.NoError(t, err) {
+				assert.Equal(t, tt.expected, kind.Extension)
+			}
+		})
+	}
+}
+
+func TestDetectJxl(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     []byte
+		expected string
+	}{
+		{"codestream", []byte{0xff, 0x0a, 0xfa, 0x7f, 0x01, 0x90, 0x08}, string(Jxl)},
+		{"container", append(append([]byte(nil), jxlContainer...), ftyp("jxl ", "jxl ")...), string(Jxl)},
+		{"jpeg", []byte{0xff, 0xd8, 0xff, 0xe0}, string(Jpeg)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kind, err := filetype.Match(tt.data)
+			if assert.NoError(t, err) {
+				assert.Equal(t, tt.expected, kind.Extension)
+			}
+		})
+	}
+}
+
+func TestDetectJpeg2000(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     []byte
+		expected string
+	}{
+		{"jp2", append([]byte{0x00, 0x00, 0x00, 0x0c, 'j', 'P', ' ', ' ', 0x0d, 0x0a, 0x87, 0x0a}, ftyp("jp2 ", "jp2 ")...), string(Jp2)},
+		{"j2k", []byte{0xff, 0x4f, 0xff, 0x51, 0x00, 0x29, 0x00, 0x00}, string(J2k)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kind, err := filetype.Match(tt.data)
+			if assert.NoError(t, err) {
+				assert.Equal(t, tt.expected, kind.Extension)
+			}
+		})
+	}
+}
@@ -0,0 +1,193 @@
+package imagecoding
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/draw"
+	"image/gif"
+
+	"github.com/h2non/filetype"
+)
+
+// ErrFrameOutOfRange is returned when a frame index is negative or not less than the frame count
+var ErrFrameOutOfRange = errors.New("frame index out of range")
+
+// FrameCount returns the number of frames of an animation, GIF and WebP files can have several.
+// Every other image format has a single frame.
+func FrameCount(data []byte) (int, error) {
+	if len(data) == 0 {
+		return 0, ErrEmptyInput
+	}
+	kind, err := filetype.Match(data)
+	if err != nil {
+		return 0, errors.New("could not determine file type")
+	}
+
+	switch ImgFormat(kind.Extension) {
+	case Gif:
+		return gifFrameCount(data)
+	case Webp:
+		return webpFrameCount(data)
+	default:
+		return 1, nil
+	}
+}
+
+// gifFrameCount counts the image descriptors of a GIF file, skipping the LZW data of the frames
+// instead of decoding it
+func gifFrameCount(data []byte) (int, error) {
+	errInvalid := errors.New("invalid gif data")
+	// The header and the logical screen descriptor, followed by the global color table
+	if len(data) < 13 {
+		return 0, errInvalid
+	}
+	pos := 13
+	if data[10]&0x80 != 0 {
+		pos += 3 << (data[10]&0x07 + 1)
+	}
+	// skipBlocks skips data sub-blocks up to and including the terminating empty one
+	skipBlocks := func() bool {
+		for pos < len(data) {
+			size := int(data[pos])
+			pos += 1 + size
+			if size == 0 {
+				return true
+			}
+		}
+		return false
+	}
+
+	count := 0
+	for pos < len(data) {
+		switch data[pos] {
+		case 0x2c:
+			// Position, size and flags, then the local color table and the LZW code size
+			if pos+10 > len(data) {
+				return 0, errInvalid
+			}
+			flags := data[pos+9]
+			pos += 10
+			if flags&0x80 != 0 {
+				pos += 3 << (flags&0x07 + 1)
+			}
+			pos++
+			if !skipBlocks() {
+				return 0, errInvalid
+			}
+			count++
+		case 0x21:
+			// The extension label precedes the sub-blocks
+			pos += 2
+			if !skipBlocks() {
+				return 0, errInvalid
+			}
+		case 0x3b:
+			if count == 0 {
+				return 0, errors.New("gif has no frames")
+			}
+			return count, nil
+		default:
+			return 0, errInvalid
+		}
+	}
+	// Like gif.DecodeAll, a file must end with the trailer
+	return 0, errInvalid
+}
+
+// TransformFrame is like TransformWithOptions for the frame with the given zero based index.
+// A frame is the complete picture shown at that point of the animation, the frames before it
+// are blended and disposed of as the file prescribes. Frame 0 of a still image is the image.
+func TransformFrame(data []byte, index int, opts TransformOptions) (TransformResult, error) {
+	results, err := transformFrames(data, index, index, opts)
+	if err != nil {
+		return TransformResult{}, err
+	}
+	return results[0], nil
+}
+
+// TransformFrames transforms every frame of an animation, see TransformFrame
+func TransformFrames(data []byte, opts TransformOptions) ([]TransformResult, error) {
+	return transformFrames(data, 0, -1, opts)
+}
+
+// transformFrames transforms the frames from first to last, all frames when last is negative
+func transformFrames(data []byte, first, last int, opts TransformOptions) ([]TransformResult, error) {
+	if len(data) == 0 {
+		return nil, ErrEmptyInput
+	}
+	opts = opts.withDefaults()
+	kind, err := filetype.Match(data)
+	if err != nil {
+		return nil, errors.New("could not determine file type")
+	}
+
+	switch ImgFormat(kind.Extension) {
+	case Gif:
+		return transformGifFrames(data, first, last, opts)
+	case Webp:
+		return transformWebPFrames(data, first, last, opts)
+	default:
+		if first != 0 || last > 0 {
+			return nil, ErrFrameOutOfRange
+		}
+		res, err := TransformWithOptions(data, opts)
+		if err != nil {
+			return nil, err
+		}
+		return []TransformResult{res}, nil
+	}
+}
+
+// transformGifFrames composites the frames of a GIF file on its logical screen,
+// which starts out transparent like in browsers
+func transformGifFrames(data []byte, first, last int, opts TransformOptions) ([]TransformResult, error) {
+	// Check the limits before decoding the frames
+	conf, err := gif.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if err = opts.Limits.check(conf.Width, conf.Height); err != nil {
+		return nil, err
+	}
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	if last < 0 {
+		last = len(g.Image) - 1
+	}
+	if first < 0 || last >= len(g.Image) {
+		return nil, ErrFrameOutOfRange
+	}
+
+	screen := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	canvas := image.NewRGBA(screen)
+	var results []TransformResult
+	for i := 0; i <= last; i++ {
+		frame := g.Image[i]
+		var disposal byte
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+		var previous *image.RGBA
+		if disposal == gif.DisposalPrevious {
+			previous = image.NewRGBA(screen)
+			copy(previous.Pix, canvas.Pix)
+		}
+
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		if i >= first {
+			results = append(results, finishTransform(canvas, Gif, TopLeft, nil, opts))
+		}
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+	return results, nil
+}
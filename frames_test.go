@@ -0,0 +1,289 @@
+package imagecoding
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/gif"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	red   = color.RGBA{0xff, 0, 0, 0xff}
+	green = color.RGBA{0, 0xff, 0, 0xff}
+	blue  = color.RGBA{0, 0, 0xff, 0xff}
+	black = color.RGBA{0, 0, 0, 0xff}
+	white = color.RGBA{0xff, 0xff, 0xff, 0xff}
+)
+
+// makeGifFrame returns a frame filled with c
+func makeGifFrame(r image.Rectangle, c color.Color) *image.Paletted {
+	img := image.NewPaletted(r, palette.Plan9)
+	idx := uint8(img.Palette.Index(c))
+	for i := range img.Pix {
+		img.Pix[i] = idx
+	}
+	return img
+}
+
+// webpFrame is a frame of makeAnimatedWebP, offsets must be even
+type webpFrame struct {
+	img     *image.NRGBA
+	offset  image.Point
+	noBlend bool
+	dispose bool
+}
+
+// makeAnimatedWebP wraps lossless WebP frames in the chunks of an animation
+func makeAnimatedWebP(t *testing.T, width, height int, frames []webpFrame) []byte {
+	put24 := func(buf *bytes.Buffer, v int) {
+		buf.Write([]byte{byte(v), byte(v >> 8), byte(v >> 16)})
+	}
+	var body bytes.Buffer
+	body.WriteString("VP8X")
+	binary.Write(&body, binary.LittleEndian, uint32(10))
+	body.Write([]byte{0x12, 0, 0, 0}) // Animation and alpha
+	put24(&body, width-1)
+	put24(&body, height-1)
+	body.WriteString("ANIM")
+	binary.Write(&body, binary.LittleEndian, uint32(6))
+	body.Write([]byte{0, 0, 0, 0, 0, 0}) // Transparent background, loop forever
+
+	for _, f := range frames {
+		var buf bytes.Buffer
+		data, err := EncodeWebP(&buf, f.img)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		// The VP8L chunk follows the RIFF header
+		chunk := data[12:]
+		var flags byte
+		if f.noBlend {
+			flags |= 0x02
+		}
+		if f.dispose {
+			flags |= 0x01
+		}
+		body.WriteString("ANMF")
+		binary.Write(&body, binary.LittleEndian, uint32(16+len(chunk)))
+		put24(&body, f.offset.X/2)
+		put24(&body, f.offset.Y/2)
+		put24(&body, f.img.Rect.Dx()-1)
+		put24(&body, f.img.Rect.Dy()-1)
+		put24(&body, 100)
+		body.WriteByte(flags)
+		body.Write(chunk)
+	}
+
+	var out bytes.Buffer
+	out.WriteString("RIFF")
+	binary.Write(&out, binary.LittleEndian, uint32(4+body.Len()))
+	out.WriteString("WEBP")
+	out.Write(body.Bytes())
+	return out.Bytes()
+}
+
+// makeFilled returns an image filled with c
+func makeFilled(w, h int, c color.Color) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+type framePixel struct {
+	frame int
+	x, y  int
+	c     color.RGBA
+}
+
+func assertFramePixels(t *testing.T, results []TransformResult, pixels []framePixel) {
+	for _, p := range pixels {
+		rgb := results[p.frame].Image.(*RGBImage)
+		assert.Equal(t, p.c, rgb.RGBAAt(p.x, p.y), "frame %d at %d,%d", p.frame, p.x, p.y)
+	}
+}
+
+func TestGifFrames(t *testing.T) {
+	g := &gif.GIF{
+		Image: []*image.Paletted{
+			makeGifFrame(image.Rect(0, 0, 4, 4), red),
+			makeGifFrame(image.Rect(0, 0, 2, 2), blue),
+			makeGifFrame(image.Rect(2, 2, 4, 4), green),
+			makeGifFrame(image.Rect(3, 0, 4, 1), white),
+		},
+		Delay:    []int{10, 10, 10, 10},
+		Disposal: []byte{gif.DisposalNone, gif.DisposalBackground, gif.DisposalPrevious, gif.DisposalNone},
+		Config:   image.Config{Width: 4, Height: 4},
+	}
+	var buf bytes.Buffer
+	if !assert.NoError(t, gif.EncodeAll(&buf, g)) {
+		t.FailNow()
+	}
+	data := buf.Bytes()
+
+	count, err := FrameCount(data)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 4, count)
+	}
+
+	results, err := TransformFrames(data, TransformOptions{})
+	if !assert.NoError(t, err) || !assert.Len(t, results, 4) {
+		t.FailNow()
+	}
+	for _, res := range results {
+		assert.Equal(t, Gif, res.Format)
+		assert.Equal(t, 4, res.Width)
+		assert.Equal(t, 4, res.Height)
+	}
+	assertFramePixels(t, results, []framePixel{
+		{0, 0, 0, red},
+		{1, 1, 1, blue},
+		{1, 3, 3, red},
+		// The background disposal clears the blue square
+		{2, 0, 0, black},
+		{2, 3, 3, green},
+		{2, 3, 0, red},
+		// The previous disposal restores red under the green square
+		{3, 3, 3, red},
+		{3, 0, 0, black},
+		{3, 3, 0, white},
+	})
+
+	res, err := TransformFrame(data, 2, TransformOptions{})
+	if assert.NoError(t, err) {
+		assert.Equal(t, results[2].Image, res.Image)
+	}
+	_, err = TransformFrame(data, 4, TransformOptions{})
+	assert.Equal(t, ErrFrameOutOfRange, err)
+}
+
+func TestGifFrameCount(t *testing.T) {
+	sample, err := os.ReadFile("testdata/rose_grey.gif")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	g, err := gif.DecodeAll(bytes.NewReader(sample))
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	count, err := FrameCount(sample)
+	if assert.NoError(t, err) {
+		assert.Equal(t, len(g.Image), count)
+	}
+
+	// Local color tables and extensions are skipped
+	anim := &gif.GIF{
+		Image: []*image.Paletted{
+			makeGifFrame(image.Rect(0, 0, 4, 4), red),
+			makeGifFrame(image.Rect(0, 0, 2, 2), blue),
+		},
+		Delay:     []int{10, 10},
+		LoopCount: 3,
+	}
+	anim.Image[1].Palette = palette.WebSafe
+	var buf bytes.Buffer
+	if !assert.NoError(t, gif.EncodeAll(&buf, anim)) {
+		t.FailNow()
+	}
+	count, err = FrameCount(buf.Bytes())
+	if assert.NoError(t, err) {
+		assert.Equal(t, 2, count)
+	}
+
+	// Truncated frames and files without frames are invalid
+	_, err = FrameCount(buf.Bytes()[:buf.Len()-10])
+	assert.Error(t, err)
+	_, err = FrameCount(append(append([]byte{}, buf.Bytes()[:13+3*256]...), 0x3b))
+	assert.Error(t, err)
+}
+
+func TestWebPFrames(t *testing.T) {
+	// The left column of the second frame is blue, the right one transparent
+	half := makeFilled(2, 2, color.Transparent)
+	half.Set(0, 0, blue)
+	half.Set(0, 1, blue)
+	data := makeAnimatedWebP(t, 4, 4, []webpFrame{
+		{img: makeFilled(4, 4, red)},
+		{img: half, offset: image.Pt(2, 2), dispose: true},
+		{img: makeFilled(2, 2, green), noBlend: true},
+	})
+
+	count, err := FrameCount(data)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 3, count)
+	}
+
+	results, err := TransformFrames(data, TransformOptions{})
+	if !assert.NoError(t, err) || !assert.Len(t, results, 3) {
+		t.FailNow()
+	}
+	for _, res := range results {
+		assert.Equal(t, Webp, res.Format)
+		assert.Equal(t, 4, res.Width)
+		assert.Equal(t, 4, res.Height)
+	}
+	assertFramePixels(t, results, []framePixel{
+		{0, 3, 3, red},
+		// The transparent pixels blend with the canvas
+		{1, 2, 2, blue},
+		{1, 3, 2, red},
+		// The second frame is disposed of to transparent
+		{2, 2, 2, black},
+		{2, 3, 3, black},
+		{2, 0, 0, green},
+		{2, 3, 0, red},
+	})
+
+	// The first frame of an animation is the image
+	res, err := TransformWithOptions(data, TransformOptions{})
+	if assert.NoError(t, err) {
+		assert.Equal(t, results[0].Image, res.Image)
+	}
+	_, err = TransformFrame(data, -1, TransformOptions{})
+	assert.Equal(t, ErrFrameOutOfRange, err)
+
+	// The canvas is checked against the limits before it is allocated
+	huge := makeAnimatedWebP(t, 1<<15, 1<<15, []webpFrame{{img: makeFilled(4, 4, red)}})
+	count, err = FrameCount(huge)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 1, count)
+	}
+	_, err = TransformFrames(huge, TransformOptions{Limits: Limits{MaxPixels: 1 << 20}})
+	assert.Equal(t, ErrImageTooLarge, err)
+}
+
+func TestFrameCountStill(t *testing.T) {
+	var buf bytes.Buffer
+	data, err := EncodeWebP(&buf, makeFilled(4, 4, red))
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	count, err := FrameCount(data)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 1, count)
+	}
+	res, err := TransformFrame(data, 0, TransformOptions{})
+	if assert.NoError(t, err) {
+		assert.Equal(t, red, res.Image.(*RGBImage).RGBAAt(1, 1))
+	}
+
+	data, err = EncodePng(&buf, makeMarkedPage(4, 4))
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	count, err = FrameCount(data)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 1, count)
+	}
+	_, err = TransformFrame(data, 1, TransformOptions{})
+	assert.Equal(t, ErrFrameOutOfRange, err)
+}
@@ -6,7 +6,6 @@ require (
 	github.com/Nr90/imgsim v0.0.0-20180202144352-5caa057144b0
 	github.com/disintegration/imaging v1.6.2
 	github.com/h2non/filetype v1.1.3
-	github.com/kolesa-team/go-webp v1.0.4
 	github.com/rwcarlsen/goexif v0.0.0-20190401172101-9e8deecbddbd
 	github.com/stretchr/testify v1.8.4
 	github.com/strukturag/libheif v1.11.0
@@ -4,28 +4,36 @@
 package imagecoding
 
 import (
+	"bytes"
+	"fmt"
 	"image"
 	"image/color"
 	"runtime"
 
+	"github.com/h2non/filetype"
 	"github.com/strukturag/libheif/go/heif"
 )
 
 func ConfigHeif(data []byte) (image.Config, string, error) {
+	return configHeifFile(data, Heif)
+}
+
+// configHeifFile reads the size of the primary image of any file that libheif can open
+func configHeifFile(data []byte, format ImgFormat) (image.Config, string, error) {
 	if len(data) == 0 {
-		return image.Config{}, string(Heif), ErrEmptyInput
+		return image.Config{}, string(format), ErrEmptyInput
 	}
 	ctx, err := heif.NewContext()
 	if err != nil {
-		return image.Config{}, string(Heif), err
+		return image.Config{}, string(format), err
 	}
 	err = ctx.ReadFromMemory(data)
 	if err != nil {
-		return image.Config{}, string(Heif), err
+		return image.Config{}, string(format), err
 	}
 	img, err := ctx.GetPrimaryImageHandle()
 	if err != nil {
-		return image.Config{}, string(Heif), err
+		return image.Config{}, string(format), err
 	}
 	cfg := image.Config{
 		ColorModel: color.YCbCrModel,
@@ -33,7 +41,7 @@ func ConfigHeif(data []byte) (image.Config, string, error) {
 		Height:     img.GetHeight(),
 	}
 	runtime.KeepAlive(ctx)
-	return cfg, string(Heif), nil
+	return cfg, string(format), nil
 }
 
 func DecodeHeif(data []byte) (image.Image, error) {
@@ -57,7 +65,7 @@ func DecodeHeif(data []byte) (image.Image, error) {
 	if err != nil {
 		return nil, err
 	}
-	goimg, err := img.GetImage()
+	goimg, err := heifGoImage(img)
 	if err != nil {
 		return nil, err
 	}
@@ -65,61 +73,297 @@ func DecodeHeif(data []byte) (image.Image, error) {
 }
 
 func TransformHeif(data []byte, grayscale bool, scale ScaleFunc) (out image.Image, width, height int, scaleFactor float64, err error) {
+	res, err := TransformHeifWithOptions(data, legacyOptions(grayscale, scale))
+	if err != nil {
+		return nil, 0, 0, 0, err
+	}
+	return res.Image, res.Width, res.Height, res.ScaleFactor, nil
+}
+
+// TransformHeifWithOptions is like TransformHeif but configured through TransformOptions
+func TransformHeifWithOptions(data []byte, opts TransformOptions) (TransformResult, error) {
+	return transformHeifFile(data, opts, Heif)
+}
+
+// transformHeifFile transforms the primary image of any file that libheif can open
+func transformHeifFile(data []byte, opts TransformOptions, format ImgFormat) (TransformResult, error) {
+	opts = opts.withDefaults()
+	ctx, err := openHeif(data)
+	if err != nil {
+		return TransformResult{}, err
+	}
+	id, err := ctx.GetPrimaryImageID()
+	if err != nil {
+		return TransformResult{}, err
+	}
+	imgh, err := ctx.GetImageHandle(id)
+	if err != nil {
+		return TransformResult{}, err
+	}
+	res, err := transformHeifHandle(data, imgh, id, opts, format, false)
+	runtime.KeepAlive(ctx)
+	return res, err
+}
+
+// HeifImages lists the top-level images of a HEIF or AVIF file with their thumbnails
+func HeifImages(data []byte) ([]HeifImage, error) {
+	ctx, err := openHeif(data)
+	if err != nil {
+		return nil, err
+	}
+	ids := ctx.GetListOfTopLevelImageIDs()
+	images := make([]HeifImage, 0, len(ids))
+	for _, id := range ids {
+		imgh, err := ctx.GetImageHandle(id)
+		if err != nil {
+			return nil, err
+		}
+		info := HeifImage{
+			ID:       id,
+			Width:    imgh.GetWidth(),
+			Height:   imgh.GetHeight(),
+			Primary:  imgh.IsPrimaryImage(),
+			HasAlpha: imgh.HasAlphaChannel(),
+			HasDepth: imgh.HasDepthImage(),
+		}
+		for _, thumbID := range imgh.GetListOfThumbnailIDs() {
+			thumb, err := imgh.GetThumbnail(thumbID)
+			if err != nil {
+				return nil, err
+			}
+			info.Thumbnails = append(info.Thumbnails, HeifThumbnail{ID: thumbID, Width: thumb.GetWidth(), Height: thumb.GetHeight()})
+		}
+		for _, depthID := range imgh.GetListOfDepthImageIDs() {
+			depth, err := imgh.GetDepthImageHandle(depthID)
+			if err != nil {
+				return nil, err
+			}
+			info.DepthImages = append(info.DepthImages, HeifDepthImage{ID: depthID, Width: depth.GetWidth(), Height: depth.GetHeight()})
+		}
+		images = append(images, info)
+	}
+	runtime.KeepAlive(ctx)
+	return images, nil
+}
+
+// TransformHeifImage is like TransformHeifWithOptions for the top-level image with the given ID,
+// see HeifImages
+func TransformHeifImage(data []byte, id int, opts TransformOptions) (TransformResult, error) {
+	opts = opts.withDefaults()
+	ctx, err := openHeif(data)
+	if err != nil {
+		return TransformResult{}, err
+	}
+	if !ctx.IsTopLevelImageID(id) {
+		return TransformResult{}, ErrHeifImageNotFound
+	}
+	imgh, err := ctx.GetImageHandle(id)
+	if err != nil {
+		return TransformResult{}, err
+	}
+	res, err := transformHeifHandle(data, imgh, id, opts, heifFormat(data), false)
+	runtime.KeepAlive(ctx)
+	return res, err
+}
+
+// TransformHeifDepth is like TransformHeifImage for the depth map with the given ID of the top-level
+// image id, see HeifImages. The map decodes to a gray image.
+func TransformHeifDepth(data []byte, id, depthID int, opts TransformOptions) (TransformResult, error) {
+	opts = opts.withDefaults()
+	ctx, err := openHeif(data)
+	if err != nil {
+		return TransformResult{}, err
+	}
+	if !ctx.IsTopLevelImageID(id) {
+		return TransformResult{}, ErrHeifImageNotFound
+	}
+	imgh, err := ctx.GetImageHandle(id)
+	if err != nil {
+		return TransformResult{}, err
+	}
+	found := false
+	for _, d := range imgh.GetListOfDepthImageIDs() {
+		found = found || d == depthID
+	}
+	if !found {
+		return TransformResult{}, ErrHeifImageNotFound
+	}
+	depth, err := imgh.GetDepthImageHandle(depthID)
+	if err != nil {
+		return TransformResult{}, err
+	}
+	res, err := transformHeifHandle(data, depth, depthID, opts, heifFormat(data), false)
+	runtime.KeepAlive(ctx)
+	return res, err
+}
+
+// TransformHeifPreview is like TransformHeifWithOptions, but decodes the smallest embedded thumbnail
+// of the primary image that is at least as large as the scaled output instead of the full image.
+// Without such a thumbnail the full image is decoded. Width, Height and the mapping in the result
+// still refer to the full image.
+func TransformHeifPreview(data []byte, opts TransformOptions) (TransformResult, error) {
+	opts = opts.withDefaults()
+	ctx, err := openHeif(data)
+	if err != nil {
+		return TransformResult{}, err
+	}
+	id, err := ctx.GetPrimaryImageID()
+	if err != nil {
+		return TransformResult{}, err
+	}
+	imgh, err := ctx.GetImageHandle(id)
+	if err != nil {
+		return TransformResult{}, err
+	}
+	res, err := transformHeifHandle(data, imgh, id, opts, heifFormat(data), true)
+	runtime.KeepAlive(ctx)
+	return res, err
+}
+
+// openHeif reads a file into a new libheif context
+func openHeif(data []byte) (*heif.Context, error) {
 	if len(data) == 0 {
-		return nil, 0, 0, 0, ErrEmptyInput
+		return nil, ErrEmptyInput
 	}
 	ctx, err := heif.NewContext()
 	if err != nil {
-		return nil, 0, 0, 0, err
+		return nil, err
 	}
-	err = ctx.ReadFromMemory(data)
-	if err != nil {
-		return nil, 0, 0, 0, err
+	if err = ctx.ReadFromMemory(data); err != nil {
+		return nil, err
 	}
-	imgh, err := ctx.GetPrimaryImageHandle()
+	return ctx, nil
+}
+
+// heifFormat tells AVIF files apart from the other files that libheif opens
+func heifFormat(data []byte) ImgFormat {
+	if kind, err := filetype.Match(data); err == nil && ImgFormat(kind.Extension) == Avif {
+		return Avif
+	}
+	return Heif
+}
+
+// transformHeifHandle decodes, scales and colormaps the image item with the given ID,
+// from a large enough thumbnail if thumbnails is set
+func transformHeifHandle(data []byte, imgh *heif.ImageHandle, id int, opts TransformOptions, format ImgFormat, thumbnails bool) (TransformResult, error) {
+	var warnings []string
+	info, err := parseHeifItem(data, id)
 	if err != nil {
-		return nil, 0, 0, 0, err
+		warnings = append(warnings, fmt.Sprintf("could not read heif item properties: %v", err))
+		info = heifItemInfo{orientation: TopLeft}
+	}
+
+	// libheif applies irot and imir, an EXIF orientation only counts without them
+	orient, fix := info.orientation, TopLeft
+	if !info.transformed && opts.Orientation == OrientationAuto && len(info.exif) > 0 {
+		fix = GetOrientation(bytes.NewReader(info.exif))
+		orient = fix
 	}
 
-	width = imgh.GetWidth()
-	height = imgh.GetHeight()
+	width, height := imgh.GetWidth(), imgh.GetHeight()
+	if err := opts.Limits.check(width, height); err != nil {
+		return TransformResult{}, err
+	}
+	if fix >= LeftTop && fix <= LeftBottom {
+		width, height = height, width
+	}
 
 	// Calculate scaling factor
-	scaledW, scaledH, scaleFactor := scale(width, height)
+	scaledW, scaledH, scaleFactor := opts.Scale(width, height)
+	if !opts.resizes(scaleFactor) {
+		scaledW, scaledH, scaleFactor = width, height, 1
+	}
+	// The size to decode before the EXIF orientation is applied
+	decodeW, decodeH := scaledW, scaledH
+	if fix >= LeftTop && fix <= LeftBottom {
+		decodeW, decodeH = scaledH, scaledW
+	}
 
-	var img *heif.Image
-	img, err = imgh.DecodeImage(heif.ColorspaceUndefined, heif.ChromaUndefined, nil)
-	runtime.KeepAlive(ctx)
+	source := imgh
+	if thumbnails && scaleFactor < 1 {
+		source = heifThumbnail(imgh, decodeW, decodeH)
+	}
+	img, err := source.DecodeImage(heif.ColorspaceUndefined, heif.ChromaUndefined, nil)
 	if err != nil {
-		return nil, 0, 0, 0, err
+		return TransformResult{}, err
 	}
 
-	// Scale if required
-	if scaleFactor > 1.1 || scaleFactor < 0.9 {
-		img, err = img.ScaleImage(scaledW, scaledH)
-		if err != nil {
-			return nil, 0, 0, 0, err
+	goimg, err := heifGoImage(img)
+	if err != nil {
+		return TransformResult{}, err
+	}
+	colorModel := goimg.ColorModel()
+	// Convert the embedded color profile to sRGB on request, gray images are left as they are
+	var transform *colorTransform
+	if _, gray := goimg.(*image.Gray); !gray && opts.ConvertToSRGB {
+		if transform, err = info.colorTransform(); err != nil {
+			warnings = append(warnings, fmt.Sprintf("could not convert heif colors to sRGB: %v", err))
 		}
-	} else {
-		scaleFactor = 1
+	}
+	// Gray output only needs the luma plane, unless the colors are converted first
+	if v, ok := goimg.(*image.YCbCr); ok && opts.ColorMode == ColorModeGray && transform == nil {
+		goimg = toGray(v)
+	}
+	// Scale if required, on the decoded planes before any color conversion
+	if source.GetWidth() != decodeW || source.GetHeight() != decodeH {
+		goimg = resizePlanes(goimg, decodeW, decodeH, opts.Filter)
+	}
+	result := TransformResult{
+		Format:      format,
+		Width:       width,
+		Height:      height,
+		ScaleFactor: scaleFactor,
+		Orientation: orient,
+		ColorModel:  colorModel,
 	}
 
-	goimg, err := img.GetImage()
-	if err != nil {
-		return nil, 0, 0, 0, err
+	if transform != nil {
+		goimg = transform.apply(toRGB(goimg))
 	}
+	goimg = FixOrientation(goimg, fix)
 
 	// libheif does not support conversion from YUV/RGB -> Gray Scale
-	if grayscale {
+	if opts.ColorMode == ColorModeGray {
 		// Drop the channels we don't need by converting to image.Gray
-		bounds := goimg.Bounds()
-		imgGray := image.NewGray(bounds)
-		for y := 0; y < bounds.Max.Y; y++ {
-			for x := 0; x < bounds.Max.X; x++ {
-				imgGray.Set(x, y, color.GrayModel.Convert(goimg.At(x, y)))
-			}
+		goimg = toGray(goimg)
+	} else {
+		goimg = toRGB(goimg)
+	}
+	result.Warnings = warnings
+	result.setImage(goimg)
+	result.setMapping(result.ScaleX, result.ScaleY)
+	return result, nil
+}
+
+// heifThumbnail picks the smallest thumbnail of imgh that covers width x height pixels,
+// or imgh itself when there is none
+func heifThumbnail(imgh *heif.ImageHandle, width, height int) *heif.ImageHandle {
+	best := imgh
+	for _, id := range imgh.GetListOfThumbnailIDs() {
+		thumb, err := imgh.GetThumbnail(id)
+		if err != nil {
+			continue
+		}
+		if thumb.GetWidth() >= width && thumb.GetHeight() >= height && thumb.GetWidth() < best.GetWidth() {
+			best = thumb
 		}
-		goimg = imgGray
 	}
-	return goimg, width, height, scaleFactor, nil
+	return best
+}
+
+// heifGoImage wraps the decoded planes in a Go image, adding the monochrome images
+// that the libheif bindings can not convert
+func heifGoImage(img *heif.Image) (image.Image, error) {
+	if img.GetColorspace() != heif.ColorspaceMonochrome || img.GetBitsPerPixelRange(heif.ChannelY) != 8 {
+		return img.GetImage()
+	}
+	y, err := img.GetPlane(heif.ChannelY)
+	if err != nil {
+		return nil, err
+	}
+	return &image.Gray{
+		Pix:    y.Plane,
+		Stride: y.Stride,
+		Rect:   image.Rect(0, 0, img.GetWidth(heif.ChannelY), img.GetHeight(heif.ChannelY)),
+	}, nil
 }
@@ -0,0 +1,418 @@
+package imagecoding
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// The libheif bindings do not expose the transformations, color profiles and Exif metadata of an item,
+// so they are read from the ISOBMFF boxes of the file
+
+var errHeifBoxes = errors.New("invalid heif boxes")
+
+// heifNCLX is the nclx color description of a colr property
+type heifNCLX struct {
+	primaries, transfer, matrix uint16
+	fullRange                   bool
+}
+
+// heifItemInfo holds the properties of an image item
+type heifItemInfo struct {
+	// orientation combines the irot and imir properties as an EXIF orientation
+	orientation Orientation
+	// transformed is set when the item has irot or imir properties
+	transformed bool
+	// icc is the ICC profile of a prof or rICC colr property
+	icc []byte
+	// nclx is the nclx colr property
+	nclx *heifNCLX
+	// exif is the Exif metadata of the item, starting at the TIFF header
+	exif []byte
+}
+
+// colorTransform returns the conversion of the item colors to sRGB, nil when they are sRGB already.
+// An ICC profile takes precedence over nclx.
+func (i heifItemInfo) colorTransform() (*colorTransform, error) {
+	var t *colorTransform
+	var err error
+	switch {
+	case i.icc != nil:
+		t, err = iccColorTransform(i.icc)
+	case i.nclx != nil:
+		t, err = nclxColorTransform(i.nclx.primaries, i.nclx.transfer)
+	default:
+		return nil, nil
+	}
+	if err != nil || t.identity() {
+		return nil, err
+	}
+	return t, nil
+}
+
+// heifBox is an ISOBMFF box, payload excludes the header
+type heifBox struct {
+	typ     string
+	payload []byte
+}
+
+// heifBoxes splits data into boxes
+func heifBoxes(data []byte) ([]heifBox, error) {
+	var boxes []heifBox
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return nil, errHeifBoxes
+		}
+		size := uint64(binary.BigEndian.Uint32(data))
+		typ := string(data[4:8])
+		header := uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return nil, errHeifBoxes
+			}
+			size = binary.BigEndian.Uint64(data[8:])
+			header = 16
+		}
+		if size < header || size > uint64(len(data)) {
+			return nil, errHeifBoxes
+		}
+		boxes = append(boxes, heifBox{typ: typ, payload: data[header:size]})
+		data = data[size:]
+	}
+	return boxes, nil
+}
+
+// findHeifBox returns the payload of the first box of the given type
+func findHeifBox(boxes []heifBox, typ string) []byte {
+	for _, b := range boxes {
+		if b.typ == typ {
+			return b.payload
+		}
+	}
+	return nil
+}
+
+// heifReader reads the big endian fields of a box, running past the end sets err
+type heifReader struct {
+	data []byte
+	err  error
+}
+
+func (r *heifReader) uint(n int) uint64 {
+	if n == 0 {
+		return 0
+	}
+	if r.err != nil || len(r.data) < n {
+		r.err = errHeifBoxes
+		return 0
+	}
+	var v uint64
+	for _, b := range r.data[:n] {
+		v = v<<8 | uint64(b)
+	}
+	r.data = r.data[n:]
+	return v
+}
+
+// id reads an item ID, 16 bits wide in version 0 boxes and 32 bits in later versions
+func (r *heifReader) id(version uint64) uint32 {
+	if version == 0 {
+		return uint32(r.uint(2))
+	}
+	return uint32(r.uint(4))
+}
+
+// heifMeta returns the boxes of the meta box of a file
+func heifMeta(data []byte) ([]heifBox, error) {
+	boxes, err := heifBoxes(data)
+	if err != nil {
+		return nil, err
+	}
+	meta := findHeifBox(boxes, "meta")
+	if len(meta) < 4 {
+		return nil, errHeifBoxes
+	}
+	// meta is a full box, skip version and flags
+	return heifBoxes(meta[4:])
+}
+
+// heifPrimaryItem returns the ID of the primary image item
+func heifPrimaryItem(data []byte) (int, error) {
+	meta, err := heifMeta(data)
+	if err != nil {
+		return 0, err
+	}
+	r := heifReader{data: findHeifBox(meta, "pitm")}
+	version := r.uint(1)
+	r.uint(3)
+	id := r.id(version)
+	return int(id), r.err
+}
+
+// parseHeifItem reads the properties of the item with the given ID and the Exif metadata describing it
+func parseHeifItem(data []byte, itemID int) (heifItemInfo, error) {
+	info := heifItemInfo{orientation: TopLeft}
+	children, err := heifMeta(data)
+	if err != nil {
+		return info, err
+	}
+
+	props, err := heifItemProperties(findHeifBox(children, "iprp"), uint32(itemID))
+	if err != nil {
+		return info, err
+	}
+	transform := IdentityAffine()
+	for _, p := range props {
+		switch p.typ {
+		case "irot":
+			if len(p.payload) < 1 {
+				return info, errHeifBoxes
+			}
+			// irot rotates anti-clockwise in steps of 90 degrees
+			rotations := map[byte]Orientation{0: TopLeft, 1: LeftBottom, 2: BottomRight, 3: RightTop}
+			transform = transform.Then(orientationAffine(rotations[p.payload[0]&3], 1, 1))
+			info.transformed = true
+		case "imir":
+			if len(p.payload) < 1 {
+				return info, errHeifBoxes
+			}
+			// Axis 0 flips top and bottom, axis 1 left and right, as libheif reads it
+			mirror := BottomLeft
+			if p.payload[0]&1 == 1 {
+				mirror = TopRight
+			}
+			transform = transform.Then(orientationAffine(mirror, 1, 1))
+			info.transformed = true
+		case "colr":
+			if len(p.payload) < 4 {
+				return info, errHeifBoxes
+			}
+			switch string(p.payload[:4]) {
+			case "nclx":
+				r := heifReader{data: p.payload[4:]}
+				nclx := heifNCLX{primaries: uint16(r.uint(2)), transfer: uint16(r.uint(2)), matrix: uint16(r.uint(2))}
+				nclx.fullRange = r.uint(1)&0x80 != 0
+				if r.err != nil {
+					return info, r.err
+				}
+				info.nclx = &nclx
+			case "prof", "rICC":
+				info.icc = p.payload[4:]
+			}
+		}
+	}
+	// The transformations of a unit square only match the affine of a single orientation
+	for o := TopLeft; o <= LeftBottom; o++ {
+		if orientationAffine(o, 1, 1) == transform {
+			info.orientation = o
+		}
+	}
+
+	info.exif, err = heifExif(data, children, uint32(itemID))
+	return info, err
+}
+
+// heifItemProperties returns the properties associated with an item in the order they apply
+func heifItemProperties(iprp []byte, itemID uint32) ([]heifBox, error) {
+	if iprp == nil {
+		return nil, nil
+	}
+	boxes, err := heifBoxes(iprp)
+	if err != nil {
+		return nil, err
+	}
+	ipco, err := heifBoxes(findHeifBox(boxes, "ipco"))
+	if err != nil {
+		return nil, err
+	}
+
+	var props []heifBox
+	for _, b := range boxes {
+		if b.typ != "ipma" {
+			continue
+		}
+		r := heifReader{data: b.payload}
+		version := r.uint(1)
+		flags := r.uint(3)
+		count := r.uint(4)
+		for i := uint64(0); i < count && r.err == nil; i++ {
+			id := r.id(version)
+			associations := r.uint(1)
+			for j := uint64(0); j < associations; j++ {
+				// The top bit flags essential properties, indices start at 1
+				var index uint64
+				if flags&1 != 0 {
+					index = r.uint(2) & 0x7fff
+				} else {
+					index = r.uint(1) & 0x7f
+				}
+				if id == itemID && index > 0 && index <= uint64(len(ipco)) {
+					props = append(props, ipco[index-1])
+				}
+			}
+		}
+		if r.err != nil {
+			return nil, r.err
+		}
+	}
+	return props, nil
+}
+
+// heifExif returns the Exif metadata item that describes the given item, starting at the TIFF header
+func heifExif(data []byte, meta []heifBox, itemID uint32) ([]byte, error) {
+	exifID, ok, err := heifExifItem(meta, itemID)
+	if err != nil || !ok {
+		return nil, err
+	}
+	payload, err := heifItemData(data, meta, exifID)
+	if err != nil {
+		return nil, err
+	}
+	// The payload starts with the offset of the TIFF header
+	if len(payload) < 4 {
+		return nil, errHeifBoxes
+	}
+	offset := uint64(binary.BigEndian.Uint32(payload)) + 4
+	if offset > uint64(len(payload)) {
+		return nil, errHeifBoxes
+	}
+	return payload[offset:], nil
+}
+
+// heifExifItem finds the Exif item with a cdsc reference to the given item
+func heifExifItem(meta []heifBox, itemID uint32) (uint32, bool, error) {
+	iinf := findHeifBox(meta, "iinf")
+	iref := findHeifBox(meta, "iref")
+	if iinf == nil || iref == nil {
+		return 0, false, nil
+	}
+
+	exifItems := map[uint32]bool{}
+	r := heifReader{data: iinf}
+	version := r.uint(1)
+	r.uint(3)
+	r.id(version)
+	if r.err != nil {
+		return 0, false, r.err
+	}
+	entries, err := heifBoxes(r.data)
+	if err != nil {
+		return 0, false, err
+	}
+	for _, e := range entries {
+		er := heifReader{data: e.payload}
+		infeVersion := er.uint(1)
+		er.uint(3)
+		if e.typ != "infe" || infeVersion < 2 {
+			continue
+		}
+		// Item IDs of infe boxes are 32 bits wide from version 3
+		var id uint32
+		if infeVersion == 2 {
+			id = uint32(er.uint(2))
+		} else {
+			id = uint32(er.uint(4))
+		}
+		er.uint(2) // item_protection_index
+		if er.err == nil && len(er.data) >= 4 && string(er.data[:4]) == "Exif" {
+			exifItems[id] = true
+		}
+	}
+
+	r = heifReader{data: iref}
+	version = r.uint(1)
+	r.uint(3)
+	if r.err != nil {
+		return 0, false, r.err
+	}
+	refs, err := heifBoxes(r.data)
+	if err != nil {
+		return 0, false, err
+	}
+	for _, ref := range refs {
+		if ref.typ != "cdsc" {
+			continue
+		}
+		rr := heifReader{data: ref.payload}
+		from := rr.id(version)
+		count := rr.uint(2)
+		for i := uint64(0); i < count && rr.err == nil; i++ {
+			if rr.id(version) == itemID && exifItems[from] {
+				return from, true, nil
+			}
+		}
+		if rr.err != nil {
+			return 0, false, rr.err
+		}
+	}
+	return 0, false, nil
+}
+
+// heifItemData concatenates the extents of an item from the file or the idat box
+func heifItemData(data []byte, meta []heifBox, itemID uint32) ([]byte, error) {
+	r := heifReader{data: findHeifBox(meta, "iloc")}
+	version := r.uint(1)
+	r.uint(3)
+	sizes := r.uint(1)
+	offsetSize, lengthSize := int(sizes>>4), int(sizes&0xf)
+	sizes = r.uint(1)
+	baseOffsetSize, indexSize := int(sizes>>4), 0
+	if version == 1 || version == 2 {
+		indexSize = int(sizes & 0xf)
+	}
+	var count uint64
+	if version < 2 {
+		count = r.uint(2)
+	} else {
+		count = r.uint(4)
+	}
+
+	for i := uint64(0); i < count && r.err == nil; i++ {
+		var id uint32
+		if version < 2 {
+			id = uint32(r.uint(2))
+		} else {
+			id = uint32(r.uint(4))
+		}
+		var method uint64
+		if version == 1 || version == 2 {
+			method = r.uint(2) & 0xf
+		}
+		r.uint(2) // data_reference_index
+		base := r.uint(baseOffsetSize)
+		extents := r.uint(2)
+
+		var out []byte
+		source := data
+		if method == 1 {
+			source = findHeifBox(meta, "idat")
+		} else if method != 0 {
+			return nil, errHeifBoxes
+		}
+		for j := uint64(0); j < extents && r.err == nil; j++ {
+			r.uint(indexSize)
+			offset := base + r.uint(offsetSize)
+			length := r.uint(lengthSize)
+			if offset > uint64(len(source)) {
+				return nil, errHeifBoxes
+			}
+			// A zero length extends to the end of the source
+			if length == 0 {
+				length = uint64(len(source)) - offset
+			}
+			if length > uint64(len(source))-offset {
+				return nil, errHeifBoxes
+			}
+			out = append(out, source[offset:offset+length]...)
+		}
+		if r.err == nil && id == itemID {
+			return out, nil
+		}
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return nil, errHeifBoxes
+}
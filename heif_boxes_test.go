@@ -0,0 +1,26 @@
+package imagecoding
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseHeifItem(t *testing.T) {
+	sample, err := os.ReadFile("testdata/world-political.heic")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	info, err := parseHeifItem(sample, 25)
+	if assert.NoError(t, err) {
+		assert.Equal(t, TopLeft, info.orientation)
+		assert.False(t, info.transformed)
+	}
+
+	// Damaged boxes are reported, not read past
+	for _, n := range []int{0, 7, 30, 200, 400} {
+		_, err = parseHeifItem(sample[:n], 25)
+		assert.Error(t, err, "truncated to %d bytes", n)
+	}
+}
@@ -0,0 +1,248 @@
+//go:build heif || darwin
+// +build heif darwin
+
+package imagecoding
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"unsafe"
+)
+
+// #cgo pkg-config: libheif
+// #include <stdlib.h>
+// #include <string.h>
+// #include <libheif/heif.h>
+//
+// #define GO_HEIF_MESSAGE_LENGTH 256
+//
+// typedef struct {
+//     unsigned char *data;
+//     size_t size;
+// } goHeifBuffer;
+//
+// static struct heif_error goHeifWrite(struct heif_context *ctx, const void *data, size_t size, void *userdata) {
+//     goHeifBuffer *out = (goHeifBuffer *)userdata;
+//     struct heif_error err = {heif_error_Ok, heif_suberror_Unspecified, "Success"};
+//     unsigned char *grown = realloc(out->data, out->size + size);
+//     if (grown == NULL) {
+//         err.code = heif_error_Memory_allocation_error;
+//         err.message = "out of memory";
+//         return err;
+//     }
+//     memcpy(grown + out->size, data, size);
+//     out->data = grown;
+//     out->size += size;
+//     return err;
+// }
+//
+// // goHeifEncode encodes gray, RGB or RGBA pixels with libheif into out, which must be freed
+// // by the caller, also on failure. chroma may be NULL to use the encoder's default.
+// // A thumbnail fitting in thumbnail x thumbnail pixels is added when thumbnail is positive.
+// // An ICC profile and EXIF data starting with the TIFF header are embedded when their size is positive.
+// // On failure it returns non-zero and copies the libheif message to message before the context
+// // that holds it is freed.
+// int goHeifEncode(const unsigned char *pix, int width, int height, int stride, int channels,
+//                  enum heif_compression_format format, int quality, int lossless,
+//                  const char *chroma, int thumbnail,
+//                  const void *icc, size_t iccSize, const void *exif, size_t exifSize,
+//                  goHeifBuffer *out, char *message) {
+//     struct heif_context *ctx;
+//     struct heif_encoder *encoder = NULL;
+//     struct heif_image *img = NULL;
+//     struct heif_image_handle *handle = NULL;
+//     struct heif_encoding_options *options = NULL;
+//     struct heif_color_profile_nclx *nclx = NULL;
+//     struct heif_writer writer = {1, goHeifWrite};
+//     struct heif_error err = {heif_error_Memory_allocation_error, heif_suberror_Unspecified, "could not allocate heif context"};
+//     enum heif_colorspace colorspace = heif_colorspace_RGB;
+//     enum heif_chroma imgChroma = heif_chroma_interleaved_RGB;
+//     enum heif_channel channel = heif_channel_interleaved;
+//     unsigned char *plane;
+//     int planeStride, y;
+//
+//     ctx = heif_context_alloc();
+//     if (ctx == NULL) {
+//         strncpy(message, err.message, GO_HEIF_MESSAGE_LENGTH - 1);
+//         return -1;
+//     }
+//     if (channels == 1) {
+//         colorspace = heif_colorspace_monochrome;
+//         imgChroma = heif_chroma_monochrome;
+//         channel = heif_channel_Y;
+//     } else if (channels == 4) {
+//         imgChroma = heif_chroma_interleaved_RGBA;
+//     }
+//
+//     err = heif_context_get_encoder_for_format(ctx, format, &encoder);
+//     if (err.code != heif_error_Ok) {
+//         goto done;
+//     }
+//     if (lossless) {
+//         err = heif_encoder_set_lossless(encoder, 1);
+//     } else {
+//         err = heif_encoder_set_lossy_quality(encoder, quality);
+//     }
+//     if (err.code != heif_error_Ok) {
+//         goto done;
+//     }
+//     if (chroma != NULL && channels != 1) {
+//         err = heif_encoder_set_parameter_string(encoder, "chroma", chroma);
+//         if (err.code != heif_error_Ok) {
+//             goto done;
+//         }
+//     }
+//
+//     err = heif_image_create(width, height, colorspace, imgChroma, &img);
+//     if (err.code != heif_error_Ok) {
+//         goto done;
+//     }
+//     err = heif_image_add_plane(img, channel, width, height, 8);
+//     if (err.code != heif_error_Ok) {
+//         goto done;
+//     }
+//     plane = heif_image_get_plane(img, channel, &planeStride);
+//     for (y = 0; y < height; y++) {
+//         memcpy(plane + (size_t)y * planeStride, pix + (size_t)y * stride, (size_t)width * channels);
+//     }
+//     if (lossless && channels != 1) {
+//         // The identity matrix stores RGB as is, any other matrix rounds when converting to YCbCr
+//         nclx = heif_nclx_color_profile_alloc();
+//         if (nclx == NULL) {
+//             err.code = heif_error_Memory_allocation_error;
+//             err.message = "could not allocate nclx profile";
+//             goto done;
+//         }
+//         nclx->matrix_coefficients = heif_matrix_coefficients_RGB_GBR;
+//         err = heif_image_set_nclx_color_profile(img, nclx);
+//         if (err.code != heif_error_Ok) {
+//             goto done;
+//         }
+//     }
+//     if (iccSize > 0) {
+//         err = heif_image_set_raw_color_profile(img, "prof", icc, iccSize);
+//         if (err.code != heif_error_Ok) {
+//             goto done;
+//         }
+//     }
+//
+//     options = heif_encoding_options_alloc();
+//     err = heif_context_encode_image(ctx, img, encoder, options, &handle);
+//     if (err.code != heif_error_Ok) {
+//         goto done;
+//     }
+//     if (exifSize > 0) {
+//         err = heif_context_add_exif_metadata(ctx, handle, exif, (int)exifSize);
+//         if (err.code != heif_error_Ok) {
+//             goto done;
+//         }
+//     }
+//     if (thumbnail > 0) {
+//         // libheif skips the thumbnail when the image fits in it already
+//         err = heif_context_encode_thumbnail(ctx, img, handle, encoder, options, thumbnail, NULL);
+//         if (err.code != heif_error_Ok) {
+//             goto done;
+//         }
+//     }
+//     err = heif_context_write(ctx, &writer, out);
+//
+// done:
+//     if (err.code != heif_error_Ok && err.message != NULL) {
+//         strncpy(message, err.message, GO_HEIF_MESSAGE_LENGTH - 1);
+//     }
+//     if (handle != NULL) {
+//         heif_image_handle_release(handle);
+//     }
+//     if (options != NULL) {
+//         heif_encoding_options_free(options);
+//     }
+//     if (nclx != NULL) {
+//         heif_nclx_color_profile_free(nclx);
+//     }
+//     if (img != NULL) {
+//         heif_image_release(img);
+//     }
+//     if (encoder != NULL) {
+//         heif_encoder_release(encoder);
+//     }
+//     heif_context_free(ctx);
+//     return err.code == heif_error_Ok ? 0 : -1;
+// }
+import "C"
+
+// EncodeHeif will encode an image to HEIC bytes using libheif's HEVC encoder
+func EncodeHeif(buf *bytes.Buffer, img image.Image, opts HeifEncodeOptions) ([]byte, error) {
+	opts = opts.withDefaults()
+	chroma := opts.Chroma.encoderParameter(opts.Lossless)
+	return encodeHeifFile(buf, img, C.heif_compression_HEVC, opts.Quality, opts.Lossless, chroma, opts.Thumbnail, opts.Metadata)
+}
+
+// encodeHeifFile encodes an image into a HEIF container with the given compression format.
+// Gray, RGB and straight alpha RGBA images are passed as they are, any other image is converted
+// to RGB, or to straight alpha RGBA if it has transparency. The DPI of the metadata is written to EXIF.
+func encodeHeifFile(buf *bytes.Buffer, img image.Image, format C.enum_heif_compression_format, quality int, lossless bool, chroma string, thumbnail int, meta EncodeMetadata) ([]byte, error) {
+	if img.Bounds().Empty() {
+		return nil, ErrEmptyInput
+	}
+
+	var pix []uint8
+	var stride, channels int
+	switch v := img.(type) {
+	case *image.Gray:
+		pix = v.Pix[v.PixOffset(v.Rect.Min.X, v.Rect.Min.Y):]
+		stride = v.Stride
+		channels = 1
+	case *RGBImage:
+		pix = v.Pix[v.PixOffset(v.Rect.Min.X, v.Rect.Min.Y):]
+		stride = v.Stride
+		channels = 3
+	case *image.NRGBA:
+		if v.Opaque() {
+			// Skip encoding an alpha image that carries no information
+			return encodeHeifFile(buf, toRGB(v), format, quality, lossless, chroma, thumbnail, meta)
+		}
+		pix = v.Pix[v.PixOffset(v.Rect.Min.X, v.Rect.Min.Y):]
+		stride = v.Stride
+		channels = 4
+	default:
+		if isOpaque(img) {
+			return encodeHeifFile(buf, toRGB(img), format, quality, lossless, chroma, thumbnail, meta)
+		}
+		return encodeHeifFile(buf, toNRGBA(img), format, quality, lossless, chroma, thumbnail, meta)
+	}
+
+	var cChroma *C.char
+	if chroma != "" {
+		cChroma = C.CString(chroma)
+		defer C.free(unsafe.Pointer(cChroma))
+	}
+
+	var icc, exif unsafe.Pointer
+	if len(meta.ICCProfile) > 0 {
+		icc = unsafe.Pointer(&meta.ICCProfile[0])
+	}
+	exifData := meta.exif(true)
+	if exifData != nil {
+		exif = unsafe.Pointer(&exifData[0])
+	}
+
+	var out C.goHeifBuffer
+	var message [C.GO_HEIF_MESSAGE_LENGTH]C.char
+	res := C.goHeifEncode(
+		(*C.uchar)(unsafe.Pointer(&pix[0])),
+		C.int(img.Bounds().Dx()), C.int(img.Bounds().Dy()), C.int(stride), C.int(channels),
+		format, C.int(quality), cBool(lossless), cChroma, C.int(thumbnail),
+		icc, C.size_t(len(meta.ICCProfile)), exif, C.size_t(len(exifData)), &out, &message[0],
+	)
+	if out.data != nil {
+		defer C.free(unsafe.Pointer(out.data))
+	}
+	if res != 0 {
+		return nil, fmt.Errorf("could not encode heif: %v", C.GoString(&message[0]))
+	}
+
+	buf.Reset()
+	buf.Write(C.GoBytes(unsafe.Pointer(out.data), C.int(out.size)))
+	return buf.Bytes(), nil
+}
@@ -3,6 +3,7 @@
 package imagecoding
 
 import (
+	"bytes"
 	"image"
 )
 
@@ -13,3 +14,27 @@ func ConfigHeif(data []byte) (image.Config, string, error) {
 func TransformHeif(data []byte, grayscale bool, scale ScaleFunc) (out image.Image, width, height int, scaleFactor float64, err error) {
 	return nil, 0, 0, 0, image.ErrFormat
 }
+
+func TransformHeifWithOptions(data []byte, opts TransformOptions) (TransformResult, error) {
+	return TransformResult{}, image.ErrFormat
+}
+
+func EncodeHeif(buf *bytes.Buffer, img image.Image, opts HeifEncodeOptions) ([]byte, error) {
+	return nil, image.ErrFormat
+}
+
+func HeifImages(data []byte) ([]HeifImage, error) {
+	return nil, image.ErrFormat
+}
+
+func TransformHeifImage(data []byte, id int, opts TransformOptions) (TransformResult, error) {
+	return TransformResult{}, image.ErrFormat
+}
+
+func TransformHeifDepth(data []byte, id, depthID int, opts TransformOptions) (TransformResult, error) {
+	return TransformResult{}, image.ErrFormat
+}
+
+func TransformHeifPreview(data []byte, opts TransformOptions) (TransformResult, error) {
+	return TransformResult{}, image.ErrFormat
+}
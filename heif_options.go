@@ -0,0 +1,107 @@
+package imagecoding
+
+import "errors"
+
+const (
+	// DefaultHeifQuality is the quality used when HeifEncodeOptions.Quality is zero, libheif's own default
+	DefaultHeifQuality = 50
+	// DefaultAvifQuality is the quality used when AvifEncodeOptions.Quality is zero, libheif's own default
+	DefaultAvifQuality = 50
+)
+
+// HeifChroma selects the chroma subsampling of the libheif encoders
+type HeifChroma int
+
+const (
+	// HeifChroma420 halves the chroma resolution in both directions, the most compatible choice
+	HeifChroma420 HeifChroma = iota
+	// HeifChroma422 halves the chroma resolution horizontally
+	HeifChroma422
+	// HeifChroma444 keeps full chroma resolution, not every HEIC reader supports it
+	HeifChroma444
+)
+
+// encoderParameter returns the value of the libheif "chroma" encoder parameter
+func (c HeifChroma) encoderParameter(lossless bool) string {
+	switch {
+	case lossless || c == HeifChroma444:
+		// Lossless output can not subsample
+		return "444"
+	case c == HeifChroma422:
+		return "422"
+	default:
+		return "420"
+	}
+}
+
+// HeifEncodeOptions configures EncodeHeif
+type HeifEncodeOptions struct {
+	// Quality ranges from 1 to 100, zero uses DefaultHeifQuality
+	Quality int
+	// Lossless keeps every pixel intact and ignores Quality and Chroma
+	Lossless bool
+	// Chroma subsampling of color images
+	Chroma HeifChroma
+	// Thumbnail is the size of the square an embedded thumbnail fits in, zero adds no thumbnail.
+	// TransformHeifPreview uses the thumbnail when it is large enough.
+	Thumbnail int
+	// Metadata is written as a color profile and an EXIF item, the DPI to EXIF
+	Metadata EncodeMetadata
+}
+
+func (o HeifEncodeOptions) withDefaults() HeifEncodeOptions {
+	if o.Quality == 0 {
+		o.Quality = DefaultHeifQuality
+	}
+	return o
+}
+
+// AvifEncodeOptions configures EncodeAvif
+type AvifEncodeOptions struct {
+	// Quality ranges from 1 to 100, zero uses DefaultAvifQuality
+	Quality int
+	// Lossless keeps every pixel intact and ignores Quality and Chroma
+	Lossless bool
+	// Chroma subsampling of color images
+	Chroma HeifChroma
+	// Metadata is written as a color profile and an EXIF item, the DPI to EXIF
+	Metadata EncodeMetadata
+}
+
+func (o AvifEncodeOptions) withDefaults() AvifEncodeOptions {
+	if o.Quality == 0 {
+		o.Quality = DefaultAvifQuality
+	}
+	return o
+}
+
+// ErrHeifImageNotFound is returned when an ID is not that of a top-level image or of its depth map
+var ErrHeifImageNotFound = errors.New("heif image not found")
+
+// HeifImage describes a top-level image of a HEIF file, like an image of a burst sequence
+type HeifImage struct {
+	// ID identifies the image for TransformHeifImage
+	ID int
+	// Width and Height are the dimensions after the irot/imir transformations
+	Width, Height int
+	// Primary is set for the image that TransformHeif decodes
+	Primary bool
+	// HasAlpha and HasDepth report the auxiliary alpha and depth images
+	HasAlpha, HasDepth bool
+	// Thumbnails are the embedded preview images
+	Thumbnails []HeifThumbnail
+	// DepthImages are the depth maps, see TransformHeifDepth
+	DepthImages []HeifDepthImage
+}
+
+// HeifThumbnail describes an embedded preview image of a top-level image
+type HeifThumbnail struct {
+	ID            int
+	Width, Height int
+}
+
+// HeifDepthImage describes the depth map of a top-level image
+type HeifDepthImage struct {
+	ID            int
+	Width, Height int
+}
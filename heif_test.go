@@ -4,9 +4,13 @@
 package imagecoding
 
 import (
+	"bytes"
+	"image"
 	"os"
 	"testing"
 
+	"github.com/Nr90/imgsim"
+	"github.com/disintegration/imaging"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -19,6 +23,7 @@ func TestHeifTransform(t *testing.T) {
 	{
 		img, _, _, _, err := TransformHeif(sample, true, DefaultScale)
 		if assert.NoError(t, err) {
+			assert.IsType(t, &image.Gray{}, img)
 			assert.Equal(t, 1754, img.Bounds().Dx())
 			assert.Equal(t, 1002, img.Bounds().Dy())
 		}
@@ -26,10 +31,22 @@ func TestHeifTransform(t *testing.T) {
 	{
 		img, _, _, _, err := TransformHeif(sample, false, DefaultScale)
 		if assert.NoError(t, err) {
+			assert.IsType(t, &RGBImage{}, img)
 			assert.Equal(t, 1754, img.Bounds().Dx())
 			assert.Equal(t, 1002, img.Bounds().Dy())
 		}
 	}
+	{
+		// The color model is that of the source, also when a YCbCr decode is made gray
+		res, err := TransformHeifWithOptions(sample, TransformOptions{})
+		if assert.NoError(t, err) {
+			gray, err := TransformHeifWithOptions(sample, TransformOptions{ColorMode: ColorModeGray})
+			if assert.NoError(t, err) {
+				assert.IsType(t, &image.Gray{}, gray.Image)
+				assert.Equal(t, res.ColorModel, gray.ColorModel)
+			}
+		}
+	}
 }
 
 func BenchmarkHeifTransform(b *testing.B) {
@@ -44,3 +61,335 @@ func BenchmarkHeifTransform(b *testing.B) {
 		}
 	}
 }
+
+func TestEncodeHeif(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  HeifEncodeOptions
+		delta int
+	}{
+		{"default", HeifEncodeOptions{}, 24},
+		{"quality", HeifEncodeOptions{Quality: 90}, 6},
+		{"422", HeifEncodeOptions{Quality: 90, Chroma: HeifChroma422}, 6},
+		{"444", HeifEncodeOptions{Quality: 90, Chroma: HeifChroma444}, 6},
+		{"lossless", HeifEncodeOptions{Lossless: true}, 0},
+	}
+	src := makeConvertTestImages()["rgb-image"]
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			imgbytes, err := EncodeHeif(&buf, src, tt.opts)
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, buf.Len(), len(imgbytes))
+
+			_, format, err := DecodeConfig(imgbytes)
+			if assert.NoError(t, err) {
+				assert.Equal(t, string(Heif), format)
+			}
+			output, err := DecodeHeif(imgbytes)
+			if assert.NoError(t, err) {
+				assert.Equal(t, src.Bounds().Size(), output.Bounds().Size())
+				if tt.opts.Lossless {
+					assertSimilar(t, src, output, 0)
+				} else {
+					assertSimilar(t, toGray(src), toGray(output), tt.delta)
+				}
+			}
+		})
+	}
+
+	t.Run("transform", func(t *testing.T) {
+		var buf bytes.Buffer
+		imgbytes, err := EncodeHeif(&buf, img, HeifEncodeOptions{Quality: 80})
+		if !assert.NoError(t, err) {
+			return
+		}
+		out, _, _, _, err := TransformHeif(imgbytes, true, DefaultScale)
+		if assert.NoError(t, err) {
+			assert.Equal(t, ref, imgsim.AverageHash(out))
+		}
+	})
+
+	// The libheif message outlives the context
+	t.Run("error", func(t *testing.T) {
+		var buf bytes.Buffer
+		_, err := EncodeHeif(&buf, src, HeifEncodeOptions{Quality: 1000})
+		if assert.Error(t, err) {
+			assert.Equal(t, "could not encode heif: Invalid parameter value", err.Error())
+		}
+	})
+}
+
+func TestHeifImages(t *testing.T) {
+	src := NewRGBImage(image.Rect(0, 0, 160, 120))
+	for y := 0; y < 120; y++ {
+		for x := 0; x < 160; x++ {
+			i := src.PixOffset(x, y)
+			src.Pix[i], src.Pix[i+1], src.Pix[i+2] = uint8(x), uint8(y*2), uint8((x+y)/2)
+		}
+	}
+	var buf bytes.Buffer
+	data, err := EncodeHeif(&buf, src, HeifEncodeOptions{Quality: 90, Thumbnail: 64})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	images, err := HeifImages(data)
+	if !assert.NoError(t, err) || !assert.Len(t, images, 1) {
+		t.FailNow()
+	}
+	primary := images[0]
+	assert.True(t, primary.Primary)
+	assert.Equal(t, 160, primary.Width)
+	assert.Equal(t, 120, primary.Height)
+	if assert.Len(t, primary.Thumbnails, 1) {
+		assert.Equal(t, 64, primary.Thumbnails[0].Width)
+		assert.Equal(t, 48, primary.Thumbnails[0].Height)
+	}
+
+	full, err := TransformHeifImage(data, primary.ID, TransformOptions{})
+	if assert.NoError(t, err) {
+		assertSimilar(t, src, full.Image, 8)
+	}
+	_, err = TransformHeifImage(data, primary.ID+100, TransformOptions{})
+	assert.Equal(t, ErrHeifImageNotFound, err)
+
+	// The thumbnail covers 40x30, but not 80x60
+	for _, w := range []int{40, 80} {
+		scale := func(pageWidth, pageHeight int) (int, int, float64) {
+			f := float64(w) / float64(pageWidth)
+			return w, pageHeight * w / pageWidth, f
+		}
+		res, err := TransformHeifPreview(data, TransformOptions{Scale: scale})
+		if !assert.NoError(t, err) {
+			continue
+		}
+		assert.Equal(t, Heif, res.Format)
+		assert.Equal(t, 160, res.Width)
+		assert.Equal(t, 120, res.Height)
+		assert.Equal(t, w, res.OutWidth)
+		assert.Equal(t, w*3/4, res.OutHeight)
+		assertSimilar(t, imaging.Resize(src, w, 0, imaging.Box), res.Image, 12)
+	}
+}
+
+func TestHeifDepth(t *testing.T) {
+	// The fixture has a 128x64 image with a 64x64 depth map, both stored losslessly
+	data, err := os.ReadFile("testdata/depth.heic")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	images, err := HeifImages(data)
+	if !assert.NoError(t, err) || !assert.Len(t, images, 1) {
+		t.FailNow()
+	}
+	primary := images[0]
+	assert.True(t, primary.HasDepth)
+	if !assert.Len(t, primary.DepthImages, 1) {
+		t.FailNow()
+	}
+	depth := primary.DepthImages[0]
+	assert.Equal(t, [2]int{64, 64}, [2]int{depth.Width, depth.Height})
+
+	expected := image.NewGray(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			expected.Pix[expected.PixOffset(x, y)] = uint8(x*3 + y)
+		}
+	}
+	res, err := TransformHeifDepth(data, primary.ID, depth.ID, TransformOptions{})
+	if assert.NoError(t, err) {
+		assert.Equal(t, [2]int{64, 64}, [2]int{res.Width, res.Height})
+		assertSimilar(t, expected, res.Image, 0)
+	}
+	res, err = TransformHeifDepth(data, primary.ID, depth.ID, TransformOptions{Scale: FitWithin(32, 32)})
+	if assert.NoError(t, err) {
+		assert.Equal(t, [2]int{32, 32}, [2]int{res.OutWidth, res.OutHeight})
+		assertSimilar(t, imaging.Resize(expected, 32, 32, imaging.Lanczos), res.Image, 2)
+	}
+
+	_, err = TransformHeifDepth(data, primary.ID, primary.ID, TransformOptions{})
+	assert.Equal(t, ErrHeifImageNotFound, err)
+	_, err = TransformHeifDepth(data, depth.ID, depth.ID, TransformOptions{})
+	assert.Equal(t, ErrHeifImageNotFound, err)
+}
+
+func TestHeifOrientation(t *testing.T) {
+	// The fixtures store this gradient with irot and imir boxes for every orientation,
+	// and once with only an EXIF orientation
+	src := NewRGBImage(image.Rect(0, 0, 48, 32))
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 48; x++ {
+			i := src.PixOffset(x, y)
+			src.Pix[i], src.Pix[i+1], src.Pix[i+2] = uint8(x*5), uint8(y*7), 128
+		}
+	}
+
+	tests := []struct {
+		file        string
+		orient      Orientation
+		transformed bool
+	}{
+		{"testdata/world-political.heic", TopLeft, false},
+		{"testdata/f2-transform.heic", TopRight, true},
+		{"testdata/f3-transform.heic", BottomRight, true},
+		{"testdata/f4-transform.heic", BottomLeft, true},
+		{"testdata/f5-transform.heic", LeftTop, true},
+		{"testdata/f6-transform.heic", RightTop, true},
+		{"testdata/f7-transform.heic", RightBottom, true},
+		{"testdata/f8-transform.heic", LeftBottom, true},
+		{"testdata/f6-exif.heic", RightTop, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			data, err := os.ReadFile(tt.file)
+			if !assert.NoError(t, err) {
+				return
+			}
+			images, err := HeifImages(data)
+			if !assert.NoError(t, err) {
+				return
+			}
+			info, err := parseHeifItem(data, images[0].ID)
+			if assert.NoError(t, err) {
+				assert.Equal(t, tt.transformed, info.transformed)
+				if tt.transformed {
+					assert.Equal(t, tt.orient, info.orientation)
+				}
+			}
+
+			res, err := TransformHeifWithOptions(data, TransformOptions{Scale: noScale})
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, tt.orient, res.Orientation)
+			assert.Empty(t, res.Warnings)
+			if tt.orient == TopLeft {
+				return
+			}
+			upright := FixOrientation(src, tt.orient)
+			assert.Equal(t, upright.Bounds().Dx(), res.Width)
+			assert.Equal(t, upright.Bounds().Dy(), res.Height)
+			assertSimilar(t, upright, res.Image, 3)
+
+			// The mapping leads back to the pixel as stored
+			x, y := res.ToSource.Apply(0.5, 0.5)
+			stored := src.RGBAAt(int(x), int(y))
+			out := res.Image.(*RGBImage).RGBAAt(0, 0)
+			assert.InDelta(t, stored.R, out.R, 3)
+			assert.InDelta(t, stored.G, out.G, 3)
+
+			// Ignoring the orientation leaves out the EXIF orientation, libheif applies the boxes regardless
+			res, err = TransformHeifWithOptions(data, TransformOptions{Scale: noScale, Orientation: OrientationIgnore})
+			if assert.NoError(t, err) {
+				expected := upright
+				if !tt.transformed {
+					expected = src
+				}
+				assert.Equal(t, expected.Bounds().Size(), res.Image.Bounds().Size())
+				assertSimilar(t, expected, res.Image, 3)
+			}
+		})
+	}
+}
+
+func TestEncodeHeifMetadata(t *testing.T) {
+	meta := testMetadata()
+	src := NewRGBImage(image.Rect(0, 0, 48, 32))
+	for i := 0; i < len(src.Pix); i += 3 {
+		src.Pix[i], src.Pix[i+1], src.Pix[i+2] = 234, 51, 35
+	}
+
+	var buf bytes.Buffer
+	data, err := EncodeHeif(&buf, src, HeifEncodeOptions{Lossless: true, Metadata: meta})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	embedded, err := ICCProfile(data)
+	if assert.NoError(t, err) {
+		assert.Equal(t, meta.ICCProfile, embedded)
+	}
+	id, err := heifPrimaryItem(data)
+	if assert.NoError(t, err) {
+		info, err := parseHeifItem(data, id)
+		if assert.NoError(t, err) {
+			assertExif(t, info.exif, 150)
+		}
+	}
+	resolution, err := ImageResolution(data)
+	if assert.NoError(t, err) {
+		assert.Equal(t, Resolution{150, 150}, resolution)
+	}
+
+	// The EXIF orientation applies without irot/imir boxes and the profile is converted on request
+	res, err := TransformHeifWithOptions(data, TransformOptions{Scale: noScale, ConvertToSRGB: true})
+	if assert.NoError(t, err) {
+		assert.Equal(t, RightTop, res.Orientation)
+		assert.Equal(t, 32, res.Width)
+		assert.Equal(t, 48, res.Height)
+		c := res.Image.(*RGBImage).RGBAAt(4, 4)
+		assertRGB(t, [3]uint8{255, 0, 0}, c.R, c.G, c.B, 4)
+	}
+	// Gray output converts the colors before dropping them
+	res, err = TransformHeifWithOptions(data, TransformOptions{Scale: noScale, ConvertToSRGB: true, ColorMode: ColorModeGray})
+	if assert.NoError(t, err) {
+		red := NewRGBImage(image.Rect(0, 0, 1, 1))
+		red.Pix[0] = 255
+		assert.InDelta(t, toGray(red).Pix[0], res.Image.(*image.Gray).GrayAt(4, 4).Y, 2)
+	}
+	res, err = TransformHeifWithOptions(data, TransformOptions{Scale: noScale})
+	if assert.NoError(t, err) {
+		c := res.Image.(*RGBImage).RGBAAt(4, 4)
+		assertRGB(t, [3]uint8{234, 51, 35}, c.R, c.G, c.B, 4)
+	}
+}
+
+func TestHeifScaleFuncs(t *testing.T) {
+	var buf bytes.Buffer
+	data, err := EncodeHeif(&buf, image.NewGray(image.Rect(0, 0, 400, 240)), HeifEncodeOptions{Lossless: true})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	for _, scale := range []ScaleFunc{FitWithin(200, 200), ExactWidth(100), Megapixels(0.024), FitPaper(PaperA5, 30)} {
+		res, err := TransformHeifWithOptions(data, TransformOptions{Scale: scale, ColorMode: ColorModeGray})
+		if assert.NoError(t, err) {
+			w, h, s := scale(400, 240)
+			assert.Equal(t, [2]int{w, h}, [2]int{res.OutWidth, res.OutHeight})
+			assert.Equal(t, s, res.ScaleFactor)
+		}
+	}
+}
+
+func TestHeifTransformFilter(t *testing.T) {
+	// Thin lines like the text of a receipt
+	src := image.NewGray(image.Rect(0, 0, 96, 64))
+	for i := range src.Pix {
+		src.Pix[i] = 0xff
+		if i%3 == 0 {
+			src.Pix[i] = 0
+		}
+	}
+	var buf bytes.Buffer
+	data, err := EncodeHeif(&buf, src, HeifEncodeOptions{Lossless: true})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	half := func(w, h int) (int, int, float64) {
+		return w / 2, h / 2, 0.5
+	}
+
+	outputs := map[string]*image.Gray{}
+	for name, filter := range map[string]imaging.ResampleFilter{"box": imaging.Box, "lanczos": imaging.Lanczos} {
+		res, err := TransformHeifWithOptions(data, TransformOptions{ColorMode: ColorModeGray, Scale: half, Filter: filter})
+		if !assert.NoError(t, err) {
+			continue
+		}
+		assert.Equal(t, 48, res.OutWidth)
+		assert.Equal(t, 32, res.OutHeight)
+		assertSimilar(t, imaging.Resize(src, 48, 32, filter), res.Image, 3)
+		outputs[name] = res.Image.(*image.Gray)
+	}
+	assert.NotEqual(t, outputs["box"].Pix, outputs["lanczos"].Pix)
+}
@@ -0,0 +1,185 @@
+package imagecoding
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"image"
+	"io"
+
+	"github.com/h2non/filetype"
+)
+
+// maxICCProfileSize bounds the decompressed size of PNG profiles
+const maxICCProfileSize = 16 << 20
+
+// ICCProfile returns the ICC profile embedded in an image, nil when it has none.
+// Profiles are read from JPEG APP2 markers, PNG iCCP chunks, WebP ICCP chunks, the first page
+// of a TIFF file and the primary image of a HEIF or AVIF file.
+func ICCProfile(data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, ErrEmptyInput
+	}
+	kind, err := filetype.Match(data)
+	if err != nil {
+		return nil, errors.New("could not determine file type")
+	}
+	switch ImgFormat(kind.Extension) {
+	case Jpeg:
+		segments, err := jpegSegments(data)
+		if err != nil {
+			return nil, err
+		}
+		return jpegICCProfile(segments), nil
+	case Png:
+		return pngICCProfile(data)
+	case Webp:
+		return webpICCProfile(data), nil
+	case Tiff:
+		ifds, err := tiffIFDs(data)
+		if err != nil {
+			return nil, err
+		}
+		return tiffTagBytes(data, ifds[0], tiffTagICCProfile), nil
+	case Heif, Avif:
+		id, err := heifPrimaryItem(data)
+		if err != nil {
+			return nil, err
+		}
+		info, err := parseHeifItem(data, id)
+		return info.icc, err
+	default:
+		return nil, nil
+	}
+}
+
+// ConvertToSRGB converts the colors of an image from the color space of an ICC profile to sRGB.
+// Matrix/TRC RGB profiles, like Display P3 and Adobe RGB, give an *RGBImage, gray profiles an
+// *image.Gray and the lookup tables of CMYK profiles convert an *image.CMYK to an *RGBImage.
+// Images in sRGB already are returned as they are.
+func ConvertToSRGB(img image.Image, profile []byte) (image.Image, error) {
+	switch iccColorSpace(profile) {
+	case "RGB ":
+		t, err := iccColorTransform(profile)
+		if err != nil {
+			return nil, err
+		}
+		if t.identity() {
+			return img, nil
+		}
+		return t.apply(toRGB(img)), nil
+	case "GRAY":
+		lut, err := iccGrayTransform(profile)
+		if err != nil {
+			return nil, err
+		}
+		gray := toGray(img)
+		out := image.NewGray(gray.Rect)
+		for i, v := range gray.Pix {
+			out.Pix[i] = lut[v]
+		}
+		return out, nil
+	case "CMYK":
+		cmyk, ok := img.(*image.CMYK)
+		if !ok {
+			return nil, errUnsupportedProfile
+		}
+		t, err := cmykColorTransform(profile)
+		if err != nil {
+			return nil, err
+		}
+		return cmykToRGB(cmyk, false, t), nil
+	case "":
+		return nil, errInvalidProfile
+	default:
+		return nil, errUnsupportedProfile
+	}
+}
+
+// iccColorSpace returns the signature of the color space of a profile, like "RGB " or "CMYK"
+func iccColorSpace(profile []byte) string {
+	if len(profile) < 20 {
+		return ""
+	}
+	return string(profile[16:20])
+}
+
+// pngICCProfile decompresses the profile of the iCCP chunk, which precedes the image data
+func pngICCProfile(data []byte) ([]byte, error) {
+	if len(data) < 8 {
+		return nil, image.ErrFormat
+	}
+	data = data[8:]
+	for len(data) >= 12 {
+		size := uint64(binary.BigEndian.Uint32(data))
+		typ := string(data[4:8])
+		if size+12 > uint64(len(data)) || typ == "IDAT" {
+			break
+		}
+		if typ == "iCCP" {
+			// The profile name ends with a zero byte, the compression method follows it
+			chunk := data[8 : 8+size]
+			name := bytes.IndexByte(chunk, 0)
+			if name < 0 || name+2 > len(chunk) || chunk[name+1] != 0 {
+				return nil, errors.New("invalid png iCCP chunk")
+			}
+			r, err := zlib.NewReader(bytes.NewReader(chunk[name+2:]))
+			if err != nil {
+				return nil, err
+			}
+			defer r.Close()
+			return io.ReadAll(io.LimitReader(r, maxICCProfileSize))
+		}
+		data = data[12+size:]
+	}
+	return nil, nil
+}
+
+// webpICCProfile returns the payload of the ICCP chunk of an extended WebP file
+func webpICCProfile(data []byte) []byte {
+	return webpChunk(data, "ICCP")
+}
+
+// webpChunk returns the payload of the first chunk of a type in a WebP file, nil when there is none
+func webpChunk(data []byte, typ string) []byte {
+	if len(data) < 12 {
+		return nil
+	}
+	data = data[12:]
+	for len(data) >= 8 {
+		size := uint64(binary.LittleEndian.Uint32(data[4:]))
+		if size+8 > uint64(len(data)) {
+			return nil
+		}
+		if string(data[:4]) == typ {
+			return data[8 : 8+size]
+		}
+		// Chunks are padded to an even size
+		next := 8 + size + size&1
+		if next > uint64(len(data)) {
+			return nil
+		}
+		data = data[next:]
+	}
+	return nil
+}
+
+// pngChunk returns the payload of the first chunk of a type in a PNG file, nil when there is none
+func pngChunk(data []byte, typ string) []byte {
+	if len(data) < 8 {
+		return nil
+	}
+	data = data[8:]
+	for len(data) >= 12 {
+		size := uint64(binary.BigEndian.Uint32(data))
+		if size+12 > uint64(len(data)) {
+			return nil
+		}
+		if string(data[4:8]) == typ {
+			return data[8 : 8+size]
+		}
+		data = data[12+size:]
+	}
+	return nil
+}
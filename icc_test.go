@@ -0,0 +1,258 @@
+package imagecoding
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/image/tiff"
+)
+
+// makeGrayProfile writes a gray profile with a gamma curve
+func makeGrayProfile(gamma float64) []byte {
+	profile := makeICCProfile(primariesSRGB, whiteD65, srgbCurve)
+	var out bytes.Buffer
+	header := make([]byte, 128)
+	copy(header, profile[:128])
+	copy(header[16:], "GRAY")
+	out.Write(header)
+	binary.Write(&out, binary.BigEndian, []uint32{1})
+	out.WriteString("kTRC")
+	binary.Write(&out, binary.BigEndian, []uint32{128 + 4 + 12, 14})
+	out.WriteString("curv\x00\x00\x00\x00")
+	binary.Write(&out, binary.BigEndian, uint32(1))
+	binary.Write(&out, binary.BigEndian, uint16(gamma*256))
+	binary.BigEndian.PutUint32(out.Bytes(), uint32(out.Len()))
+	return out.Bytes()
+}
+
+// pngWithICC adds an iCCP chunk after the IHDR chunk
+func pngWithICC(data, profile []byte) []byte {
+	var chunk bytes.Buffer
+	chunk.WriteString("iCCPtest\x00\x00")
+	z := zlib.NewWriter(&chunk)
+	z.Write(profile)
+	z.Close()
+
+	var out bytes.Buffer
+	out.Write(data[:33])
+	binary.Write(&out, binary.BigEndian, uint32(chunk.Len()-4))
+	out.Write(chunk.Bytes())
+	binary.Write(&out, binary.BigEndian, crc32.ChecksumIEEE(chunk.Bytes()))
+	out.Write(data[33:])
+	return out.Bytes()
+}
+
+// webpWithICC turns a simple WebP file into an extended one with an ICCP chunk
+func webpWithICC(data, profile []byte, width, height int) []byte {
+	var body bytes.Buffer
+	body.WriteString("WEBPVP8X")
+	binary.Write(&body, binary.LittleEndian, uint32(10))
+	body.Write([]byte{0x20, 0, 0, 0}) // ICC profile
+	body.Write([]byte{byte(width - 1), byte((width - 1) >> 8), byte((width - 1) >> 16)})
+	body.Write([]byte{byte(height - 1), byte((height - 1) >> 8), byte((height - 1) >> 16)})
+	body.WriteString("ICCP")
+	binary.Write(&body, binary.LittleEndian, uint32(len(profile)))
+	body.Write(profile)
+	if len(profile)%2 == 1 {
+		body.WriteByte(0)
+	}
+	body.Write(data[12:])
+
+	var out bytes.Buffer
+	out.WriteString("RIFF")
+	binary.Write(&out, binary.LittleEndian, uint32(body.Len()))
+	out.Write(body.Bytes())
+	return out.Bytes()
+}
+
+// tiffWithICC appends a copy of the first directory of a little endian TIFF file with an ICC profile tag
+func tiffWithICC(data, profile []byte) []byte {
+	ifd := binary.LittleEndian.Uint32(data[4:])
+	count := binary.LittleEndian.Uint16(data[ifd:])
+	entries := data[ifd+2 : ifd+2+12*uint32(count)]
+
+	out := append([]byte{}, data...)
+	profileOffset := uint32(len(out))
+	out = append(out, profile...)
+	if len(out)%2 == 1 {
+		out = append(out, 0)
+	}
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)))
+	// The tag is larger than the tags of the decoder, so it stays last in order
+	out = binary.LittleEndian.AppendUint16(out, count+1)
+	out = append(out, entries...)
+	out = binary.LittleEndian.AppendUint16(out, tiffTagICCProfile)
+	out = binary.LittleEndian.AppendUint16(out, 7)
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(profile)))
+	out = binary.LittleEndian.AppendUint32(out, profileOffset)
+	return binary.LittleEndian.AppendUint32(out, 0)
+}
+
+// makeP3Red returns an image in the sRGB red of Display P3 with a white row
+func makeP3Red() *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			img.Set(x, y, color.NRGBA{234, 51, 35, 255})
+			if y == 0 {
+				img.Set(x, y, color.White)
+			}
+		}
+	}
+	return img
+}
+
+func TestICCProfile(t *testing.T) {
+	profile := makeICCProfile(primariesP3, whiteD65, srgbCurve)
+	src := makeP3Red()
+
+	var buf bytes.Buffer
+	jpegData, err := EncodeJpegWithOptions(&buf, src, JpegEncodeOptions{Quality: 100, Subsampling: Subsampling444})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	jpegData = insertJpegSegment(jpegData, 0xe2, append([]byte("ICC_PROFILE\x00\x01\x01"), profile...))
+	pngData, err := EncodePng(&buf, src)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	pngData = pngWithICC(pngData, profile)
+	webpData, err := EncodeWebP(&buf, src)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	webpData = webpWithICC(webpData, profile, 8, 8)
+	var tiffBuf bytes.Buffer
+	if !assert.NoError(t, tiff.Encode(&tiffBuf, src, nil)) {
+		t.FailNow()
+	}
+	tiffData := tiffWithICC(tiffBuf.Bytes(), profile)
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"jpeg", jpegData},
+		{"png", pngData},
+		{"webp", webpData},
+		{"tiff", tiffData},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			embedded, err := ICCProfile(tt.data)
+			if assert.NoError(t, err) {
+				assert.Equal(t, profile, embedded)
+			}
+
+			// Without the option the colors stay as they are stored
+			res, err := TransformWithOptions(tt.data, TransformOptions{})
+			if !assert.NoError(t, err) {
+				return
+			}
+			c := res.Image.(*RGBImage).RGBAAt(4, 4)
+			assertRGB(t, [3]uint8{234, 51, 35}, c.R, c.G, c.B, 4)
+
+			res, err = TransformWithOptions(tt.data, TransformOptions{ConvertToSRGB: true})
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Empty(t, res.Warnings)
+			c = res.Image.(*RGBImage).RGBAAt(4, 4)
+			assertRGB(t, [3]uint8{255, 0, 0}, c.R, c.G, c.B, 4)
+			c = res.Image.(*RGBImage).RGBAAt(4, 0)
+			assertRGB(t, [3]uint8{255, 255, 255}, c.R, c.G, c.B, 2)
+
+			// Gray output is the gray of the converted colors
+			res, err = TransformWithOptions(tt.data, TransformOptions{ConvertToSRGB: true, ColorMode: ColorModeGray})
+			if assert.NoError(t, err) {
+				assert.InDelta(t, 76, int(res.Image.(*image.Gray).GrayAt(4, 4).Y), 4)
+			}
+		})
+	}
+
+	plain, err := EncodePng(&buf, src)
+	if assert.NoError(t, err) {
+		embedded, err := ICCProfile(plain)
+		assert.NoError(t, err)
+		assert.Nil(t, embedded)
+	}
+	_, err = ICCProfile(nil)
+	assert.Equal(t, ErrEmptyInput, err)
+
+	// The sample carries Adobe RGB (1998), which shares red with sRGB and has a wider green
+	sample, err := os.ReadFile("testdata/world-political.jpg")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	embedded, err := ICCProfile(sample)
+	if assert.NoError(t, err) && assert.NotNil(t, embedded) {
+		img := NewRGBImage(image.Rect(0, 0, 3, 1))
+		copy(img.Pix, []uint8{255, 0, 0, 128, 128, 128, 0, 255, 0})
+		out, err := ConvertToSRGB(img, embedded)
+		if assert.NoError(t, err) {
+			assertSimilar(t, &RGBImage{Pix: []uint8{255, 0, 0, 129, 129, 129, 0, 255, 0}, Stride: 9, Rect: img.Rect}, out, 2)
+		}
+		// Colors within sRGB come out more saturated
+		copy(img.Pix, []uint8{64, 128, 64})
+		out, err = ConvertToSRGB(img, embedded)
+		if assert.NoError(t, err) {
+			c := out.(*RGBImage).RGBAAt(0, 0)
+			assert.True(t, c.R < 60 && c.G > 128, "%v", c)
+		}
+	}
+}
+
+func TestConvertToSRGB(t *testing.T) {
+	out, err := ConvertToSRGB(makeP3Red(), makeICCProfile(primariesP3, whiteD65, srgbCurve))
+	if assert.NoError(t, err) {
+		c := out.(*RGBImage).RGBAAt(4, 4)
+		assertRGB(t, [3]uint8{255, 0, 0}, c.R, c.G, c.B, 2)
+	}
+
+	// sRGB images are left alone
+	src := makeP3Red()
+	out, err = ConvertToSRGB(src, makeICCProfile(primariesSRGB, whiteD65, srgbCurve))
+	if assert.NoError(t, err) {
+		assert.Same(t, src, out)
+	}
+
+	// A linear gray profile brightens the mid tones
+	gray := image.NewGray(image.Rect(0, 0, 3, 1))
+	copy(gray.Pix, []uint8{0, 128, 255})
+	out, err = ConvertToSRGB(gray, makeGrayProfile(1))
+	if assert.NoError(t, err) {
+		assert.Equal(t, []uint8{0, 188, 255}, out.(*image.Gray).Pix)
+	}
+
+	cmyk := image.NewCMYK(image.Rect(0, 0, 1, 1))
+	cmyk.SetCMYK(0, 0, color.CMYK{C: 255})
+	out, err = ConvertToSRGB(cmyk, makeCMYKProfile("mft2"))
+	if assert.NoError(t, err) {
+		c := out.(*RGBImage).RGBAAt(0, 0)
+		assertRGB(t, swappedInks(255, 0, 0, 0), c.R, c.G, c.B, 1)
+	}
+	_, err = ConvertToSRGB(gray, makeCMYKProfile("mft2"))
+	assert.Equal(t, errUnsupportedProfile, err)
+	_, err = ConvertToSRGB(gray, []byte("short"))
+	assert.Equal(t, errInvalidProfile, err)
+
+	// Unsupported profiles leave the colors as they are in a transform, with a warning
+	var buf bytes.Buffer
+	data, err := EncodePng(&buf, makeP3Red())
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	res, err := TransformWithOptions(pngWithICC(data, makeCMYKProfile("mft2")), TransformOptions{ConvertToSRGB: true})
+	if assert.NoError(t, err) {
+		assert.Len(t, res.Warnings, 1)
+		c := res.Image.(*RGBImage).RGBAAt(4, 4)
+		assertRGB(t, [3]uint8{234, 51, 35}, c.R, c.G, c.B, 0)
+	}
+}
@@ -0,0 +1,386 @@
+//go:build jp2
+// +build jp2
+
+package imagecoding
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"unsafe"
+
+	"github.com/disintegration/imaging"
+)
+
+// #cgo pkg-config: libopenjp2
+// #include <stdlib.h>
+// #include <string.h>
+// #include <openjpeg.h>
+//
+// #define GO_OPJ_MESSAGE_LENGTH 256
+//
+// typedef struct {
+//     const OPJ_BYTE *data;
+//     OPJ_SIZE_T size;
+//     OPJ_SIZE_T offset;
+// } goOpjSource;
+//
+// static OPJ_SIZE_T goOpjRead(void *buf, OPJ_SIZE_T n, void *user) {
+//     goOpjSource *src = (goOpjSource *)user;
+//     if (src->offset >= src->size) {
+//         return (OPJ_SIZE_T)-1;
+//     }
+//     if (n > src->size - src->offset) {
+//         n = src->size - src->offset;
+//     }
+//     memcpy(buf, src->data + src->offset, n);
+//     src->offset += n;
+//     return n;
+// }
+//
+// static OPJ_OFF_T goOpjSkip(OPJ_OFF_T n, void *user) {
+//     goOpjSource *src = (goOpjSource *)user;
+//     if (n < 0 && (OPJ_SIZE_T)-n > src->offset) {
+//         n = -(OPJ_OFF_T)src->offset;
+//     } else if (n > 0 && (OPJ_SIZE_T)n > src->size - src->offset) {
+//         n = (OPJ_OFF_T)(src->size - src->offset);
+//     }
+//     src->offset += n;
+//     return n;
+// }
+//
+// static OPJ_BOOL goOpjSeek(OPJ_OFF_T n, void *user) {
+//     goOpjSource *src = (goOpjSource *)user;
+//     if (n < 0 || (OPJ_SIZE_T)n > src->size) {
+//         return OPJ_FALSE;
+//     }
+//     src->offset = (OPJ_SIZE_T)n;
+//     return OPJ_TRUE;
+// }
+//
+// // Keep the first error, the later ones are consequences of it
+// static void goOpjError(const char *msg, void *message) {
+//     if (((char *)message)[0] == 0) {
+//         strncpy((char *)message, msg, GO_OPJ_MESSAGE_LENGTH - 1);
+//     }
+// }
+//
+// static void goOpjQuiet(const char *msg, void *client) {}
+//
+// typedef struct {
+//     opj_codec_t *codec;
+//     opj_stream_t *stream;
+//     opj_image_t *image;
+//     goOpjSource src;
+// } goOpjDecoder;
+//
+// static void goOpjClose(goOpjDecoder *dec) {
+//     if (dec->image != NULL) {
+//         opj_image_destroy(dec->image);
+//     }
+//     if (dec->stream != NULL) {
+//         opj_stream_destroy(dec->stream);
+//     }
+//     if (dec->codec != NULL) {
+//         opj_destroy_codec(dec->codec);
+//     }
+// }
+//
+// // goOpjOpen reads the header of a JP2 file or J2K codestream, decoding at 1/2^reduce
+// // of the full resolution. dec must be closed with goOpjClose, also on failure.
+// static int goOpjOpen(goOpjDecoder *dec, const OPJ_BYTE *data, size_t size, OPJ_CODEC_FORMAT format,
+//                      OPJ_UINT32 reduce, char *message) {
+//     opj_dparameters_t params;
+//
+//     memset(dec, 0, sizeof(*dec));
+//     dec->src.data = data;
+//     dec->src.size = size;
+//     dec->codec = opj_create_decompress(format);
+//     if (dec->codec == NULL) {
+//         return -1;
+//     }
+//     opj_set_info_handler(dec->codec, goOpjQuiet, NULL);
+//     opj_set_warning_handler(dec->codec, goOpjQuiet, NULL);
+//     opj_set_error_handler(dec->codec, goOpjError, message);
+//     opj_set_default_decoder_parameters(&params);
+//     params.cp_reduce = reduce;
+//     if (!opj_setup_decoder(dec->codec, &params)) {
+//         return -1;
+//     }
+//
+//     dec->stream = opj_stream_create(OPJ_J2K_STREAM_CHUNK_SIZE, OPJ_TRUE);
+//     if (dec->stream == NULL) {
+//         return -1;
+//     }
+//     opj_stream_set_read_function(dec->stream, goOpjRead);
+//     opj_stream_set_skip_function(dec->stream, goOpjSkip);
+//     opj_stream_set_seek_function(dec->stream, goOpjSeek);
+//     opj_stream_set_user_data(dec->stream, &dec->src, NULL);
+//     opj_stream_set_user_data_length(dec->stream, size);
+//     if (!opj_read_header(dec->stream, dec->codec, &dec->image) || dec->image->numcomps == 0) {
+//         return -1;
+//     }
+//     return 0;
+// }
+//
+// typedef struct {
+//     int width, height;
+//     int components;
+//     int resolutions;
+// } goOpjHeader;
+//
+// // goOpjInfo reads the size, the number of components and the number of resolution levels
+// int goOpjInfo(const OPJ_BYTE *data, size_t size, OPJ_CODEC_FORMAT format, goOpjHeader *header, char *message) {
+//     goOpjDecoder dec;
+//     opj_codestream_info_v2_t *info;
+//     OPJ_UINT32 c;
+//     int res = -1;
+//
+//     if (goOpjOpen(&dec, data, size, format, 0, message) == 0) {
+//         header->width = dec.image->x1 - dec.image->x0;
+//         header->height = dec.image->y1 - dec.image->y0;
+//         header->components = dec.image->numcomps;
+//         info = opj_get_cstr_info(dec.codec);
+//         if (info != NULL) {
+//             // Every component must have the reduced resolution
+//             header->resolutions = info->m_default_tile_info.tccp_info[0].numresolutions;
+//             for (c = 1; c < info->nbcomps; c++) {
+//                 if (info->m_default_tile_info.tccp_info[c].numresolutions < header->resolutions) {
+//                     header->resolutions = info->m_default_tile_info.tccp_info[c].numresolutions;
+//                 }
+//             }
+//             opj_destroy_cstr_info(&info);
+//             res = 0;
+//         }
+//     }
+//     goOpjClose(&dec);
+//     return res;
+// }
+//
+// // goOpjSample reads the sample of component c at the position of pixel x, y of the first component
+// // and scales it to 8 bits
+// static int goOpjSample(opj_image_t *image, OPJ_UINT32 c, OPJ_UINT32 x, OPJ_UINT32 y) {
+//     opj_image_comp_t *comp = &image->comps[c];
+//     OPJ_UINT32 cx = x * image->comps[0].dx / comp->dx;
+//     OPJ_UINT32 cy = y * image->comps[0].dy / comp->dy;
+//     int v;
+//
+//     if (cx >= comp->w) {
+//         cx = comp->w - 1;
+//     }
+//     if (cy >= comp->h) {
+//         cy = comp->h - 1;
+//     }
+//     v = comp->data[(size_t)cy * comp->w + cx];
+//     if (comp->sgnd) {
+//         v += 1 << (comp->prec - 1);
+//     }
+//     if (comp->prec > 8) {
+//         v >>= comp->prec - 8;
+//     } else if (comp->prec < 8) {
+//         v = v * 255 / ((1 << comp->prec) - 1);
+//     }
+//     return v < 0 ? 0 : v > 255 ? 255 : v;
+// }
+//
+// static uint8_t goOpjClamp(double v) {
+//     return v < 0 ? 0 : v > 255 ? 255 : (uint8_t)(v + 0.5);
+// }
+//
+// // goOpjDecode decodes at 1/2^reduce of the full resolution into 8-bit gray or RGB pixels,
+// // depending on the number of components. out must be freed by the caller.
+// int goOpjDecode(const OPJ_BYTE *data, size_t size, OPJ_CODEC_FORMAT format, OPJ_UINT32 reduce,
+//                 uint8_t **out, int *width, int *height, int *channels, char *message) {
+//     goOpjDecoder dec;
+//     opj_image_t *image;
+//     OPJ_UINT32 x, y;
+//     uint8_t *px;
+//     int ycc, res = -1;
+//     double yy, cb, cr;
+//
+//     if (goOpjOpen(&dec, data, size, format, reduce, message) != 0 ||
+//         !opj_decode(dec.codec, dec.stream, dec.image) ||
+//         !opj_end_decompress(dec.codec, dec.stream)) {
+//         goto done;
+//     }
+//     image = dec.image;
+//
+//     *width = image->comps[0].w;
+//     *height = image->comps[0].h;
+//     *channels = image->numcomps >= 3 ? 3 : 1;
+//     *out = malloc((size_t)*width * *height * *channels);
+//     if (*out == NULL) {
+//         goto done;
+//     }
+//     // Subsampled chroma without a color space is YCbCr, like opj_decompress assumes
+//     ycc = *channels == 3 && (image->color_space == OPJ_CLRSPC_SYCC ||
+//         (image->color_space == OPJ_CLRSPC_UNSPECIFIED &&
+//          (image->comps[1].dx > image->comps[0].dx || image->comps[1].dy > image->comps[0].dy)));
+//
+//     px = *out;
+//     for (y = 0; y < (OPJ_UINT32)*height; y++) {
+//         for (x = 0; x < (OPJ_UINT32)*width; x++) {
+//             if (*channels == 1) {
+//                 *px++ = goOpjSample(image, 0, x, y);
+//             } else if (ycc) {
+//                 yy = goOpjSample(image, 0, x, y);
+//                 cb = goOpjSample(image, 1, x, y) - 128.0;
+//                 cr = goOpjSample(image, 2, x, y) - 128.0;
+//                 *px++ = goOpjClamp(yy + 1.402 * cr);
+//                 *px++ = goOpjClamp(yy - 0.344136 * cb - 0.714136 * cr);
+//                 *px++ = goOpjClamp(yy + 1.772 * cb);
+//             } else {
+//                 *px++ = goOpjSample(image, 0, x, y);
+//                 *px++ = goOpjSample(image, 1, x, y);
+//                 *px++ = goOpjSample(image, 2, x, y);
+//             }
+//         }
+//     }
+//     res = 0;
+//
+// done:
+//     goOpjClose(&dec);
+//     return res;
+// }
+import "C"
+
+// ConfigJp2 reads the size of a JP2 file or a J2K codestream
+func ConfigJp2(data []byte) (image.Config, string, error) {
+	format := jp2Format(data)
+	header, err := jp2Info(data)
+	if err != nil {
+		return image.Config{}, string(format), err
+	}
+	cfg := image.Config{
+		ColorModel: color.GrayModel,
+		Width:      int(header.width),
+		Height:     int(header.height),
+	}
+	if header.components >= 3 {
+		cfg.ColorModel = RGBModel
+	}
+	return cfg, string(format), nil
+}
+
+// TransformJp2 is like TransformJpeg for JPEG 2000 images.
+// OpenJPEG decodes at a reduced resolution where possible, which makes large scans cheap to downscale.
+func TransformJp2(data []byte, grayscale bool, scale ScaleFunc) (out image.Image, width, height int, scaleFactor float64, err error) {
+	res, err := TransformJp2WithOptions(data, legacyOptions(grayscale, scale))
+	if err != nil {
+		return nil, 0, 0, 0, err
+	}
+	return res.Image, res.Width, res.Height, res.ScaleFactor, nil
+}
+
+// TransformJp2WithOptions is like TransformJp2 but configured through TransformOptions
+func TransformJp2WithOptions(data []byte, opts TransformOptions) (TransformResult, error) {
+	if len(data) == 0 {
+		return TransformResult{}, ErrEmptyInput
+	}
+	opts = opts.withDefaults()
+	format := jp2Format(data)
+
+	header, err := jp2Info(data)
+	if err != nil {
+		return TransformResult{}, err
+	}
+	width, height := int(header.width), int(header.height)
+	if err = opts.Limits.check(width, height); err != nil {
+		return TransformResult{}, err
+	}
+
+	scaledW, scaledH, scaleFactor := opts.Scale(width, height)
+	reduce := 0
+	if opts.resizes(scaleFactor) {
+		reduce = jp2Reduce(int(header.resolutions), scaleFactor)
+	} else {
+		scaleFactor = 1
+	}
+
+	var out *C.uint8_t
+	var outW, outH, channels C.int
+	var message [C.GO_OPJ_MESSAGE_LENGTH]C.char
+	res := C.goOpjDecode(
+		(*C.OPJ_BYTE)(unsafe.Pointer(&data[0])), C.size_t(len(data)), jp2Codec(format), C.OPJ_UINT32(reduce),
+		&out, &outW, &outH, &channels, &message[0],
+	)
+	if out != nil {
+		defer C.free(unsafe.Pointer(out))
+	}
+	if res != 0 {
+		return TransformResult{}, fmt.Errorf("could not decode jpeg 2000: %v", C.GoString(&message[0]))
+	}
+
+	pix := C.GoBytes(unsafe.Pointer(out), outW*outH*channels)
+	rect := image.Rect(0, 0, int(outW), int(outH))
+	var img image.Image
+	if channels == 1 {
+		img = &image.Gray{Pix: pix, Stride: int(outW), Rect: rect}
+	} else {
+		img = &RGBImage{Pix: pix, Stride: int(outW) * 3, Rect: rect}
+	}
+	result := TransformResult{
+		Format:      format,
+		Width:       width,
+		Height:      height,
+		ScaleFactor: scaleFactor,
+		Orientation: TopLeft,
+		ColorModel:  img.ColorModel(),
+	}
+
+	// Scale the rest of the way from the reduced resolution
+	if scaleFactor != 1 && (scaledW != int(outW) || scaledH != int(outH)) {
+		img = imaging.Resize(img, scaledW, scaledH, opts.Filter)
+	}
+	if opts.ColorMode == ColorModeGray {
+		img = toGray(img)
+	} else {
+		img = toRGB(img)
+	}
+	result.setImage(img)
+	result.setMapping(result.ScaleX, result.ScaleY)
+	return result, nil
+}
+
+// jp2Reduce returns the number of resolution levels to skip for a scale factor. Every level halves
+// the size, the smallest one that is still at least as large as the output is decoded.
+func jp2Reduce(resolutions int, scaleFactor float64) int {
+	reduce := 0
+	for reduce+1 < resolutions && math.Ldexp(1, -(reduce+1)) >= scaleFactor {
+		reduce++
+	}
+	return reduce
+}
+
+// jp2Format tells a JP2 file from a bare J2K codestream
+func jp2Format(data []byte) ImgFormat {
+	if isJ2k(data) {
+		return J2k
+	}
+	return Jp2
+}
+
+func jp2Codec(format ImgFormat) C.OPJ_CODEC_FORMAT {
+	if format == J2k {
+		return C.OPJ_CODEC_J2K
+	}
+	return C.OPJ_CODEC_JP2
+}
+
+// jp2Info reads the header of a JP2 file or a J2K codestream
+func jp2Info(data []byte) (C.goOpjHeader, error) {
+	var header C.goOpjHeader
+	if len(data) == 0 {
+		return header, ErrEmptyInput
+	}
+	var message [C.GO_OPJ_MESSAGE_LENGTH]C.char
+	res := C.goOpjInfo(
+		(*C.OPJ_BYTE)(unsafe.Pointer(&data[0])), C.size_t(len(data)), jp2Codec(jp2Format(data)),
+		&header, &message[0],
+	)
+	if res != 0 {
+		return header, fmt.Errorf("could not read jpeg 2000 header: %v", C.GoString(&message[0]))
+	}
+	return header, nil
+}
@@ -0,0 +1,18 @@
+//go:build !jp2
+// +build !jp2
+
+package imagecoding
+
+import "image"
+
+func ConfigJp2(data []byte) (image.Config, string, error) {
+	return image.Config{}, "", image.ErrFormat
+}
+
+func TransformJp2(data []byte, grayscale bool, scale ScaleFunc) (out image.Image, width, height int, scaleFactor float64, err error) {
+	return nil, 0, 0, 0, image.ErrFormat
+}
+
+func TransformJp2WithOptions(data []byte, opts TransformOptions) (TransformResult, error) {
+	return TransformResult{}, image.ErrFormat
+}
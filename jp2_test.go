@@ -0,0 +1,124 @@
+//go:build jp2
+// +build jp2
+
+package imagecoding
+
+import (
+	"image"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// The testdata files have empty code-blocks, every pixel decodes to mid gray at every resolution
+
+func TestConfigJp2(t *testing.T) {
+	tests := []struct {
+		filename      string
+		format        ImgFormat
+		width, height int
+		gray          bool
+	}{
+		{"testdata/gray.j2k", J2k, 400, 240, true},
+		{"testdata/rgb.jp2", Jp2, 200, 120, false},
+	}
+	for _, tt := range tests {
+		data, err := os.ReadFile(tt.filename)
+		if err != nil {
+			t.Fatalf("Error reading file %v", err)
+		}
+		cfg, format, err := DecodeConfig(data)
+		if assert.NoError(t, err, tt.filename) {
+			assert.Equal(t, string(tt.format), format)
+			assert.Equal(t, tt.width, cfg.Width)
+			assert.Equal(t, tt.height, cfg.Height)
+			assert.Equal(t, tt.gray, cfg.ColorModel != RGBModel)
+		}
+	}
+}
+
+func TestTransformJp2(t *testing.T) {
+	data, err := os.ReadFile("testdata/rgb.jp2")
+	if err != nil {
+		t.Fatalf("Error reading file %v", err)
+	}
+	res, err := TransformWithOptions(data, TransformOptions{Scale: noScale})
+	if assert.NoError(t, err) {
+		assert.Equal(t, Jp2, res.Format)
+		assert.Equal(t, RGBModel, res.ColorModel)
+		assert.Equal(t, image.Rect(0, 0, 200, 120), res.Image.Bounds())
+		c := res.Image.(*RGBImage).RGBAAt(100, 60)
+		assertRGB(t, [3]uint8{128, 128, 128}, c.R, c.G, c.B, 1)
+	}
+
+	res, err = TransformWithOptions(data, TransformOptions{Scale: noScale, ColorMode: ColorModeGray})
+	if assert.NoError(t, err) {
+		gray := res.Image.(*image.Gray)
+		assert.InDelta(t, 128, gray.GrayAt(0, 0).Y, 1)
+	}
+
+	_, err = TransformWithOptions(data[:len(data)/2], TransformOptions{})
+	assert.Error(t, err)
+}
+
+func TestJp2Reduce(t *testing.T) {
+	tests := []struct {
+		scaleFactor float64
+		reduce      int
+	}{
+		{1, 0},
+		{0.6, 0},
+		{0.5, 1},
+		{0.3, 1},
+		{0.25, 2},
+		{0.125, 3},
+		// The codestream has 3 decomposition levels, so 4 resolutions
+		{0.0625, 3},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.reduce, jp2Reduce(4, tt.scaleFactor), "%v", tt.scaleFactor)
+	}
+}
+
+func TestTransformJp2Scaled(t *testing.T) {
+	data, err := os.ReadFile("testdata/gray.j2k")
+	if err != nil {
+		t.Fatalf("Error reading file %v", err)
+	}
+	tests := []struct {
+		name          string
+		scale         ScaleFunc
+		width, height int
+	}{
+		// Decoded at 1/4 of the resolution, which is the output size
+		{"reduced", ExactWidth(100), 100, 60},
+		// The smallest resolution is 1/8, the rest is resized
+		{"below the smallest resolution", ExactWidth(25), 25, 15},
+		// Decoded at 1/2 and resized
+		{"between resolutions", ExactWidth(120), 120, 72},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := TransformWithOptions(data, TransformOptions{Scale: tt.scale})
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, J2k, res.Format)
+			assert.Equal(t, 400, res.Width)
+			assert.Equal(t, 240, res.Height)
+			assert.Equal(t, tt.width, res.OutWidth)
+			assert.Equal(t, tt.height, res.OutHeight)
+			_, _, s := tt.scale(400, 240)
+			assert.Equal(t, s, res.ScaleFactor)
+
+			// The output maps back onto the full resolution source
+			assert.Equal(t, image.Rect(0, 0, 400, 240), res.ToSource.Rect(res.Image.Bounds()))
+			gray := res.Image.(*image.Gray)
+			assert.InDelta(t, 128, gray.GrayAt(tt.width/2, tt.height/2).Y, 1)
+		})
+	}
+
+	_, err = TransformWithOptions(data, TransformOptions{Limits: Limits{MaxPixels: 1000}})
+	assert.Equal(t, ErrImageTooLarge, err)
+}
@@ -23,44 +23,102 @@ import (
 //                         pixelFormat, &jpegBuf, jpegSize,
 //                         jpegSubsamp, jpegQual, flags | TJFLAG_NOREALLOC);
 //}
+// int goTjCompressFromYUVPlanes(tjhandle handle, const unsigned char *y,
+//                               const unsigned char *cb, const unsigned char *cr,
+//                               int width, int yStride, int cStride, int height, int subsamp,
+//                               unsigned char *jpegBuf, unsigned long *jpegSize,
+//                               int jpegQual, int flags) {
+//      const unsigned char *planes[3] = {y, cb, cr};
+//      int strides[3] = {yStride, cStride, cStride};
+//      return tjCompressFromYUVPlanes(handle, planes, width, strides, height,
+//                                     subsamp, &jpegBuf, jpegSize,
+//                                     jpegQual, flags | TJFLAG_NOREALLOC);
+//}
+// int goTjScaled(int dimension, tjscalingfactor scalingFactor) {
+//      return TJSCALED(dimension, scalingFactor);
+//}
 import "C"
 
 type TurboJpegOperation C.int
 
+// EncodeJpeg will encode an image to JPEG bytes, using libjpeg-turbo for performance.
+// It uses 4:2:0 subsampling, see EncodeJpegWithOptions for the other settings.
 func EncodeJpeg(buf *bytes.Buffer, img image.Image, quality int) ([]byte, error) {
-	var pix []uint8
-	var format, stride, jpegSubsamp, cWidth, cHeight, flags, jpegQual, res C.int
-
-	cWidth = C.int(img.Bounds().Dx())
-	cHeight = C.int(img.Bounds().Dy())
-	switch v := img.(type) {
-	case *image.Gray:
-		pix = v.Pix
-		stride = C.int(v.Stride)
-		format = C.TJPF_GRAY
-		jpegSubsamp = C.TJSAMP_GRAY
-	case *RGBImage:
-		pix = v.Pix
-		stride = C.int(v.Stride)
+	return EncodeJpegWithOptions(buf, img, JpegEncodeOptions{Quality: quality})
+}
+
+// tjSubsampling maps a YCbCr subsample ratio to the matching libjpeg-turbo subsampling
+func tjSubsampling(ratio image.YCbCrSubsampleRatio) (C.int, bool) {
+	switch ratio {
+	case image.YCbCrSubsampleRatio444:
+		return C.TJSAMP_444, true
+	case image.YCbCrSubsampleRatio422:
+		return C.TJSAMP_422, true
+	case image.YCbCrSubsampleRatio420:
+		return C.TJSAMP_420, true
+	case image.YCbCrSubsampleRatio440:
+		return C.TJSAMP_440, true
+	case image.YCbCrSubsampleRatio411:
+		return C.TJSAMP_411, true
+	default:
+		return 0, false
+	}
+}
+
+// tjSupportsYCbCr reports whether the planes of a YCbCr image can be compressed as they are
+func tjSupportsYCbCr(img *image.YCbCr) bool {
+	_, ok := tjSubsampling(img.SubsampleRatio)
+	return ok && img.Rect.Min == (image.Point{})
+}
+
+// tjFlags returns the TurboJPEG flags for the encode options
+func tjFlags(opts JpegEncodeOptions) C.int {
+	flags := C.int(C.TJFLAG_NOREALLOC)
+	if opts.Progressive {
+		flags |= C.TJFLAG_PROGRESSIVE
+	}
+	if opts.DCTMethod == DCTFast {
+		flags |= C.TJFLAG_FASTDCT
+	}
+	return flags
+}
+
+// tjCompressPixels compresses interleaved pixels with TurboJPEG
+func tjCompressPixels(buf *bytes.Buffer, px jpegPixels, opts JpegEncodeOptions) ([]byte, error) {
+	var format, jpegSubsamp C.int
+	switch px.layout {
+	case jpegGray:
+		format, jpegSubsamp = C.TJPF_GRAY, C.TJSAMP_GRAY
+	case jpegRGB:
 		format = C.TJPF_RGB
-		jpegSubsamp = C.TJSAMP_420
-	case *image.RGBA:
-		pix = v.Pix
-		stride = C.int(v.Stride)
+	case jpegRGBX:
 		format = C.TJPF_RGBX
-		jpegSubsamp = C.TJSAMP_420
-	default:
-		return nil, errors.New("unsupported image type")
+	case jpegCMYK:
+		// Chroma subsampling of CMYK visibly shifts colors
+		format, jpegSubsamp = C.TJPF_CMYK, C.TJSAMP_444
+	}
+	if px.layout == jpegRGB || px.layout == jpegRGBX {
+		switch opts.Subsampling {
+		case Subsampling444:
+			jpegSubsamp = C.TJSAMP_444
+		case Subsampling422:
+			jpegSubsamp = C.TJSAMP_422
+		case Subsampling440:
+			jpegSubsamp = C.TJSAMP_440
+		default:
+			jpegSubsamp = C.TJSAMP_420
+		}
 	}
 
+	cWidth := C.int(px.width)
+	cHeight := C.int(px.height)
+
 	tjHandle := C.tjInitCompress()
 	if tjHandle == nil {
 		return nil, fmt.Errorf("could not init libjpeg-turbo: %v", C.GoString(C.tjGetErrorStr2(tjHandle)))
 	}
 	defer C.tjDestroy(tjHandle)
 
-	jpegQual = C.int(quality)
-	flags = C.TJFLAG_NOREALLOC
 	imageBytes := C.tjBufSize(cWidth, cHeight, jpegSubsamp)
 
 	// Prepare a buffer
@@ -68,14 +126,57 @@ func EncodeJpeg(buf *bytes.Buffer, img image.Image, quality int) ([]byte, error)
 	buf.Grow(int(imageBytes))
 	buf.WriteByte(0)
 
-	res = C.goTjCompress2(
+	res := C.goTjCompress2(
 		tjHandle,
-		(*C.uchar)(unsafe.Pointer(&pix[0])),
-		cWidth, stride, cHeight,
+		(*C.uchar)(unsafe.Pointer(&px.pix[0])),
+		cWidth, C.int(px.stride), cHeight,
 		format,
 		(*C.uchar)(unsafe.Pointer(&(buf.Bytes())[0])),
 		&imageBytes,
-		jpegSubsamp, jpegQual, flags,
+		jpegSubsamp, C.int(opts.Quality), tjFlags(opts),
+	)
+	if res != 0 {
+		if C.tjGetErrorCode(tjHandle) == C.TJERR_WARNING {
+			zap.L().Warn(
+				"jpeg compress warning",
+				zap.String("jpgerror", C.GoString(C.tjGetErrorStr2(tjHandle))),
+			)
+		} else {
+			return nil, fmt.Errorf("could not compress jpeg: %v", C.GoString(C.tjGetErrorStr2(tjHandle)))
+		}
+	}
+
+	return buf.Bytes()[:int(imageBytes)], nil
+}
+
+// tjCompressYCbCr compresses the planes of a YCbCr image directly, skipping color conversion
+func tjCompressYCbCr(buf *bytes.Buffer, img *image.YCbCr, opts JpegEncodeOptions) ([]byte, error) {
+	subsamp, _ := tjSubsampling(img.SubsampleRatio)
+	cWidth := C.int(img.Rect.Dx())
+	cHeight := C.int(img.Rect.Dy())
+
+	tjHandle := C.tjInitCompress()
+	if tjHandle == nil {
+		return nil, fmt.Errorf("could not init libjpeg-turbo: %v", C.GoString(C.tjGetErrorStr2(tjHandle)))
+	}
+	defer C.tjDestroy(tjHandle)
+
+	imageBytes := C.tjBufSize(cWidth, cHeight, subsamp)
+
+	// Prepare a buffer
+	buf.Reset()
+	buf.Grow(int(imageBytes))
+	buf.WriteByte(0)
+
+	res := C.goTjCompressFromYUVPlanes(
+		tjHandle,
+		(*C.uchar)(unsafe.Pointer(&img.Y[0])),
+		(*C.uchar)(unsafe.Pointer(&img.Cb[0])),
+		(*C.uchar)(unsafe.Pointer(&img.Cr[0])),
+		cWidth, C.int(img.YStride), C.int(img.CStride), cHeight, subsamp,
+		(*C.uchar)(unsafe.Pointer(&(buf.Bytes())[0])),
+		&imageBytes,
+		C.int(opts.Quality), tjFlags(opts),
 	)
 	if res != 0 {
 		if C.tjGetErrorCode(tjHandle) == C.TJERR_WARNING {
@@ -208,44 +309,93 @@ func ConfigJpeg(data []byte) (image.Config, string, error) {
 }
 
 // TransformJpeg will scale and colormap an input JPEG file to an image.Gray or RGBImage
-// This will use libjpeg-turbo to do it as efficiently as possible, utilizing DCT factors for fast scaling
+// This will use libjpeg-turbo to do it as efficiently as possible, utilizing DCT factors for fast scaling.
+// CMYK and YCCK images are converted with their embedded CMYK ICC profile, or as ideal inks without one.
 func TransformJpeg(data []byte, grayscale bool, scale ScaleFunc) (out image.Image, width, height int, scaleFactor float64, err error) {
+	res, err := TransformJpegWithOptions(data, legacyOptions(grayscale, scale))
+	if err != nil {
+		return nil, 0, 0, -1, err
+	}
+	return res.Image, res.Width, res.Height, res.ScaleFactor, nil
+}
+
+// TransformJpegWithOptions is like TransformJpeg but configured through TransformOptions
+func TransformJpegWithOptions(data []byte, opts TransformOptions) (TransformResult, error) {
 	if len(data) == 0 {
-		return nil, 0, 0, -1, ErrEmptyInput
+		return TransformResult{}, ErrEmptyInput
 	}
+	opts = opts.withDefaults()
+	result := TransformResult{Format: Jpeg, Orientation: TopLeft}
 
 	// Init Turbo-JPEG Decompression
 	tjHandle := C.tjInitDecompress()
 	if tjHandle == nil {
-		return nil, 0, 0, -1, fmt.Errorf("could not init libjpeg-turbo: %v", C.GoString(C.tjGetErrorStr()))
+		return TransformResult{}, fmt.Errorf("could not init libjpeg-turbo: %v", C.GoString(C.tjGetErrorStr()))
 	}
 	defer C.tjDestroy(tjHandle)
 
-	// Detect orientation
-	orientation := GetOrientation(bytes.NewReader(data))
-	if orientation != TopLeft {
-		data, err = ReOrientJpeg(data, orientation)
+	// Check the limits before doing any work on the image
+	conf, _, err := ConfigJpeg(data)
+	if err != nil {
+		return TransformResult{}, err
+	}
+	if err = opts.Limits.check(conf.Width, conf.Height); err != nil {
+		return TransformResult{}, err
+	}
+	result.ColorModel = conf.ColorModel
+
+	// libjpeg-turbo does not convert CMYK, the inks are decoded and converted to RGB afterwards
+	cmyk := conf.ColorModel == color.CMYKModel
+	var inverted bool
+	var profile []byte
+	var cmykProfile *cmykTransform
+	if cmyk || opts.ConvertToSRGB {
+		segments, err := jpegSegments(data)
 		if err != nil {
-			return nil, 0, 0, -1, err
+			result.Warnings = append(result.Warnings, fmt.Sprintf("could not read the jpeg markers: %v", err))
 		}
+		_, inverted = jpegAdobeTransform(segments)
+		profile = jpegICCProfile(segments)
 	}
+	if cmyk && profile != nil {
+		cmykProfile, err = cmykColorTransform(profile)
+		if err != nil {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("could not use the cmyk color profile: %v", err))
+		}
+	}
+	// Colors are converted to sRGB before they are dropped for gray output
+	convert := opts.ConvertToSRGB && profile != nil && !cmyk
 
-	// Read the size of the jpeg
-	conf, _, err := ConfigJpeg(data)
-	if err != nil {
-		return nil, 0, 0, -1, err
+	// Detect orientation
+	if opts.Orientation == OrientationAuto {
+		orientation := GetOrientation(bytes.NewReader(data))
+		if orientation != TopLeft {
+			data, err = ReOrientJpeg(data, orientation)
+			if err != nil {
+				return TransformResult{}, err
+			}
+			// Read the size of the reoriented jpeg
+			conf, _, err = ConfigJpeg(data)
+			if err != nil {
+				return TransformResult{}, err
+			}
+		}
+		result.Orientation = orientation
 	}
-	width = conf.Width
-	height = conf.Height
+	width := conf.Width
+	height := conf.Height
 
 	// Calculate our preferred scaling factor
-	_, _, prefScaleFactor := scale(width, height)
+	_, _, prefScaleFactor := opts.Scale(width, height)
+	if !opts.resizes(prefScaleFactor) {
+		prefScaleFactor = 1
+	}
 
 	// Find the closest match for a DCT scaling
 	var cNumScaleFactor C.int
 	cScaleFactors := C.tjGetScalingFactors(&cNumScaleFactor)
 	if cScaleFactors == nil {
-		return nil, 0, 0, -1, errors.New("could not get libjpeg-turbo scale factors")
+		return TransformResult{}, errors.New("could not get libjpeg-turbo scale factors")
 	}
 	scaleFactors := uintptr(unsafe.Pointer(cScaleFactors))
 
@@ -266,17 +416,24 @@ func TransformJpeg(data []byte, grayscale bool, scale ScaleFunc) (out image.Imag
 
 	// Calculate the final image size
 	sf := (*C.tjscalingfactor)(unsafe.Pointer(scaleFactors + selectedScaleFactor))
-	scaledW := int(math.RoundToEven(float64(C.int(width)*sf.num+sf.denom-1) / float64(sf.denom)))
-	scaledH := int(math.RoundToEven(float64(C.int(height)*sf.num+sf.denom-1) / float64(sf.denom)))
-	scaleFactor = float64(sf.num) / float64(sf.denom)
+	// The size libjpeg-turbo decodes to, partial pixels are rounded up
+	scaledW := int(C.goTjScaled(C.int(width), *sf))
+	scaledH := int(C.goTjScaled(C.int(height), *sf))
+	scaleFactor := float64(sf.num) / float64(sf.denom)
 
 	// Calculate the image stride and pitch
 	var pixelFormat C.int
 	var pitch int
-	if grayscale {
+	grayscale := opts.ColorMode == ColorModeGray
+	decodeGray := grayscale && !(convert && iccColorSpace(profile) == "RGB ")
+	switch {
+	case cmyk:
+		pixelFormat = C.TJPF_CMYK
+		pitch = scaledW * 4 // C.tjPixelSize[C.TJPF_CMYK]
+	case decodeGray:
 		pixelFormat = C.TJPF_GRAY
 		pitch = scaledW * 1 // C.tjPixelSize[C.TJPF_GRAY]
-	} else {
+	default:
 		pixelFormat = C.TJPF_RGB
 		pitch = scaledW * 3 // C.tjPixelSize[C.TJPF_RGB]
 	}
@@ -295,27 +452,55 @@ func TransformJpeg(data []byte, grayscale bool, scale ScaleFunc) (out image.Imag
 	)
 	if res != 0 {
 		if C.tjGetErrorCode(tjHandle) == C.TJERR_WARNING {
+			warning := C.GoString(C.tjGetErrorStr2(tjHandle))
 			zap.L().Warn(
 				"jpeg decompress warning",
-				zap.String("jpgerror", C.GoString(C.tjGetErrorStr())),
+				zap.String("jpgerror", warning),
 			)
+			result.Warnings = append(result.Warnings, warning)
 		} else {
-			return nil, 0, 0, -1, fmt.Errorf("could not decompress jpeg: %v", C.GoString(C.tjGetErrorStr()))
+			return TransformResult{}, fmt.Errorf("could not decompress jpeg: %v", C.GoString(C.tjGetErrorStr2(tjHandle)))
 		}
 	}
 	var img image.Image
-	if grayscale {
+	switch {
+	case cmyk:
+		img = cmykToRGB(&image.CMYK{
+			Pix:    buf,
+			Stride: pitch,
+			Rect:   image.Rect(0, 0, scaledW, scaledH),
+		}, inverted, cmykProfile)
+		if grayscale {
+			img = toGray(img)
+		}
+	case decodeGray:
 		img = &image.Gray{
 			Pix:    buf,
 			Stride: pitch,
 			Rect:   image.Rect(0, 0, scaledW, scaledH),
 		}
-	} else {
+	default:
 		img = &RGBImage{
 			Pix:    buf,
 			Stride: pitch,
 			Rect:   image.Rect(0, 0, scaledW, scaledH),
 		}
 	}
-	return img, width, height, scaleFactor, nil
+	if convert {
+		converted, err := ConvertToSRGB(img, profile)
+		if err != nil {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("could not convert the color profile to sRGB: %v", err))
+		} else if grayscale {
+			img = toGray(converted)
+		} else {
+			img = toRGB(converted)
+		}
+	}
+	result.Width = width
+	result.Height = height
+	result.ScaleFactor = scaleFactor
+	result.setImage(img)
+	// DCT scaling is exact, the last row and column of blocks are merely partial
+	result.setMapping(scaleFactor, scaleFactor)
+	return result, nil
 }
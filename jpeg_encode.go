@@ -0,0 +1,360 @@
+package imagecoding
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"unsafe"
+)
+
+// #cgo pkg-config: libjpeg
+// #include <stdio.h>
+// #include <stdlib.h>
+// #include <setjmp.h>
+// #include <jpeglib.h>
+//
+// typedef struct {
+//     struct jpeg_error_mgr pub;
+//     jmp_buf jmp;
+// } goJpegErrorMgr;
+//
+// static void goJpegErrorExit(j_common_ptr cinfo) {
+//     longjmp(((goJpegErrorMgr *)cinfo->err)->jmp, 1);
+// }
+//
+// // Warnings are not fatal, keep them off stderr
+// static void goJpegOutputMessage(j_common_ptr cinfo) {}
+//
+// typedef struct {
+//     int quality;
+//     int hSamp, vSamp;
+//     int progressive;
+//     int optimize;
+//     int arithmetic;
+//     int restartInterval;
+//     int dctMethod;
+// } goJpegOptions;
+//
+// // goJpegCompress compresses interleaved pixels with the libjpeg API, for the settings TurboJPEG
+// // does not expose. On failure it returns non-zero and writes the libjpeg message to message.
+// int goJpegCompress(const unsigned char *pix, int width, int height, int stride,
+//                    int components, J_COLOR_SPACE colorspace, goJpegOptions *opts,
+//                    unsigned char **out, unsigned long *outSize, char *message) {
+//     struct jpeg_compress_struct cinfo;
+//     goJpegErrorMgr jerr;
+//     JSAMPROW row;
+//     int c;
+//
+//     cinfo.err = jpeg_std_error(&jerr.pub);
+//     jerr.pub.error_exit = goJpegErrorExit;
+//     jerr.pub.output_message = goJpegOutputMessage;
+//     if (setjmp(jerr.jmp)) {
+//         (*cinfo.err->format_message)((j_common_ptr)&cinfo, message);
+//         jpeg_destroy_compress(&cinfo);
+//         return -1;
+//     }
+//     jpeg_create_compress(&cinfo);
+//     jpeg_mem_dest(&cinfo, out, outSize);
+//
+//     cinfo.image_width = width;
+//     cinfo.image_height = height;
+//     cinfo.input_components = components;
+//     cinfo.in_color_space = colorspace;
+//     jpeg_set_defaults(&cinfo);
+//     jpeg_set_quality(&cinfo, opts->quality, TRUE);
+//
+//     // Luma, and black for YCCK, carries the sampling factors, chroma stays at 1x1
+//     for (c = 0; c < cinfo.num_components; c++) {
+//         int luma = c == 0 || c == 3;
+//         cinfo.comp_info[c].h_samp_factor = luma ? opts->hSamp : 1;
+//         cinfo.comp_info[c].v_samp_factor = luma ? opts->vSamp : 1;
+//     }
+//     if (cinfo.num_components == 1) {
+//         cinfo.comp_info[0].h_samp_factor = 1;
+//         cinfo.comp_info[0].v_samp_factor = 1;
+//     }
+//
+//     cinfo.optimize_coding = opts->optimize ? TRUE : FALSE;
+//     cinfo.arith_code = opts->arithmetic ? TRUE : FALSE;
+//     cinfo.restart_interval = opts->restartInterval;
+//     cinfo.dct_method = (J_DCT_METHOD)opts->dctMethod;
+//     if (opts->progressive) {
+//         jpeg_simple_progression(&cinfo);
+//     }
+//
+//     jpeg_start_compress(&cinfo, TRUE);
+//     while (cinfo.next_scanline < cinfo.image_height) {
+//         row = (JSAMPROW)(pix + (size_t)cinfo.next_scanline * stride);
+//         jpeg_write_scanlines(&cinfo, &row, 1);
+//     }
+//     jpeg_finish_compress(&cinfo);
+//     jpeg_destroy_compress(&cinfo);
+//     return 0;
+// }
+import "C"
+
+// DefaultJpegQuality is the quality used when JpegEncodeOptions.Quality is zero
+const DefaultJpegQuality = 75
+
+// JpegSubsampling selects the chroma subsampling of color JPEGs
+type JpegSubsampling int
+
+const (
+	// SubsamplingAuto keeps the subsampling of YCbCr images and uses 4:2:0 for other color images
+	SubsamplingAuto JpegSubsampling = iota
+	// Subsampling420 halves the chroma resolution in both directions
+	Subsampling420
+	// Subsampling444 keeps full chroma resolution, best for thin colored text
+	Subsampling444
+	// Subsampling422 halves the chroma resolution horizontally
+	Subsampling422
+	// Subsampling440 halves the chroma resolution vertically
+	Subsampling440
+)
+
+// DCTMethod selects the forward DCT implementation
+type DCTMethod int
+
+const (
+	// DCTAccurate is the accurate integer DCT
+	DCTAccurate DCTMethod = iota
+	// DCTFast is the fast but less accurate integer DCT
+	DCTFast
+	// DCTFloat is the floating point DCT
+	DCTFloat
+)
+
+// JpegEncodeOptions configures EncodeJpegWithOptions.
+// The zero value matches EncodeJpeg at DefaultJpegQuality.
+type JpegEncodeOptions struct {
+	// Quality ranges from 1 to 100, zero uses DefaultJpegQuality
+	Quality int
+	// Subsampling of the chroma channels, ignored for gray and CMYK images. YCbCr images with the
+	// selected subsampling are compressed from their planes, other YCbCr images are resampled.
+	Subsampling JpegSubsampling
+	// Progressive writes a progressive JPEG that renders incrementally while loading
+	Progressive bool
+	// OptimizeHuffman computes optimal Huffman tables for smaller files at some speed cost
+	OptimizeHuffman bool
+	// Arithmetic uses arithmetic instead of Huffman coding, not every reader supports it
+	Arithmetic bool
+	// RestartInterval is the number of MCUs between restart markers, zero writes none
+	RestartInterval int
+	// DCTMethod selects the DCT implementation
+	DCTMethod DCTMethod
+	// Metadata is written to the JFIF marker and to EXIF and ICC profile markers
+	Metadata EncodeMetadata
+}
+
+func (o JpegEncodeOptions) withDefaults() JpegEncodeOptions {
+	if o.Quality == 0 {
+		o.Quality = DefaultJpegQuality
+	}
+	return o
+}
+
+// turboCompatible reports whether TurboJPEG can encode with these options,
+// the remaining settings are only reachable through the libjpeg API
+func (o JpegEncodeOptions) turboCompatible() bool {
+	return !o.OptimizeHuffman && !o.Arithmetic && o.RestartInterval == 0 && o.DCTMethod != DCTFloat
+}
+
+// jpegSubsamplingOf returns the subsampling of a YCbCr subsample ratio, if it has one
+func jpegSubsamplingOf(ratio image.YCbCrSubsampleRatio) (JpegSubsampling, bool) {
+	switch ratio {
+	case image.YCbCrSubsampleRatio420:
+		return Subsampling420, true
+	case image.YCbCrSubsampleRatio444:
+		return Subsampling444, true
+	case image.YCbCrSubsampleRatio422:
+		return Subsampling422, true
+	case image.YCbCrSubsampleRatio440:
+		return Subsampling440, true
+	default:
+		return SubsamplingAuto, false
+	}
+}
+
+// samplingFactors returns the horizontal and vertical luma sampling factors
+func (s JpegSubsampling) samplingFactors() (h, v int) {
+	switch s {
+	case Subsampling444:
+		return 1, 1
+	case Subsampling422:
+		return 2, 1
+	case Subsampling440:
+		return 1, 2
+	default:
+		return 2, 2
+	}
+}
+
+// jpegPixels is an interleaved pixel buffer in a layout that both TurboJPEG and libjpeg accept
+type jpegPixels struct {
+	pix           []uint8
+	width, height int
+	stride        int
+	layout        jpegLayout
+}
+
+type jpegLayout int
+
+const (
+	jpegGray jpegLayout = iota
+	jpegRGB
+	jpegRGBX
+	// jpegCMYK holds Adobe inverted CMYK
+	jpegCMYK
+)
+
+// newJpegPixels prepares the pixels of an image for compression, converting it if required
+func newJpegPixels(img image.Image) jpegPixels {
+	px := jpegPixels{width: img.Bounds().Dx(), height: img.Bounds().Dy()}
+	switch v := img.(type) {
+	case *image.Gray:
+		px.pix = v.Pix[v.PixOffset(v.Rect.Min.X, v.Rect.Min.Y):]
+		px.stride = v.Stride
+		px.layout = jpegGray
+	case *RGBImage:
+		px.pix = v.Pix[v.PixOffset(v.Rect.Min.X, v.Rect.Min.Y):]
+		px.stride = v.Stride
+		px.layout = jpegRGB
+	case *image.RGBA:
+		// Premultiplied alpha is dropped, which composites the image on black
+		px.pix = v.Pix[v.PixOffset(v.Rect.Min.X, v.Rect.Min.Y):]
+		px.stride = v.Stride
+		px.layout = jpegRGBX
+	case *image.NRGBA:
+		if !v.Opaque() {
+			// Straight alpha must be applied to the colors before it can be dropped
+			return newJpegPixels(toRGB(v))
+		}
+		px.pix = v.Pix[v.PixOffset(v.Rect.Min.X, v.Rect.Min.Y):]
+		px.stride = v.Stride
+		px.layout = jpegRGBX
+	case *image.CMYK:
+		// An Adobe marker is written, readers expect the inverted Adobe convention
+		px.pix = make([]uint8, 4*px.width*px.height)
+		px.stride = 4 * px.width
+		px.layout = jpegCMYK
+		for y := 0; y < px.height; y++ {
+			src := v.Pix[v.PixOffset(v.Rect.Min.X, v.Rect.Min.Y+y):]
+			dst := px.pix[px.stride*y:]
+			for x := 0; x < px.stride; x++ {
+				dst[x] = 0xff - src[x]
+			}
+		}
+	case *image.Gray16:
+		return newJpegPixels(toGray(v))
+	default:
+		return newJpegPixels(toRGB(img))
+	}
+	return px
+}
+
+// EncodeJpegWithOptions will encode an image to JPEG bytes with the given options.
+// libjpeg-turbo's TurboJPEG API is used where possible, the libjpeg API otherwise.
+// Gray, RGB and YCbCr images are compressed from their own pixels, CMYK images are written
+// with Adobe inverted values and any other image is converted to gray or RGB first.
+func EncodeJpegWithOptions(buf *bytes.Buffer, img image.Image, opts JpegEncodeOptions) ([]byte, error) {
+	if img.Bounds().Empty() {
+		return nil, ErrEmptyInput
+	}
+	opts = opts.withDefaults()
+	data, err := jpegCompress(buf, img, opts)
+	if err != nil || opts.Metadata.empty() {
+		return data, err
+	}
+	data, err = jpegWithMetadata(data, opts.Metadata)
+	if err != nil {
+		return nil, err
+	}
+	return setEncoded(buf, data), nil
+}
+
+// jpegCompress picks the compressor for an image and the options
+func jpegCompress(buf *bytes.Buffer, img image.Image, opts JpegEncodeOptions) ([]byte, error) {
+	if v, ok := img.(*image.YCbCr); ok {
+		own, known := jpegSubsamplingOf(v.SubsampleRatio)
+		keep := opts.Subsampling == SubsamplingAuto || known && opts.Subsampling == own
+		if keep && opts.turboCompatible() && tjSupportsYCbCr(v) {
+			return tjCompressYCbCr(buf, v, opts)
+		}
+		if opts.Subsampling == SubsamplingAuto && known {
+			opts.Subsampling = own
+		}
+		img = toRGB(v)
+	}
+
+	px := newJpegPixels(img)
+	if opts.turboCompatible() {
+		return tjCompressPixels(buf, px, opts)
+	}
+	return jpegCompressPixels(buf, px, opts)
+}
+
+// jpegCompressPixels compresses with the libjpeg API
+func jpegCompressPixels(buf *bytes.Buffer, px jpegPixels, opts JpegEncodeOptions) ([]byte, error) {
+	var components C.int
+	var colorspace C.J_COLOR_SPACE
+	switch px.layout {
+	case jpegGray:
+		components, colorspace = 1, C.JCS_GRAYSCALE
+	case jpegRGB:
+		components, colorspace = 3, C.JCS_EXT_RGB
+	case jpegRGBX:
+		components, colorspace = 4, C.JCS_EXT_RGBX
+	case jpegCMYK:
+		components, colorspace = 4, C.JCS_CMYK
+	}
+
+	hSamp, vSamp := opts.Subsampling.samplingFactors()
+	if px.layout == jpegCMYK {
+		// Chroma subsampling of CMYK visibly shifts colors
+		hSamp, vSamp = 1, 1
+	}
+	copts := C.goJpegOptions{
+		quality:         C.int(opts.Quality),
+		hSamp:           C.int(hSamp),
+		vSamp:           C.int(vSamp),
+		progressive:     cBool(opts.Progressive),
+		optimize:        cBool(opts.OptimizeHuffman),
+		arithmetic:      cBool(opts.Arithmetic),
+		restartInterval: C.int(opts.RestartInterval),
+	}
+	switch opts.DCTMethod {
+	case DCTFast:
+		copts.dctMethod = C.JDCT_IFAST
+	case DCTFloat:
+		copts.dctMethod = C.JDCT_FLOAT
+	default:
+		copts.dctMethod = C.JDCT_ISLOW
+	}
+
+	var out *C.uchar
+	var outSize C.ulong
+	var message [C.JMSG_LENGTH_MAX]C.char
+	res := C.goJpegCompress(
+		(*C.uchar)(unsafe.Pointer(&px.pix[0])),
+		C.int(px.width), C.int(px.height), C.int(px.stride),
+		components, colorspace, &copts,
+		&out, &outSize, &message[0],
+	)
+	if out != nil {
+		defer C.free(unsafe.Pointer(out))
+	}
+	if res != 0 {
+		return nil, fmt.Errorf("could not compress jpeg: %v", C.GoString(&message[0]))
+	}
+
+	buf.Reset()
+	buf.Write(C.GoBytes(unsafe.Pointer(out), C.int(outSize)))
+	return buf.Bytes(), nil
+}
+
+func cBool(b bool) C.int {
+	if b {
+		return 1
+	}
+	return 0
+}
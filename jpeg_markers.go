@@ -0,0 +1,172 @@
+package imagecoding
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// TurboJPEG does not expose the application markers, they are read from the JPEG header
+
+var errJpegMarkers = errors.New("invalid jpeg markers")
+
+const (
+	jpegAPP0  = 0xe0
+	jpegAPP1  = 0xe1
+	jpegAPP2  = 0xe2
+	jpegAPP14 = 0xee
+)
+
+// jpegSegment is a marker segment of the JPEG header, payload excludes the length
+type jpegSegment struct {
+	marker  byte
+	payload []byte
+}
+
+// jpegSegments reads the marker segments in front of the first scan
+func jpegSegments(data []byte) ([]jpegSegment, error) {
+	if len(data) < 2 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil, errJpegMarkers
+	}
+	data = data[2:]
+	var segments []jpegSegment
+	for {
+		// Any number of fill bytes may precede a marker
+		i := 0
+		for i < len(data) && data[i] == 0xff {
+			i++
+		}
+		if i == 0 || i >= len(data) {
+			return nil, errJpegMarkers
+		}
+		marker := data[i]
+		data = data[i+1:]
+		switch {
+		case marker == 0xda || marker == 0xd9:
+			// Start of scan and end of image
+			return segments, nil
+		case marker == 0x01 || marker >= 0xd0 && marker <= 0xd7:
+			// Markers without a payload
+			continue
+		}
+		if len(data) < 2 {
+			return nil, errJpegMarkers
+		}
+		size := int(binary.BigEndian.Uint16(data))
+		if size < 2 || size > len(data) {
+			return nil, errJpegMarkers
+		}
+		segments = append(segments, jpegSegment{marker: marker, payload: data[2:size]})
+		data = data[size:]
+	}
+}
+
+// jpegAdobeTransform returns the color transform of an Adobe APP14 marker,
+// 0 for RGB or CMYK, 1 for YCbCr and 2 for YCCK
+func jpegAdobeTransform(segments []jpegSegment) (byte, bool) {
+	for _, s := range segments {
+		if s.marker == jpegAPP14 && len(s.payload) >= 12 && bytes.HasPrefix(s.payload, []byte("Adobe")) {
+			return s.payload[11], true
+		}
+	}
+	return 0, false
+}
+
+var jpegICCSignature = []byte("ICC_PROFILE\x00")
+
+// jpegICCProfile joins the chunks of an ICC profile spread over APP2 markers,
+// nil when there is none or chunks are missing
+func jpegICCProfile(segments []jpegSegment) []byte {
+	var chunks [][]byte
+	for _, s := range segments {
+		if s.marker != jpegAPP2 || len(s.payload) < len(jpegICCSignature)+2 || !bytes.HasPrefix(s.payload, jpegICCSignature) {
+			continue
+		}
+		// Chunks are numbered from 1 and carry the total count
+		seq, count := int(s.payload[12]), int(s.payload[13])
+		if chunks == nil {
+			chunks = make([][]byte, count)
+		}
+		if seq < 1 || seq > len(chunks) || count != len(chunks) {
+			return nil
+		}
+		chunks[seq-1] = s.payload[14:]
+	}
+	var profile []byte
+	for _, c := range chunks {
+		if c == nil {
+			return nil
+		}
+		profile = append(profile, c...)
+	}
+	return profile
+}
+
+// jpegMaxPayload is the largest payload of a marker segment
+const jpegMaxPayload = 0xffff - 2
+
+// jpegWithMetadata writes the DPI to the JFIF marker of a JPEG file and adds EXIF and ICC profile
+// markers after it. Files without a JFIF marker, like CMYK files, carry the DPI in EXIF.
+func jpegWithMetadata(data []byte, meta EncodeMetadata) ([]byte, error) {
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil, errJpegMarkers
+	}
+	rest := data[2:]
+	var jfif []byte
+	if len(rest) >= 18 && rest[0] == 0xff && rest[1] == jpegAPP0 && string(rest[4:9]) == "JFIF\x00" {
+		size := int(binary.BigEndian.Uint16(rest[2:]))
+		if size < 16 || size+2 > len(rest) {
+			return nil, errJpegMarkers
+		}
+		jfif = append([]byte{}, rest[:size+2]...)
+		rest = rest[size+2:]
+	}
+
+	var exif []byte
+	if jfif == nil || meta.Exif != nil {
+		exif = meta.exif(true)
+	}
+	if jfif != nil && meta.DPI > 0 {
+		density := uint16(math.Min(math.Round(meta.DPI), math.MaxUint16))
+		jfif[11] = 1 // dots per inch
+		binary.BigEndian.PutUint16(jfif[12:], density)
+		binary.BigEndian.PutUint16(jfif[14:], density)
+	}
+
+	out := append([]byte{0xff, 0xd8}, jfif...)
+	segment := func(marker byte, parts ...[]byte) {
+		size := 2
+		for _, p := range parts {
+			size += len(p)
+		}
+		out = append(out, 0xff, marker)
+		out = binary.BigEndian.AppendUint16(out, uint16(size))
+		for _, p := range parts {
+			out = append(out, p...)
+		}
+	}
+	if exif != nil {
+		if len(exif)+6 > jpegMaxPayload {
+			return nil, errors.New("exif does not fit in a jpeg marker")
+		}
+		segment(jpegAPP1, []byte("Exif\x00\x00"), exif)
+	}
+	if profile := meta.ICCProfile; len(profile) > 0 {
+		// Chunks are numbered from 1 and carry the total count
+		chunkSize := jpegMaxPayload - len(jpegICCSignature) - 2
+		count := (len(profile) + chunkSize - 1) / chunkSize
+		if count > 255 {
+			return nil, fmt.Errorf("icc profile of %d bytes does not fit in jpeg markers", len(profile))
+		}
+		for i := 0; i < count; i++ {
+			chunk := profile[i*chunkSize:]
+			if len(chunk) > chunkSize {
+				chunk = chunk[:chunkSize]
+			}
+			segment(jpegAPP2, jpegICCSignature, []byte{byte(i + 1), byte(count)}, chunk)
+		}
+	}
+	return append(out, rest...), nil
+}
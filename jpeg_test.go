@@ -3,6 +3,8 @@ package imagecoding
 import (
 	"bytes"
 	"fmt"
+	"image"
+	"image/color"
 	"image/jpeg"
 	"image/png"
 	"os"
@@ -103,6 +105,309 @@ func TestEncodeJpeg(t *testing.T) {
 	}
 }
 
+func TestEncodeJpegImageTypes(t *testing.T) {
+	for name, src := range makeConvertTestImages() {
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+			imgbytes, err := EncodeJpeg(&buf, src, 100)
+			if !assert.NoError(t, err) {
+				return
+			}
+			output, err := jpeg.Decode(bytes.NewReader(imgbytes))
+			if assert.NoError(t, err) {
+				// Chroma subsampling blurs the colors, so compare the gray levels
+				assertSimilar(t, toGray(src), toGray(output), 6)
+			}
+		})
+	}
+}
+
+func TestEncodeJpegYCbCr(t *testing.T) {
+	ratios := []image.YCbCrSubsampleRatio{
+		image.YCbCrSubsampleRatio444,
+		image.YCbCrSubsampleRatio422,
+		image.YCbCrSubsampleRatio420,
+		image.YCbCrSubsampleRatio440,
+		image.YCbCrSubsampleRatio411,
+		image.YCbCrSubsampleRatio410,
+	}
+	for _, ratio := range ratios {
+		t.Run(ratio.String(), func(t *testing.T) {
+			src := image.NewYCbCr(image.Rect(0, 0, 61, 35), ratio)
+			for y := 0; y < 35; y++ {
+				for x := 0; x < 61; x++ {
+					src.Y[src.YOffset(x, y)] = uint8(3*x + 2*y)
+					ci := src.COffset(x, y)
+					src.Cb[ci], src.Cr[ci] = uint8(128+x/4), uint8(128-y/4)
+				}
+			}
+			var buf bytes.Buffer
+			imgbytes, err := EncodeJpeg(&buf, src, 100)
+			if !assert.NoError(t, err) {
+				return
+			}
+			output, err := jpeg.Decode(bytes.NewReader(imgbytes))
+			if assert.NoError(t, err) {
+				assertSimilar(t, toGray(src), toGray(output), 4)
+			}
+		})
+	}
+
+	// A selected subsampling applies to YCbCr images too, on either compressor
+	src := image.NewYCbCr(image.Rect(0, 0, 61, 35), image.YCbCrSubsampleRatio444)
+	tests := []struct {
+		name     string
+		opts     JpegEncodeOptions
+		expected []byte
+	}{
+		{"auto", JpegEncodeOptions{}, []byte{0x11, 0x11, 0x11}},
+		{"same", JpegEncodeOptions{Subsampling: Subsampling444}, []byte{0x11, 0x11, 0x11}},
+		{"420", JpegEncodeOptions{Subsampling: Subsampling420}, []byte{0x22, 0x11, 0x11}},
+		{"auto-libjpeg", JpegEncodeOptions{OptimizeHuffman: true}, []byte{0x11, 0x11, 0x11}},
+		{"422-libjpeg", JpegEncodeOptions{OptimizeHuffman: true, Subsampling: Subsampling422}, []byte{0x21, 0x11, 0x11}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			data, err := EncodeJpegWithOptions(&buf, src, tt.opts)
+			if assert.NoError(t, err) {
+				assert.Equal(t, tt.expected, jpegSamplingFactors(t, data))
+			}
+		})
+	}
+}
+
+// jpegSamplingFactors returns the sampling factors of the components of a JPEG file
+func jpegSamplingFactors(t *testing.T, data []byte) []byte {
+	segments, err := jpegSegments(data)
+	if !assert.NoError(t, err) {
+		return nil
+	}
+	for _, s := range segments {
+		if s.marker >= 0xc0 && s.marker <= 0xcb && s.marker != 0xc4 && s.marker != 0xc8 {
+			var factors []byte
+			for c := 0; c < int(s.payload[5]); c++ {
+				factors = append(factors, s.payload[6+3*c+1])
+			}
+			return factors
+		}
+	}
+	assert.Fail(t, "missing start of frame")
+	return nil
+}
+
+func TestEncodeJpegWithOptions(t *testing.T) {
+	src := NewRGBImage(image.Rect(0, 0, 61, 35))
+	for y := 0; y < 35; y++ {
+		for x := 0; x < 61; x++ {
+			i := src.PixOffset(x, y)
+			src.Pix[i+0], src.Pix[i+1], src.Pix[i+2] = uint8(4*x), uint8(7*y), uint8(2*x+3*y)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		opts   JpegEncodeOptions
+		ratio  image.YCbCrSubsampleRatio
+		marker []byte
+	}{
+		{"default", JpegEncodeOptions{}, image.YCbCrSubsampleRatio420, []byte{0xff, 0xc0}},
+		{"444", JpegEncodeOptions{Subsampling: Subsampling444}, image.YCbCrSubsampleRatio444, nil},
+		{"422", JpegEncodeOptions{Subsampling: Subsampling422}, image.YCbCrSubsampleRatio422, nil},
+		{"440", JpegEncodeOptions{Subsampling: Subsampling440}, image.YCbCrSubsampleRatio440, nil},
+		{"progressive", JpegEncodeOptions{Progressive: true}, image.YCbCrSubsampleRatio420, []byte{0xff, 0xc2}},
+		{"fast-dct", JpegEncodeOptions{DCTMethod: DCTFast}, image.YCbCrSubsampleRatio420, nil},
+		{"float-dct", JpegEncodeOptions{DCTMethod: DCTFloat}, image.YCbCrSubsampleRatio420, nil},
+		{"optimize", JpegEncodeOptions{OptimizeHuffman: true}, image.YCbCrSubsampleRatio420, nil},
+		{"restart", JpegEncodeOptions{RestartInterval: 2}, image.YCbCrSubsampleRatio420, []byte{0xff, 0xdd}},
+		{
+			"libjpeg-progressive-444",
+			JpegEncodeOptions{Progressive: true, OptimizeHuffman: true, Subsampling: Subsampling444},
+			image.YCbCrSubsampleRatio444,
+			[]byte{0xff, 0xc2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			imgbytes, err := EncodeJpegWithOptions(&buf, src, tt.opts)
+			if !assert.NoError(t, err) {
+				return
+			}
+			if tt.marker != nil {
+				assert.True(t, bytes.Contains(imgbytes, tt.marker), "missing marker %x", tt.marker)
+			}
+			output, err := jpeg.Decode(bytes.NewReader(imgbytes))
+			if !assert.NoError(t, err) {
+				return
+			}
+			if assert.IsType(t, &image.YCbCr{}, output) {
+				assert.Equal(t, tt.ratio, output.(*image.YCbCr).SubsampleRatio)
+			}
+			assertSimilar(t, toGray(src), toGray(output), 4)
+		})
+	}
+
+	t.Run("gray", func(t *testing.T) {
+		var buf bytes.Buffer
+		imgbytes, err := EncodeJpegWithOptions(&buf, toGray(src), JpegEncodeOptions{OptimizeHuffman: true, Subsampling: Subsampling422})
+		if !assert.NoError(t, err) {
+			return
+		}
+		output, err := jpeg.Decode(bytes.NewReader(imgbytes))
+		if assert.NoError(t, err) {
+			assert.IsType(t, &image.Gray{}, output)
+		}
+	})
+
+	t.Run("optimize-is-smaller", func(t *testing.T) {
+		var buf bytes.Buffer
+		plain, err := EncodeJpegWithOptions(&buf, src, JpegEncodeOptions{Quality: 90})
+		if !assert.NoError(t, err) {
+			return
+		}
+		plainLen := len(plain)
+		optimized, err := EncodeJpegWithOptions(&buf, src, JpegEncodeOptions{Quality: 90, OptimizeHuffman: true})
+		if assert.NoError(t, err) {
+			assert.Less(t, len(optimized), plainLen)
+		}
+	})
+
+	// The standard library can not decode arithmetic coding, libjpeg-turbo can
+	t.Run("arithmetic", func(t *testing.T) {
+		var buf bytes.Buffer
+		imgbytes, err := EncodeJpegWithOptions(&buf, src, JpegEncodeOptions{Arithmetic: true})
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.True(t, bytes.Contains(imgbytes, []byte{0xff, 0xc9}), "missing arithmetic SOF marker")
+		output, _, _, _, err := TransformJpeg(imgbytes, true, DefaultScale)
+		if assert.NoError(t, err) {
+			assertSimilar(t, toGray(src), output, 4)
+		}
+	})
+}
+
+// insertJpegSegment adds a marker segment after the start of image marker
+func insertJpegSegment(data []byte, marker byte, payload []byte) []byte {
+	out := []byte{0xff, 0xd8, 0xff, marker, byte((len(payload) + 2) >> 8), byte(len(payload) + 2)}
+	out = append(out, payload...)
+	return append(out, data[2:]...)
+}
+
+func TestTransformJpegCMYK(t *testing.T) {
+	// Stripes of cyan, magenta, yellow and black ink, as in the testdata files
+	inks := []color.CMYK{{C: 255}, {M: 255}, {Y: 255}, {K: 255}}
+	src := image.NewCMYK(image.Rect(0, 0, 32, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 32; x++ {
+			src.SetCMYK(x, y, inks[x/8])
+		}
+	}
+	// libjpeg-turbo writes YCCK with an Adobe marker
+	var buf bytes.Buffer
+	ycck, err := EncodeJpegWithOptions(&buf, src, JpegEncodeOptions{Quality: 100})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	// Photoshop writes plain CMYK with inverted inks and an Adobe marker, other writers leave out both
+	adobe, err := os.ReadFile("testdata/cmyk-adobe.jpg")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	plain, err := os.ReadFile("testdata/cmyk-plain.jpg")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	// The profile is split over two APP2 markers
+	profile := makeCMYKProfile("mft2")
+	half := len(profile) / 2
+	withProfile := insertJpegSegment(ycck, 0xe2, append([]byte("ICC_PROFILE\x00\x02\x02"), profile[half:]...))
+	withProfile = insertJpegSegment(withProfile, 0xe2, append([]byte("ICC_PROFILE\x00\x01\x02"), profile[:half]...))
+
+	ideal := func(c color.CMYK) [3]uint8 {
+		r, g, b := color.CMYKToRGB(c.C, c.M, c.Y, c.K)
+		return [3]uint8{r, g, b}
+	}
+	profiled := func(c color.CMYK) [3]uint8 { return swappedInks(c.C, c.M, c.Y, c.K) }
+	tests := []struct {
+		name     string
+		data     []byte
+		expected func(color.CMYK) [3]uint8
+		delta    int
+	}{
+		{"ycck", ycck, ideal, 4},
+		{"adobe-cmyk", adobe, ideal, 4},
+		{"plain-cmyk", plain, ideal, 4},
+		// Small deviations of the inks move dark channels a lot through the steep sRGB curve
+		{"profile", withProfile, profiled, 12},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := TransformJpegWithOptions(tt.data, TransformOptions{})
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, color.CMYKModel, res.ColorModel)
+			assert.Empty(t, res.Warnings)
+			rgb, ok := res.Image.(*RGBImage)
+			if !assert.True(t, ok, "expected an RGBImage, got %T", res.Image) {
+				return
+			}
+			for i, ink := range inks {
+				c := rgb.RGBAAt(8*i+4, 4)
+				assertRGB(t, tt.expected(ink), c.R, c.G, c.B, tt.delta, "stripe %d", i)
+			}
+
+			res, err = TransformJpegWithOptions(tt.data, TransformOptions{ColorMode: ColorModeGray})
+			if assert.NoError(t, err) {
+				gray := toGray(rgb)
+				for i := range inks {
+					assert.InDelta(t, int(gray.GrayAt(8*i+4, 4).Y), int(res.Image.(*image.Gray).GrayAt(8*i+4, 4).Y), 1, "stripe %d", i)
+				}
+			}
+		})
+	}
+
+	// An unsupported profile falls back to ideal inks with a warning
+	rgbProfile := makeICCProfile(primariesSRGB, whiteD65, srgbCurve)
+	res, err := TransformJpegWithOptions(insertJpegSegment(ycck, 0xe2, append([]byte("ICC_PROFILE\x00\x01\x01"), rgbProfile...)), TransformOptions{})
+	if assert.NoError(t, err) {
+		assert.Len(t, res.Warnings, 1)
+		c := res.Image.(*RGBImage).RGBAAt(4, 4)
+		assertRGB(t, ideal(inks[0]), c.R, c.G, c.B, 4)
+	}
+
+	// Neither compressor subsamples CMYK
+	for _, opts := range []JpegEncodeOptions{{Subsampling: Subsampling420}, {Subsampling: Subsampling420, OptimizeHuffman: true}} {
+		data, err := EncodeJpegWithOptions(&buf, src, opts)
+		if assert.NoError(t, err) {
+			assert.Equal(t, []byte{0x11, 0x11, 0x11, 0x11}, jpegSamplingFactors(t, data))
+		}
+	}
+}
+
+func TestTransformJpegDCTSize(t *testing.T) {
+	src := image.NewGray(image.Rect(0, 0, 405, 203))
+	for i := range src.Pix {
+		src.Pix[i] = 200
+	}
+	var buf bytes.Buffer
+	data, err := EncodeJpeg(&buf, src, 90)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	eighth := func(w, h int) (int, int, float64) { return w / 8, h / 8, 0.125 }
+
+	// The partial last column and row of 405/8 x 203/8 are decoded as whole pixels, and nothing past them
+	res, err := TransformJpegWithOptions(data, TransformOptions{Scale: eighth, ColorMode: ColorModeGray})
+	if assert.NoError(t, err) {
+		assert.Equal(t, [2]int{51, 26}, [2]int{res.OutWidth, res.OutHeight})
+		gray := res.Image.(*image.Gray)
+		assert.InDelta(t, 200, int(gray.GrayAt(50, 25).Y), 2)
+	}
+}
+
 func BenchmarkJPEG(b *testing.B) {
 	var err error
 	var buf bytes.Buffer
@@ -126,10 +431,10 @@ func BenchmarkJPEGTransform(b *testing.B) {
 		if !assert.NoError(b, err) {
 			b.FailNow()
 		}
-		if !assert.Equal(b, 1891, img.Bounds().Dx()) {
+		if !assert.Equal(b, 1890, img.Bounds().Dx()) {
 			b.FailNow()
 		}
-		if !assert.Equal(b, 1081, img.Bounds().Dy()) {
+		if !assert.Equal(b, 1080, img.Bounds().Dy()) {
 			b.FailNow()
 		}
 	}
@@ -0,0 +1,506 @@
+//go:build jxl
+// +build jxl
+
+package imagecoding
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"unsafe"
+)
+
+// #cgo pkg-config: libjxl
+// #include <stdlib.h>
+// #include <jxl/decode.h>
+// #include <jxl/encode.h>
+//
+// // goJxlInfo reads the basic info of a JPEG XL file
+// JxlDecoderStatus goJxlInfo(const uint8_t *data, size_t size, JxlBasicInfo *info) {
+//     JxlDecoder *dec = JxlDecoderCreate(NULL);
+//     JxlDecoderStatus status;
+//
+//     if (dec == NULL) {
+//         return JXL_DEC_ERROR;
+//     }
+//     status = JxlDecoderSubscribeEvents(dec, JXL_DEC_BASIC_INFO);
+//     if (status == JXL_DEC_SUCCESS) {
+//         status = JxlDecoderSetInput(dec, data, size);
+//     }
+//     if (status == JXL_DEC_SUCCESS) {
+//         JxlDecoderCloseInput(dec);
+//         status = JxlDecoderProcessInput(dec);
+//         if (status == JXL_DEC_BASIC_INFO) {
+//             status = JxlDecoderGetBasicInfo(dec, info);
+//         } else {
+//             status = JXL_DEC_ERROR;
+//         }
+//     }
+//     JxlDecoderDestroy(dec);
+//     return status;
+// }
+//
+// // goJxlDecode decodes the first frame to 8-bit pixels with 1 or 3 color channels, plus alpha
+// // if the image has it. out must be freed by the caller, also on failure.
+// JxlDecoderStatus goJxlDecode(const uint8_t *data, size_t size, int keepOrientation,
+//                              JxlBasicInfo *info, uint32_t *channels, uint8_t **out, size_t *outSize) {
+//     JxlDecoder *dec = JxlDecoderCreate(NULL);
+//     JxlPixelFormat format = {0, JXL_TYPE_UINT8, JXL_NATIVE_ENDIAN, 0};
+//     JxlDecoderStatus status;
+//
+//     if (dec == NULL) {
+//         return JXL_DEC_ERROR;
+//     }
+//     status = JxlDecoderSubscribeEvents(dec, JXL_DEC_BASIC_INFO | JXL_DEC_FULL_IMAGE);
+//     if (status == JXL_DEC_SUCCESS) {
+//         status = JxlDecoderSetKeepOrientation(dec, keepOrientation ? JXL_TRUE : JXL_FALSE);
+//     }
+//     if (status == JXL_DEC_SUCCESS) {
+//         status = JxlDecoderSetInput(dec, data, size);
+//     }
+//     if (status != JXL_DEC_SUCCESS) {
+//         goto done;
+//     }
+//     JxlDecoderCloseInput(dec);
+//
+//     for (;;) {
+//         status = JxlDecoderProcessInput(dec);
+//         if (status == JXL_DEC_BASIC_INFO) {
+//             status = JxlDecoderGetBasicInfo(dec, info);
+//             if (status != JXL_DEC_SUCCESS) {
+//                 goto done;
+//             }
+//             format.num_channels = (info->num_color_channels == 1 ? 1 : 3) + (info->alpha_bits > 0 ? 1 : 0);
+//             *channels = format.num_channels;
+//         } else if (status == JXL_DEC_NEED_IMAGE_OUT_BUFFER) {
+//             status = JxlDecoderImageOutBufferSize(dec, &format, outSize);
+//             if (status != JXL_DEC_SUCCESS) {
+//                 goto done;
+//             }
+//             *out = malloc(*outSize);
+//             if (*out == NULL) {
+//                 status = JXL_DEC_ERROR;
+//                 goto done;
+//             }
+//             status = JxlDecoderSetImageOutBuffer(dec, &format, *out, *outSize);
+//             if (status != JXL_DEC_SUCCESS) {
+//                 goto done;
+//             }
+//         } else if (status == JXL_DEC_FULL_IMAGE) {
+//             // The first frame is the still image, or the first frame of an animation
+//             status = JXL_DEC_SUCCESS;
+//             goto done;
+//         } else {
+//             // Truncated input or an error
+//             status = JXL_DEC_ERROR;
+//             goto done;
+//         }
+//     }
+//
+// done:
+//     JxlDecoderDestroy(dec);
+//     return status;
+// }
+//
+// // goJxlToJpeg reconstructs the original JPEG of a recompressed JPEG XL file. It returns 0 on
+// // success, 1 on failure and 2 if there is no reconstruction data. out must be freed by the caller.
+// int goJxlToJpeg(const uint8_t *data, size_t size, uint8_t **out, size_t *outSize) {
+//     JxlDecoder *dec = JxlDecoderCreate(NULL);
+//     JxlDecoderStatus status;
+//     size_t capacity = size * 2 + 65536, used;
+//     uint8_t *grown;
+//     int res = 1;
+//
+//     if (dec == NULL) {
+//         return 1;
+//     }
+//     if (JxlDecoderSubscribeEvents(dec, JXL_DEC_JPEG_RECONSTRUCTION | JXL_DEC_FULL_IMAGE) != JXL_DEC_SUCCESS ||
+//         JxlDecoderSetInput(dec, data, size) != JXL_DEC_SUCCESS) {
+//         goto done;
+//     }
+//     JxlDecoderCloseInput(dec);
+//
+//     for (;;) {
+//         status = JxlDecoderProcessInput(dec);
+//         if (status == JXL_DEC_JPEG_RECONSTRUCTION) {
+//             *out = malloc(capacity);
+//             if (*out == NULL || JxlDecoderSetJPEGBuffer(dec, *out, capacity) != JXL_DEC_SUCCESS) {
+//                 goto done;
+//             }
+//         } else if (status == JXL_DEC_JPEG_NEED_MORE_OUTPUT) {
+//             used = capacity - JxlDecoderReleaseJPEGBuffer(dec);
+//             capacity *= 2;
+//             grown = realloc(*out, capacity);
+//             if (grown == NULL) {
+//                 goto done;
+//             }
+//             *out = grown;
+//             if (JxlDecoderSetJPEGBuffer(dec, *out + used, capacity - used) != JXL_DEC_SUCCESS) {
+//                 goto done;
+//             }
+//         } else if (status == JXL_DEC_FULL_IMAGE && *out != NULL) {
+//             *outSize = capacity - JxlDecoderReleaseJPEGBuffer(dec);
+//             res = 0;
+//             goto done;
+//         } else if (status == JXL_DEC_NEED_IMAGE_OUT_BUFFER || status == JXL_DEC_FULL_IMAGE) {
+//             // The pixels are next, this file was not recompressed from a JPEG
+//             res = 2;
+//             goto done;
+//         } else {
+//             goto done;
+//         }
+//     }
+//
+// done:
+//     JxlDecoderDestroy(dec);
+//     return res;
+// }
+//
+// typedef struct {
+//     float distance;
+//     int lossless;
+//     int effort;
+// } goJxlOptions;
+//
+// // goJxlEncode encodes packed 8-bit pixels with 1 to 4 channels, or recompresses the JPEG file in
+// // pix losslessly when jpeg is set. The pixels are tagged with the ICC profile and an Exif box is
+// // added when their size is positive. out must be freed by the caller, also on failure.
+// JxlEncoderStatus goJxlEncode(const uint8_t *pix, size_t pixSize, int width, int height, int channels,
+//                              int jpeg, goJxlOptions *opts, const uint8_t *icc, size_t iccSize,
+//                              const uint8_t *exif, size_t exifSize, uint8_t **out, size_t *outSize) {
+//     JxlEncoder *enc = JxlEncoderCreate(NULL);
+//     JxlEncoderFrameSettings *settings;
+//     JxlBasicInfo info;
+//     JxlColorEncoding colorEncoding;
+//     JxlPixelFormat format = {channels, JXL_TYPE_UINT8, JXL_NATIVE_ENDIAN, 0};
+//     JxlEncoderStatus status;
+//     size_t capacity = 65536, used;
+//     uint8_t *next, *grown;
+//
+//     if (enc == NULL) {
+//         return JXL_ENC_ERROR;
+//     }
+//     settings = JxlEncoderFrameSettingsCreate(enc, NULL);
+//     if (settings == NULL) {
+//         status = JXL_ENC_ERROR;
+//         goto done;
+//     }
+//     if (opts->effort > 0) {
+//         status = JxlEncoderFrameSettingsSetOption(settings, JXL_ENC_FRAME_SETTING_EFFORT, opts->effort);
+//         if (status != JXL_ENC_SUCCESS) {
+//             goto done;
+//         }
+//     }
+//
+//     if (exifSize > 0) {
+//         status = JxlEncoderUseBoxes(enc);
+//         if (status != JXL_ENC_SUCCESS) {
+//             goto done;
+//         }
+//     }
+//
+//     if (jpeg) {
+//         status = JxlEncoderStoreJPEGMetadata(enc, JXL_TRUE);
+//         if (status == JXL_ENC_SUCCESS) {
+//             status = JxlEncoderAddJPEGFrame(settings, pix, pixSize);
+//         }
+//     } else {
+//         JxlEncoderInitBasicInfo(&info);
+//         info.xsize = width;
+//         info.ysize = height;
+//         info.bits_per_sample = 8;
+//         info.num_color_channels = channels < 3 ? 1 : 3;
+//         if (channels == 2 || channels == 4) {
+//             info.num_extra_channels = 1;
+//             info.alpha_bits = 8;
+//         }
+//         // Lossless must keep the samples in their own color space
+//         info.uses_original_profile = opts->lossless ? JXL_TRUE : JXL_FALSE;
+//         status = JxlEncoderSetBasicInfo(enc, &info);
+//         if (status == JXL_ENC_SUCCESS && iccSize > 0) {
+//             status = JxlEncoderSetICCProfile(enc, icc, iccSize);
+//         } else if (status == JXL_ENC_SUCCESS) {
+//             JxlColorEncodingSetToSRGB(&colorEncoding, info.num_color_channels == 1);
+//             status = JxlEncoderSetColorEncoding(enc, &colorEncoding);
+//         }
+//         if (status == JXL_ENC_SUCCESS) {
+//             if (opts->lossless) {
+//                 status = JxlEncoderSetFrameLossless(settings, JXL_TRUE);
+//             } else {
+//                 status = JxlEncoderSetFrameDistance(settings, opts->distance);
+//             }
+//         }
+//         if (status == JXL_ENC_SUCCESS) {
+//             status = JxlEncoderAddImageFrame(settings, &format, pix, pixSize);
+//         }
+//     }
+//     if (status == JXL_ENC_SUCCESS && exifSize > 0) {
+//         status = JxlEncoderAddBox(enc, "Exif", exif, exifSize, JXL_FALSE);
+//     }
+//     if (status != JXL_ENC_SUCCESS) {
+//         goto done;
+//     }
+//     JxlEncoderCloseInput(enc);
+//
+//     *out = malloc(capacity);
+//     if (*out == NULL) {
+//         status = JXL_ENC_ERROR;
+//         goto done;
+//     }
+//     next = *out;
+//     *outSize = capacity;
+//     while ((status = JxlEncoderProcessOutput(enc, &next, outSize)) == JXL_ENC_NEED_MORE_OUTPUT) {
+//         used = next - *out;
+//         capacity *= 2;
+//         grown = realloc(*out, capacity);
+//         if (grown == NULL) {
+//             status = JXL_ENC_ERROR;
+//             goto done;
+//         }
+//         *out = grown;
+//         next = *out + used;
+//         *outSize = capacity - used;
+//     }
+//     *outSize = next - *out;
+//
+// done:
+//     JxlEncoderDestroy(enc);
+//     return status;
+// }
+import "C"
+
+// ConfigJxl returns the size of a JPEG XL image after its orientation is applied
+func ConfigJxl(data []byte) (image.Config, string, error) {
+	if len(data) == 0 {
+		return image.Config{}, string(Jxl), ErrEmptyInput
+	}
+	var info C.JxlBasicInfo
+	if C.goJxlInfo((*C.uint8_t)(unsafe.Pointer(&data[0])), C.size_t(len(data)), &info) != C.JXL_DEC_SUCCESS {
+		return image.Config{}, string(Jxl), errors.New("could not read jpeg xl header")
+	}
+	cfg := image.Config{
+		ColorModel: RGBModel,
+		Width:      int(info.xsize),
+		Height:     int(info.ysize),
+	}
+	if info.orientation > C.JXL_ORIENT_FLIP_VERTICAL {
+		cfg.Width, cfg.Height = cfg.Height, cfg.Width
+	}
+	switch {
+	case info.alpha_bits > 0:
+		cfg.ColorModel = color.NRGBAModel
+	case info.num_color_channels == 1:
+		cfg.ColorModel = color.GrayModel
+	}
+	return cfg, string(Jxl), nil
+}
+
+// DecodeJxl decodes the first frame of a JPEG XL image, upright. The result is an *image.Gray,
+// an *RGBImage, or an *image.NRGBA if the image has alpha.
+func DecodeJxl(data []byte) (image.Image, error) {
+	img, _, err := decodeJxl(data, false)
+	return img, err
+}
+
+// decodeJxl decodes the first frame and returns the orientation that libjxl applied
+func decodeJxl(data []byte, keepOrientation bool) (image.Image, Orientation, error) {
+	if len(data) == 0 {
+		return nil, TopLeft, ErrEmptyInput
+	}
+
+	var info C.JxlBasicInfo
+	var channels C.uint32_t
+	var out *C.uint8_t
+	var outSize C.size_t
+	status := C.goJxlDecode(
+		(*C.uint8_t)(unsafe.Pointer(&data[0])), C.size_t(len(data)), cBool(keepOrientation),
+		&info, &channels, &out, &outSize,
+	)
+	if out != nil {
+		defer C.free(unsafe.Pointer(out))
+	}
+	if status != C.JXL_DEC_SUCCESS || out == nil {
+		return nil, TopLeft, errors.New("could not decode jpeg xl")
+	}
+
+	width, height := int(info.xsize), int(info.ysize)
+	orient := TopLeft
+	if !keepOrientation {
+		orient = Orientation(info.orientation)
+		if info.orientation > C.JXL_ORIENT_FLIP_VERTICAL {
+			width, height = height, width
+		}
+	}
+	pix := C.GoBytes(unsafe.Pointer(out), C.int(outSize))
+	rect := image.Rect(0, 0, width, height)
+
+	switch channels {
+	case 1:
+		return &image.Gray{Pix: pix, Stride: width, Rect: rect}, orient, nil
+	case 2:
+		// Gray with alpha has no image type of its own
+		img := image.NewNRGBA(rect)
+		for i := 0; i < width*height; i++ {
+			img.Pix[i*4+0], img.Pix[i*4+1], img.Pix[i*4+2], img.Pix[i*4+3] = pix[i*2], pix[i*2], pix[i*2], pix[i*2+1]
+		}
+		return img, orient, nil
+	case 3:
+		return &RGBImage{Pix: pix, Stride: width * 3, Rect: rect}, orient, nil
+	default:
+		return &image.NRGBA{Pix: pix, Stride: width * 4, Rect: rect}, orient, nil
+	}
+}
+
+// TransformJxl is like TransformJpeg for JPEG XL images
+func TransformJxl(data []byte, grayscale bool, scale ScaleFunc) (out image.Image, width, height int, scaleFactor float64, err error) {
+	res, err := TransformJxlWithOptions(data, legacyOptions(grayscale, scale))
+	if err != nil {
+		return nil, 0, 0, 0, err
+	}
+	return res.Image, res.Width, res.Height, res.ScaleFactor, nil
+}
+
+// TransformJxlWithOptions is like TransformJxl but configured through TransformOptions.
+// libjxl applies the orientation of the image while decoding, unless it is ignored.
+func TransformJxlWithOptions(data []byte, opts TransformOptions) (TransformResult, error) {
+	if len(data) == 0 {
+		return TransformResult{}, ErrEmptyInput
+	}
+	opts = opts.withDefaults()
+
+	cfg, _, err := ConfigJxl(data)
+	if err != nil {
+		return TransformResult{}, err
+	}
+	if err = opts.Limits.check(cfg.Width, cfg.Height); err != nil {
+		return TransformResult{}, err
+	}
+
+	img, orient, err := decodeJxl(data, opts.Orientation == OrientationIgnore)
+	if err != nil {
+		return TransformResult{}, err
+	}
+	result := finishTransform(img, Jxl, orient, nil, opts)
+	result.ColorModel = cfg.ColorModel
+	return result, nil
+}
+
+// EncodeJxl will encode an image to JPEG XL bytes using libjxl.
+// Gray, RGB and straight alpha RGBA images are passed as they are, any other image is converted
+// to RGB, or to straight alpha RGBA if it has transparency.
+func EncodeJxl(buf *bytes.Buffer, img image.Image, opts JxlEncodeOptions) ([]byte, error) {
+	if img.Bounds().Empty() {
+		return nil, ErrEmptyInput
+	}
+
+	var pix []uint8
+	var stride, channels int
+	switch v := img.(type) {
+	case *image.Gray:
+		pix = v.Pix[v.PixOffset(v.Rect.Min.X, v.Rect.Min.Y):]
+		stride = v.Stride
+		channels = 1
+	case *RGBImage:
+		pix = v.Pix[v.PixOffset(v.Rect.Min.X, v.Rect.Min.Y):]
+		stride = v.Stride
+		channels = 3
+	case *image.NRGBA:
+		if v.Opaque() {
+			return EncodeJxl(buf, toRGB(v), opts)
+		}
+		pix = v.Pix[v.PixOffset(v.Rect.Min.X, v.Rect.Min.Y):]
+		stride = v.Stride
+		channels = 4
+	default:
+		if isOpaque(img) {
+			return EncodeJxl(buf, toRGB(img), opts)
+		}
+		return EncodeJxl(buf, toNRGBA(img), opts)
+	}
+
+	// libjxl reads packed rows
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+	if rowSize := width * channels; stride != rowSize {
+		packed := make([]uint8, rowSize*height)
+		for y := 0; y < height; y++ {
+			copy(packed[y*rowSize:(y+1)*rowSize], pix[y*stride:])
+		}
+		pix = packed
+	}
+	return jxlEncode(buf, pix, width, height, channels, false, opts.withDefaults())
+}
+
+// RecompressJpegToJxl losslessly recompresses a JPEG file to JPEG XL, typically about 20% smaller.
+// ReconstructJpegFromJxl restores the original JPEG file bit for bit. Only Effort is used from opts,
+// the metadata of the JPEG file is kept as it is.
+func RecompressJpegToJxl(buf *bytes.Buffer, jpegData []byte, opts JxlEncodeOptions) ([]byte, error) {
+	if len(jpegData) == 0 {
+		return nil, ErrEmptyInput
+	}
+	opts.Metadata = EncodeMetadata{}
+	return jxlEncode(buf, jpegData, 0, 0, 0, true, opts.withDefaults())
+}
+
+// ReconstructJpegFromJxl restores the JPEG file that was recompressed by RecompressJpegToJxl,
+// it returns ErrNoJpegReconstruction for JPEG XL files that were encoded from pixels
+func ReconstructJpegFromJxl(buf *bytes.Buffer, data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, ErrEmptyInput
+	}
+	var out *C.uint8_t
+	var outSize C.size_t
+	res := C.goJxlToJpeg((*C.uint8_t)(unsafe.Pointer(&data[0])), C.size_t(len(data)), &out, &outSize)
+	if out != nil {
+		defer C.free(unsafe.Pointer(out))
+	}
+	switch res {
+	case 0:
+	case 2:
+		return nil, ErrNoJpegReconstruction
+	default:
+		return nil, errors.New("could not reconstruct jpeg")
+	}
+
+	buf.Reset()
+	buf.Write(C.GoBytes(unsafe.Pointer(out), C.int(outSize)))
+	return buf.Bytes(), nil
+}
+
+// jxlEncode runs the libjxl encoder on packed pixels, or on a JPEG file if jpeg is set
+func jxlEncode(buf *bytes.Buffer, pix []uint8, width, height, channels int, jpeg bool, opts JxlEncodeOptions) ([]byte, error) {
+	copts := C.goJxlOptions{
+		distance: C.float(opts.Distance),
+		lossless: cBool(opts.Lossless),
+		effort:   C.int(opts.Effort),
+	}
+	var icc, exif *C.uint8_t
+	if len(opts.Metadata.ICCProfile) > 0 {
+		icc = (*C.uint8_t)(unsafe.Pointer(&opts.Metadata.ICCProfile[0]))
+	}
+	// The Exif box starts with the offset of the TIFF header
+	var exifBox []byte
+	if exifData := opts.Metadata.exif(true); exifData != nil {
+		exifBox = append([]byte{0, 0, 0, 0}, exifData...)
+		exif = (*C.uint8_t)(unsafe.Pointer(&exifBox[0]))
+	}
+
+	var out *C.uint8_t
+	var outSize C.size_t
+	status := C.goJxlEncode(
+		(*C.uint8_t)(unsafe.Pointer(&pix[0])), C.size_t(len(pix)),
+		C.int(width), C.int(height), C.int(channels), cBool(jpeg), &copts,
+		icc, C.size_t(len(opts.Metadata.ICCProfile)), exif, C.size_t(len(exifBox)),
+		&out, &outSize,
+	)
+	if out != nil {
+		defer C.free(unsafe.Pointer(out))
+	}
+	if status != C.JXL_ENC_SUCCESS {
+		return nil, errors.New("could not encode jpeg xl")
+	}
+
+	buf.Reset()
+	buf.Write(C.GoBytes(unsafe.Pointer(out), C.int(outSize)))
+	return buf.Bytes(), nil
+}
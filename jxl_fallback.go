@@ -0,0 +1,37 @@
+//go:build !jxl
+// +build !jxl
+
+package imagecoding
+
+import (
+	"bytes"
+	"image"
+)
+
+func ConfigJxl(data []byte) (image.Config, string, error) {
+	return image.Config{}, "", image.ErrFormat
+}
+
+func DecodeJxl(data []byte) (image.Image, error) {
+	return nil, image.ErrFormat
+}
+
+func TransformJxl(data []byte, grayscale bool, scale ScaleFunc) (out image.Image, width, height int, scaleFactor float64, err error) {
+	return nil, 0, 0, 0, image.ErrFormat
+}
+
+func TransformJxlWithOptions(data []byte, opts TransformOptions) (TransformResult, error) {
+	return TransformResult{}, image.ErrFormat
+}
+
+func EncodeJxl(buf *bytes.Buffer, img image.Image, opts JxlEncodeOptions) ([]byte, error) {
+	return nil, image.ErrFormat
+}
+
+func RecompressJpegToJxl(buf *bytes.Buffer, jpegData []byte, opts JxlEncodeOptions) ([]byte, error) {
+	return nil, image.ErrFormat
+}
+
+func ReconstructJpegFromJxl(buf *bytes.Buffer, data []byte) ([]byte, error) {
+	return nil, image.ErrFormat
+}
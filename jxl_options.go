@@ -0,0 +1,30 @@
+package imagecoding
+
+import "errors"
+
+// DefaultJxlDistance is the distance used when JxlEncodeOptions.Distance is zero, visually lossless
+const DefaultJxlDistance = 1.0
+
+// ErrNoJpegReconstruction is returned when a JPEG XL file was not recompressed from a JPEG
+var ErrNoJpegReconstruction = errors.New("no jpeg reconstruction data")
+
+// JxlEncodeOptions configures EncodeJxl and RecompressJpegToJxl
+type JxlEncodeOptions struct {
+	// Distance is the maximum Butteraugli distance from the original, lower is better.
+	// 1 is visually lossless, 0.5 to 3 is the useful range and zero uses DefaultJxlDistance.
+	Distance float32
+	// Lossless keeps every pixel intact and ignores Distance
+	Lossless bool
+	// Effort ranges from 1 (fastest) to 9 (smallest files), zero uses libjxl's default of 7
+	Effort int
+	// Metadata is written as the color profile and an Exif box, the DPI to EXIF.
+	// RecompressJpegToJxl keeps the metadata of the JPEG file instead.
+	Metadata EncodeMetadata
+}
+
+func (o JxlEncodeOptions) withDefaults() JxlEncodeOptions {
+	if o.Distance == 0 {
+		o.Distance = DefaultJxlDistance
+	}
+	return o
+}
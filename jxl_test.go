@@ -0,0 +1,83 @@
+//go:build jxl
+// +build jxl
+
+package imagecoding
+
+import (
+	"bytes"
+	"image"
+	"os"
+	"testing"
+
+	"github.com/Nr90/imgsim"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodeJxl(t *testing.T) {
+	var buf bytes.Buffer
+	imgbytes, err := EncodeJxl(&buf, img, JxlEncodeOptions{})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, buf.Len(), len(imgbytes))
+
+	cfg, format, err := DecodeConfig(imgbytes)
+	if assert.NoError(t, err) {
+		assert.Equal(t, string(Jxl), format)
+		assert.Equal(t, img.Bounds().Dx(), cfg.Width)
+		assert.Equal(t, img.Bounds().Dy(), cfg.Height)
+	}
+
+	output, err := DecodeJxl(imgbytes)
+	if assert.NoError(t, err) {
+		assert.Equal(t, ref, imgsim.AverageHash(output))
+	}
+
+	res, err := TransformWithOptions(imgbytes, TransformOptions{ColorMode: ColorModeGray})
+	if assert.NoError(t, err) {
+		assert.Equal(t, Jxl, res.Format)
+		assert.IsType(t, &image.Gray{}, res.Image)
+	}
+}
+
+func TestEncodeJxlLossless(t *testing.T) {
+	for name, src := range makeConvertTestImages() {
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+			imgbytes, err := EncodeJxl(&buf, src, JxlEncodeOptions{Lossless: true})
+			if !assert.NoError(t, err) {
+				return
+			}
+			output, err := DecodeJxl(imgbytes)
+			if assert.NoError(t, err) {
+				assertSimilar(t, toGray(src), toGray(output), 1)
+			}
+		})
+	}
+}
+
+func TestRecompressJpegToJxl(t *testing.T) {
+	jpegData, err := os.ReadFile("testdata/rose.jpg")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	var buf bytes.Buffer
+	jxlData, err := RecompressJpegToJxl(&buf, jpegData, JxlEncodeOptions{})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Less(t, len(jxlData), len(jpegData))
+	jxlData = append([]byte(nil), jxlData...)
+
+	restored, err := ReconstructJpegFromJxl(&buf, jxlData)
+	if assert.NoError(t, err) {
+		assert.Equal(t, jpegData, restored)
+	}
+
+	pixels, err := EncodeJxl(&buf, img, JxlEncodeOptions{})
+	if assert.NoError(t, err) {
+		_, err = ReconstructJpegFromJxl(&buf, append([]byte(nil), pixels...))
+		assert.Equal(t, ErrNoJpegReconstruction, err)
+	}
+}
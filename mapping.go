@@ -0,0 +1,108 @@
+package imagecoding
+
+import (
+	"image"
+	"math"
+)
+
+// mappingEpsilon absorbs floating point noise when rounding mapped coordinates to whole pixels
+const mappingEpsilon = 1e-9
+
+// Affine is a 2D affine transformation of continuous pixel coordinates, mapping (x, y) to
+// (A*x + B*y + C, D*x + E*y + F). Pixel (x, y) covers the area from (x, y) to (x+1, y+1).
+type Affine struct {
+	A, B, C float64
+	D, E, F float64
+}
+
+// IdentityAffine returns the transformation that leaves all coordinates unchanged
+func IdentityAffine() Affine {
+	return Affine{A: 1, E: 1}
+}
+
+// scaleAffine scales coordinates by sx horizontally and sy vertically
+func scaleAffine(sx, sy float64) Affine {
+	return Affine{A: sx, E: sy}
+}
+
+// translateAffine moves coordinates by (tx, ty), a crop at (x, y) translates by (-x, -y)
+func translateAffine(tx, ty float64) Affine {
+	return Affine{A: 1, C: tx, E: 1, F: ty}
+}
+
+// orientationAffine maps coordinates of a stored image of width x height pixels
+// to the upright image that FixOrientation produces for orient
+func orientationAffine(orient Orientation, width, height int) Affine {
+	w, h := float64(width), float64(height)
+	switch orient {
+	case TopRight:
+		return Affine{A: -1, C: w, E: 1}
+	case BottomRight:
+		return Affine{A: -1, C: w, E: -1, F: h}
+	case BottomLeft:
+		return Affine{A: 1, E: -1, F: h}
+	case LeftTop:
+		return Affine{B: 1, D: 1}
+	case RightTop:
+		return Affine{B: -1, C: h, D: 1}
+	case RightBottom:
+		return Affine{B: -1, C: h, D: -1, F: w}
+	case LeftBottom:
+		return Affine{B: 1, D: -1, F: w}
+	default:
+		return IdentityAffine()
+	}
+}
+
+// Apply maps the continuous coordinate (x, y)
+func (m Affine) Apply(x, y float64) (float64, float64) {
+	return m.A*x + m.B*y + m.C, m.D*x + m.E*y + m.F
+}
+
+// Then returns the transformation that applies m followed by n
+func (m Affine) Then(n Affine) Affine {
+	return Affine{
+		A: n.A*m.A + n.B*m.D,
+		B: n.A*m.B + n.B*m.E,
+		C: n.A*m.C + n.B*m.F + n.C,
+		D: n.D*m.A + n.E*m.D,
+		E: n.D*m.B + n.E*m.E,
+		F: n.D*m.C + n.E*m.F + n.F,
+	}
+}
+
+// Invert returns the inverse transformation, ok is false if m is not invertible
+func (m Affine) Invert() (inv Affine, ok bool) {
+	det := m.A*m.E - m.B*m.D
+	if det == 0 {
+		return Affine{}, false
+	}
+	inv.A = m.E / det
+	inv.B = -m.B / det
+	inv.D = -m.D / det
+	inv.E = m.A / det
+	inv.C = -(inv.A*m.C + inv.B*m.F)
+	inv.F = -(inv.D*m.C + inv.E*m.F)
+	return inv, true
+}
+
+// Point maps the pixel p by its center and returns the pixel that the center lands in
+func (m Affine) Point(p image.Point) image.Point {
+	x, y := m.Apply(float64(p.X)+0.5, float64(p.Y)+0.5)
+	return image.Pt(int(math.Floor(x)), int(math.Floor(y)))
+}
+
+// Rect maps the rectangle r and returns the smallest pixel aligned rectangle covering the result
+func (m Affine) Rect(r image.Rectangle) image.Rectangle {
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for _, p := range []image.Point{r.Min, {r.Max.X, r.Min.Y}, {r.Min.X, r.Max.Y}, r.Max} {
+		x, y := m.Apply(float64(p.X), float64(p.Y))
+		minX, maxX = math.Min(minX, x), math.Max(maxX, x)
+		minY, maxY = math.Min(minY, y), math.Max(maxY, y)
+	}
+	return image.Rect(
+		int(math.Floor(minX+mappingEpsilon)), int(math.Floor(minY+mappingEpsilon)),
+		int(math.Ceil(maxX-mappingEpsilon)), int(math.Ceil(maxY-mappingEpsilon)),
+	)
+}
@@ -0,0 +1,82 @@
+package imagecoding
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAffineInvert(t *testing.T) {
+	m := orientationAffine(RightTop, 80, 40).Then(scaleAffine(0.5, 0.25)).Then(translateAffine(-3, 7))
+	inv, ok := m.Invert()
+	if !assert.True(t, ok) {
+		t.FailNow()
+	}
+	x, y := inv.Apply(m.Apply(12.5, 31))
+	assert.InDelta(t, 12.5, x, 1e-9)
+	assert.InDelta(t, 31, y, 1e-9)
+
+	_, ok = scaleAffine(0, 1).Invert()
+	assert.False(t, ok)
+}
+
+// Every pixel must land where FixOrientation puts it
+func TestOrientationAffine(t *testing.T) {
+	src := image.NewGray(image.Rect(0, 0, 5, 3))
+	for y := 0; y < 3; y++ {
+		for x := 0; x < 5; x++ {
+			src.SetGray(x, y, color.Gray{Y: uint8(y*5 + x)})
+		}
+	}
+	for orient := TopLeft; orient <= LeftBottom; orient++ {
+		t.Run(fmt.Sprintf("orientation-%d", orient), func(t *testing.T) {
+			fixed := FixOrientation(src, orient)
+			m := orientationAffine(orient, 5, 3)
+			assert.Equal(t, fixed.Bounds(), m.Rect(src.Bounds()))
+			for y := 0; y < 3; y++ {
+				for x := 0; x < 5; x++ {
+					p := m.Point(image.Pt(x, y))
+					r, _, _, _ := fixed.At(p.X, p.Y).RGBA()
+					assert.Equal(t, uint32(y*5+x), r>>8, "pixel (%d, %d) mapped to %v", x, y, p)
+				}
+			}
+		})
+	}
+}
+
+func TestTransformMapping(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+		source   image.Rectangle
+	}{
+		{"jpeg", "testdata/world-political.jpg", image.Rect(0, 0, 3024, 1728)},
+		{"jpeg-exif", "testdata/f6-exif.jpg", image.Rect(0, 0, 80, 40)},
+		{"png", "testdata/gamer.png", image.Rect(0, 0, 2070, 2198)},
+	}
+	for _, tt := range tests {
+		sample, err := os.ReadFile(tt.filename)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := TransformWithOptions(sample, TransformOptions{ColorMode: ColorModeGray})
+			if assert.NoError(t, err) {
+				// JPEG DCT scaling may leave a partial pixel along the bottom and right edges
+				out := res.ToOutput.Rect(tt.source)
+				assert.Equal(t, image.Point{}, out.Min)
+				assert.InDelta(t, res.OutWidth, out.Dx(), 1)
+				assert.InDelta(t, res.OutHeight, out.Dy(), 1)
+
+				back := res.ToSource.Rect(res.Image.Bounds())
+				assert.Equal(t, tt.source.Min, back.Min)
+				assert.InDelta(t, tt.source.Dx(), back.Dx(), 2)
+				assert.InDelta(t, tt.source.Dy(), back.Dy(), 2)
+			}
+		})
+	}
+}
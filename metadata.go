@@ -0,0 +1,253 @@
+package imagecoding
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"math"
+	"sort"
+	"time"
+)
+
+// EncodeMetadata is written along with the pixels by the encoders, the zero value writes none.
+// WebP, HEIF, AVIF and JPEG XL have no resolution field of their own and store the DPI in EXIF.
+type EncodeMetadata struct {
+	// DPI is the resolution in pixels per inch in both directions, zero writes no resolution
+	DPI float64
+	// ICCProfile is embedded as it is, nil leaves the pixels in sRGB by convention
+	ICCProfile []byte
+	// Exif selects the EXIF tags to write, nil writes no EXIF unless the format needs it for the DPI
+	Exif *ExifTags
+}
+
+// ExifTags are the EXIF tags EncodeMetadata can write, empty fields are left out
+type ExifTags struct {
+	// Orientation tells readers how to display the image, the pixels are written as they are
+	Orientation Orientation
+	// DateTime is the time the image was last changed, written in its own time zone
+	DateTime         time.Time
+	ImageDescription string
+	Make             string
+	Model            string
+	Software         string
+	Artist           string
+	Copyright        string
+}
+
+func (m EncodeMetadata) empty() bool {
+	return m.DPI <= 0 && len(m.ICCProfile) == 0 && m.Exif == nil
+}
+
+// exifDateLayout is the layout of EXIF dates
+const exifDateLayout = "2006:01:02 15:04:05"
+
+// exifEntry is a tag of an image file directory, in EXIF or in a TIFF file
+type exifEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+// exif writes the EXIF of the metadata as a big endian TIFF structure, nil when there are no tags.
+// The resolution is included when withDPI is set.
+func (m EncodeMetadata) exif(withDPI bool) []byte {
+	entries := m.exifEntries(withDPI)
+	if len(entries) == 0 {
+		return nil
+	}
+	return append([]byte("MM\x00\x2a\x00\x00\x00\x08"), tiffDirectory(entries, 8)...)
+}
+
+// exifEntries returns the baseline TIFF tags of the metadata
+func (m EncodeMetadata) exifEntries(withDPI bool) []exifEntry {
+	var tags ExifTags
+	if m.Exif != nil {
+		tags = *m.Exif
+	}
+	var entries []exifEntry
+	ascii := func(tag uint16, s string) {
+		if s != "" {
+			entries = append(entries, exifEntry{tag, 2, uint32(len(s) + 1), append([]byte(s), 0)})
+		}
+	}
+	short := func(tag uint16, v uint16) {
+		entries = append(entries, exifEntry{tag, 3, 1, binary.BigEndian.AppendUint16(nil, v)})
+	}
+
+	ascii(0x010e, tags.ImageDescription)
+	ascii(0x010f, tags.Make)
+	ascii(0x0110, tags.Model)
+	if tags.Orientation != 0 {
+		short(0x0112, uint16(tags.Orientation))
+	}
+	if withDPI && m.DPI > 0 {
+		num, den := exifRational(m.DPI)
+		rational := binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32(nil, num), den)
+		entries = append(entries, exifEntry{0x011a, 5, 1, rational}, exifEntry{0x011b, 5, 1, rational})
+		short(0x0128, 2) // inches
+	}
+	ascii(0x0131, tags.Software)
+	if !tags.DateTime.IsZero() {
+		ascii(0x0132, tags.DateTime.Format(exifDateLayout))
+	}
+	ascii(0x013b, tags.Artist)
+	ascii(0x8298, tags.Copyright)
+	return entries
+}
+
+// tiffDirectory writes a big endian image file directory that starts at offset, followed by the
+// values of more than 4 bytes. The offset of the next directory is left 0.
+func tiffDirectory(entries []exifEntry, offset uint32) []byte {
+	sort.Slice(entries, func(i, j int) bool { return entries[i].tag < entries[j].tag })
+	out := binary.BigEndian.AppendUint16(nil, uint16(len(entries)))
+	valueOffset := offset + uint32(len(out)+12*len(entries)+4)
+	var values []byte
+	for _, e := range entries {
+		out = binary.BigEndian.AppendUint16(out, e.tag)
+		out = binary.BigEndian.AppendUint16(out, e.typ)
+		out = binary.BigEndian.AppendUint32(out, e.count)
+		if len(e.value) <= 4 {
+			out = append(out, e.value...)
+			out = append(out, make([]byte, 4-len(e.value))...)
+			continue
+		}
+		out = binary.BigEndian.AppendUint32(out, valueOffset+uint32(len(values)))
+		values = append(values, e.value...)
+		// Values start on a word boundary
+		if len(values)%2 == 1 {
+			values = append(values, 0)
+		}
+	}
+	out = binary.BigEndian.AppendUint32(out, 0)
+	return append(out, values...)
+}
+
+// exifRational returns a resolution as a fraction, exact for whole numbers
+func exifRational(v float64) (num, den uint32) {
+	if v == math.Trunc(v) {
+		return uint32(v), 1
+	}
+	return uint32(math.Round(v * 1000)), 1000
+}
+
+// pngWithMetadata adds iCCP, pHYs and eXIf chunks after the IHDR chunk of a PNG file
+func pngWithMetadata(data []byte, meta EncodeMetadata) ([]byte, error) {
+	// The signature and the IHDR chunk have a fixed size
+	const ihdrEnd = 8 + 8 + 13 + 4
+	if len(data) < ihdrEnd || string(data[12:16]) != "IHDR" {
+		return nil, errors.New("invalid png header")
+	}
+	out := append([]byte{}, data[:ihdrEnd]...)
+	chunk := func(typ string, payload []byte) {
+		out = binary.BigEndian.AppendUint32(out, uint32(len(payload)))
+		start := len(out)
+		out = append(out, typ...)
+		out = append(out, payload...)
+		out = binary.BigEndian.AppendUint32(out, crc32.ChecksumIEEE(out[start:]))
+	}
+
+	if len(meta.ICCProfile) > 0 {
+		// A profile name and the compression method precede the compressed profile
+		var profile bytes.Buffer
+		profile.WriteString("icc\x00\x00")
+		z := zlib.NewWriter(&profile)
+		z.Write(meta.ICCProfile)
+		z.Close()
+		chunk("iCCP", profile.Bytes())
+	}
+	if meta.DPI > 0 {
+		// Pixels per metre in both directions
+		ppm := uint32(math.Round(meta.DPI / 0.0254))
+		phys := binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32(nil, ppm), ppm)
+		chunk("pHYs", append(phys, 1))
+	}
+	if exif := meta.exif(false); exif != nil {
+		chunk("eXIf", exif)
+	}
+	return append(out, data[ihdrEnd:]...), nil
+}
+
+// webpWithMetadata turns a WebP file into an extended one with ICCP and EXIF chunks,
+// the DPI is written to EXIF
+func webpWithMetadata(data []byte, meta EncodeMetadata, width, height int) ([]byte, error) {
+	if len(data) < 20 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, errors.New("invalid webp header")
+	}
+	type chunk struct {
+		typ     string
+		payload []byte
+	}
+	var chunks []chunk
+	for rest := data[12:]; len(rest) >= 8; {
+		size := uint64(binary.LittleEndian.Uint32(rest[4:]))
+		if size+8 > uint64(len(rest)) {
+			return nil, errors.New("invalid webp chunk")
+		}
+		chunks = append(chunks, chunk{string(rest[:4]), rest[8 : 8+size]})
+		next := 8 + size + size&1
+		if next > uint64(len(rest)) {
+			break
+		}
+		rest = rest[next:]
+	}
+	if len(chunks) == 0 {
+		return nil, errors.New("invalid webp chunk")
+	}
+
+	// A simple file becomes extended with the canvas of the image. The alpha flag stays clear for
+	// lossless images, their alpha is part of the bitstream and x/image/webp rejects the flag.
+	var header []byte
+	if chunks[0].typ == "VP8X" && len(chunks[0].payload) >= 10 {
+		header = append([]byte{}, chunks[0].payload...)
+		chunks = chunks[1:]
+	} else {
+		header = make([]byte, 10)
+		putUint24 := func(b []byte, v int) { b[0], b[1], b[2] = byte(v), byte(v>>8), byte(v>>16) }
+		putUint24(header[4:], width-1)
+		putUint24(header[7:], height-1)
+	}
+	exif := meta.exif(true)
+	header[0] &^= 0x20 | 0x08
+	if len(meta.ICCProfile) > 0 {
+		header[0] |= 0x20
+	}
+	if exif != nil {
+		header[0] |= 0x08
+	}
+
+	var body bytes.Buffer
+	body.WriteString("WEBP")
+	write := func(typ string, payload []byte) {
+		body.WriteString(typ)
+		binary.Write(&body, binary.LittleEndian, uint32(len(payload)))
+		body.Write(payload)
+		if len(payload)%2 == 1 {
+			body.WriteByte(0)
+		}
+	}
+	write("VP8X", header)
+	if len(meta.ICCProfile) > 0 {
+		write("ICCP", meta.ICCProfile)
+	}
+	for _, c := range chunks {
+		if c.typ != "ICCP" && c.typ != "EXIF" {
+			write(c.typ, c.payload)
+		}
+	}
+	if exif != nil {
+		write("EXIF", exif)
+	}
+
+	out := append([]byte("RIFF"), binary.LittleEndian.AppendUint32(nil, uint32(body.Len()))...)
+	return append(out, body.Bytes()...), nil
+}
+
+// setEncoded replaces the content of buf with an encoded file
+func setEncoded(buf *bytes.Buffer, data []byte) []byte {
+	buf.Reset()
+	buf.Write(data)
+	return buf.Bytes()
+}
@@ -0,0 +1,206 @@
+package imagecoding
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"testing"
+	"time"
+
+	"github.com/rwcarlsen/goexif/exif"
+	"github.com/stretchr/testify/assert"
+)
+
+// assertExif checks the tags written for testMetadata
+func assertExif(t *testing.T, data []byte, dpi float64) {
+	x, err := exif.Decode(bytes.NewReader(data))
+	if !assert.NoError(t, err) {
+		return
+	}
+	orient, err := x.Get(exif.Orientation)
+	if assert.NoError(t, err) {
+		v, _ := orient.Int(0)
+		assert.Equal(t, int(RightTop), v)
+	}
+	software, err := x.Get(exif.Software)
+	if assert.NoError(t, err) {
+		v, _ := software.StringVal()
+		assert.Equal(t, "go-imagecoding", v)
+	}
+	date, err := x.DateTime()
+	if assert.NoError(t, err) {
+		assert.Equal(t, "2023-05-17 08:30:00", date.Format("2006-01-02 15:04:05"))
+	}
+	res, err := x.Get(exif.XResolution)
+	if dpi == 0 {
+		assert.Error(t, err)
+	} else if assert.NoError(t, err) {
+		num, den, _ := res.Rat2(0)
+		assert.Equal(t, dpi, float64(num)/float64(den))
+	}
+}
+
+func testMetadata() EncodeMetadata {
+	return EncodeMetadata{
+		DPI:        150,
+		ICCProfile: makeICCProfile(primariesP3, whiteD65, srgbCurve),
+		Exif: &ExifTags{
+			Orientation: RightTop,
+			DateTime:    time.Date(2023, 5, 17, 8, 30, 0, 0, time.UTC),
+			Software:    "go-imagecoding",
+		},
+	}
+}
+
+func TestEncodeMetadataJpeg(t *testing.T) {
+	meta := testMetadata()
+	cmyk := image.NewCMYK(image.Rect(0, 0, 16, 16))
+	tests := []struct {
+		name string
+		img  image.Image
+		opts JpegEncodeOptions
+		jfif bool
+	}{
+		{"turbo", makeP3Red(), JpegEncodeOptions{Metadata: meta}, true},
+		{"libjpeg", makeP3Red(), JpegEncodeOptions{Arithmetic: true, Metadata: meta}, true},
+		{"gray", image.NewGray(image.Rect(0, 0, 16, 16)), JpegEncodeOptions{Metadata: meta}, true},
+		{"cmyk", cmyk, JpegEncodeOptions{Metadata: meta}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			data, err := EncodeJpegWithOptions(&buf, tt.img, tt.opts)
+			if !assert.NoError(t, err) {
+				return
+			}
+			segments, err := jpegSegments(data)
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, meta.ICCProfile, jpegICCProfile(segments))
+			if tt.jfif {
+				// Dots per inch in both directions
+				assert.Equal(t, []byte{1, 0, 150, 0, 150}, segments[0].payload[7:12])
+			} else {
+				assert.NotEqual(t, byte(jpegAPP0), segments[0].marker)
+			}
+			assertExif(t, data, 150)
+
+			res, err := TransformWithOptions(data, TransformOptions{Scale: noScale, Orientation: OrientationIgnore})
+			if assert.NoError(t, err) {
+				assert.Equal(t, tt.img.Bounds().Size(), res.Image.Bounds().Size())
+			}
+		})
+	}
+
+	// A profile larger than a marker is split
+	large := EncodeMetadata{ICCProfile: bytes.Repeat(meta.ICCProfile, 1000)}
+	var buf bytes.Buffer
+	data, err := EncodeJpegWithOptions(&buf, makeP3Red(), JpegEncodeOptions{Metadata: large})
+	if assert.NoError(t, err) {
+		embedded, err := ICCProfile(data)
+		assert.NoError(t, err)
+		assert.Equal(t, large.ICCProfile, embedded)
+	}
+
+	// The JFIF density alone needs no EXIF
+	data, err = EncodeJpegWithOptions(&buf, makeP3Red(), JpegEncodeOptions{Metadata: EncodeMetadata{DPI: 300}})
+	if assert.NoError(t, err) {
+		segments, err := jpegSegments(data)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte{1, 1, 44, 1, 44}, segments[0].payload[7:12])
+		for _, s := range segments {
+			assert.NotEqual(t, byte(jpegAPP1), s.marker)
+		}
+	}
+}
+
+func TestEncodeMetadataPng(t *testing.T) {
+	meta := testMetadata()
+	var buf bytes.Buffer
+	data, err := EncodePngWithOptions(&buf, makeP3Red(), PngEncodeOptions{Metadata: meta})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	embedded, err := ICCProfile(data)
+	if assert.NoError(t, err) {
+		assert.Equal(t, meta.ICCProfile, embedded)
+	}
+	// 150 dpi is 5906 pixels per metre
+	assert.Equal(t, []byte{0, 0, 0x17, 0x12, 0, 0, 0x17, 0x12, 1}, pngChunk(data, "pHYs"))
+	// The resolution is in pHYs only
+	assertExif(t, pngChunk(data, "eXIf"), 0)
+
+	res, err := TransformWithOptions(data, TransformOptions{Scale: noScale, ConvertToSRGB: true})
+	if assert.NoError(t, err) {
+		assert.Empty(t, res.Warnings)
+		c := res.Image.(*RGBImage).RGBAAt(4, 4)
+		assertRGB(t, [3]uint8{255, 0, 0}, c.R, c.G, c.B, 4)
+	}
+}
+
+func TestEncodeMetadataWebP(t *testing.T) {
+	meta := testMetadata()
+	transparent := makeP3Red()
+	transparent.Pix[3] = 0
+	tests := []struct {
+		name  string
+		img   image.Image
+		opts  WebPEncodeOptions
+		alpha bool // flagged in the extended header
+	}{
+		{"lossless", makeP3Red(), WebPEncodeOptions{Metadata: meta}, false},
+		{"lossless alpha", transparent, WebPEncodeOptions{Metadata: meta}, false},
+		{"lossy", makeP3Red(), WebPEncodeOptions{Mode: WebPLossy, Metadata: meta}, false},
+		{"lossy alpha", transparent, WebPEncodeOptions{Mode: WebPLossy, Metadata: meta}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			data, err := EncodeWebPWithOptions(&buf, tt.img, tt.opts)
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, len(data)-8, int(binary.LittleEndian.Uint32(data[4:])))
+			header := webpChunk(data, "VP8X")
+			if assert.Len(t, header, 10) {
+				assert.Equal(t, byte(0x28), header[0]&0x28)
+				assert.Equal(t, tt.alpha, header[0]&0x10 != 0)
+				assert.Equal(t, []byte{7, 0, 0, 7, 0, 0}, header[4:])
+			}
+			assert.Equal(t, meta.ICCProfile, webpICCProfile(data))
+			assertExif(t, webpChunk(data, "EXIF"), 150)
+
+			res, err := TransformWithOptions(data, TransformOptions{Scale: noScale})
+			if assert.NoError(t, err) {
+				assert.Equal(t, tt.img.Bounds().Size(), res.Image.Bounds().Size())
+			}
+		})
+	}
+}
+
+func TestEncodeMetadataExif(t *testing.T) {
+	assert.Nil(t, EncodeMetadata{}.exif(true))
+	assert.Nil(t, EncodeMetadata{DPI: 300}.exif(false))
+	assert.Nil(t, EncodeMetadata{Exif: &ExifTags{}}.exif(true))
+
+	data := EncodeMetadata{DPI: 72.5, Exif: &ExifTags{Make: "Make", Model: "A model name", Copyright: "Nobody"}}.exif(true)
+	x, err := exif.Decode(bytes.NewReader(data))
+	if !assert.NoError(t, err) {
+		return
+	}
+	for field, expected := range map[exif.FieldName]string{exif.Make: "Make", exif.Model: "A model name", exif.Copyright: "Nobody"} {
+		tag, err := x.Get(field)
+		if assert.NoError(t, err) {
+			v, _ := tag.StringVal()
+			assert.Equal(t, expected, v)
+		}
+	}
+	res, err := x.Get(exif.YResolution)
+	if assert.NoError(t, err) {
+		num, den, _ := res.Rat2(0)
+		assert.Equal(t, 72.5, float64(num)/float64(den))
+	}
+	_, err = x.Get(exif.Orientation)
+	assert.Error(t, err)
+}
@@ -0,0 +1,55 @@
+package imagecoding
+
+import (
+	"errors"
+
+	"github.com/h2non/filetype"
+)
+
+// ErrPageOutOfRange is returned when a page index is negative or not less than the page count
+var ErrPageOutOfRange = errors.New("page index out of range")
+
+// PageCount returns the number of pages of a document, TIFF and PDF files can have several.
+// Every other image format has a single page.
+func PageCount(data []byte) (int, error) {
+	if len(data) == 0 {
+		return 0, ErrEmptyInput
+	}
+	kind, err := filetype.Match(data)
+	if err != nil {
+		return 0, errors.New("could not determine file type")
+	}
+
+	switch ImgFormat(kind.Extension) {
+	case Tiff:
+		return tiffPageCount(data)
+	case Pdf:
+		return PageCountPdf(data)
+	default:
+		return 1, nil
+	}
+}
+
+// TransformPage is like TransformWithOptions for the page with the given zero based index.
+// Each page is oriented and scaled by itself, page 0 of a single page format is the image.
+func TransformPage(data []byte, index int, opts TransformOptions) (TransformResult, error) {
+	if len(data) == 0 {
+		return TransformResult{}, ErrEmptyInput
+	}
+	kind, err := filetype.Match(data)
+	if err != nil {
+		return TransformResult{}, errors.New("could not determine file type")
+	}
+
+	switch ImgFormat(kind.Extension) {
+	case Tiff:
+		return transformTiffPage(data, index, opts.withDefaults())
+	case Pdf:
+		return TransformPdfPage(data, index, opts)
+	default:
+		if index != 0 {
+			return TransformResult{}, ErrPageOutOfRange
+		}
+		return TransformWithOptions(data, opts)
+	}
+}
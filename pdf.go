@@ -0,0 +1,298 @@
+//go:build pdf
+// +build pdf
+
+package imagecoding
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"unsafe"
+)
+
+// #cgo LDFLAGS: -lmupdf -lmupdf-third -lm
+// #include <stdlib.h>
+// #include <string.h>
+// #include <mupdf/fitz.h>
+//
+// #define GO_PDF_MESSAGE_LENGTH 256
+//
+// // goPdfDocument is an open document, its data must stay valid until goPdfClose
+// typedef struct {
+//     fz_context *ctx;
+//     fz_document *doc;
+// } goPdfDocument;
+//
+// // Warnings go to stderr by default, a broken page is reported through the error instead
+// static void goPdfQuiet(void *user, const char *message) {}
+//
+// static void goPdfMessage(fz_context *ctx, char *message) {
+//     strncpy(message, fz_caught_message(ctx), GO_PDF_MESSAGE_LENGTH - 1);
+// }
+//
+// void goPdfClose(goPdfDocument *d) {
+//     if (d->ctx != NULL) {
+//         fz_drop_document(d->ctx, d->doc);
+//         fz_drop_context(d->ctx);
+//     }
+// }
+//
+// // goPdfOpen opens a PDF document from memory, d must be closed with goPdfClose, also on failure
+// int goPdfOpen(goPdfDocument *d, const unsigned char *data, size_t size, char *message) {
+//     fz_stream *stm = NULL;
+//
+//     d->doc = NULL;
+//     d->ctx = fz_new_context(NULL, NULL, FZ_STORE_DEFAULT);
+//     if (d->ctx == NULL) {
+//         strcpy(message, "could not create mupdf context");
+//         return -1;
+//     }
+//     fz_set_warning_callback(d->ctx, goPdfQuiet, NULL);
+//     fz_set_error_callback(d->ctx, goPdfQuiet, NULL);
+//
+//     fz_var(stm);
+//     fz_try(d->ctx) {
+//         fz_register_document_handlers(d->ctx);
+//         stm = fz_open_memory(d->ctx, data, size);
+//         d->doc = fz_open_document_with_stream(d->ctx, "application/pdf", stm);
+//     }
+//     fz_always(d->ctx) {
+//         fz_drop_stream(d->ctx, stm);
+//     }
+//     fz_catch(d->ctx) {
+//         goPdfMessage(d->ctx, message);
+//         return -1;
+//     }
+//     return 0;
+// }
+//
+// // goPdfInfo counts the pages and measures a page in points, after its rotation is applied
+// int goPdfInfo(goPdfDocument *d, int number, int *count, float *width, float *height, char *message) {
+//     fz_page *page = NULL;
+//     fz_rect bounds;
+//     int res = 0;
+//
+//     fz_var(page);
+//     fz_try(d->ctx) {
+//         *count = fz_count_pages(d->ctx, d->doc);
+//         if (number >= 0 && number < *count) {
+//             page = fz_load_page(d->ctx, d->doc, number);
+//             bounds = fz_bound_page(d->ctx, page);
+//             *width = bounds.x1 - bounds.x0;
+//             *height = bounds.y1 - bounds.y0;
+//         }
+//     }
+//     fz_always(d->ctx) {
+//         fz_drop_page(d->ctx, page);
+//     }
+//     fz_catch(d->ctx) {
+//         goPdfMessage(d->ctx, message);
+//         res = -1;
+//     }
+//     return res;
+// }
+//
+// // goPdfRender renders a page scaled by sx and sy from points to packed gray or RGB pixels
+// // on a white background. out must be freed by the caller.
+// int goPdfRender(goPdfDocument *d, int number, float sx, float sy, int gray,
+//                 unsigned char **out, int *width, int *height, char *message) {
+//     fz_page *page = NULL;
+//     fz_pixmap *pix = NULL;
+//     unsigned char *samples;
+//     int y, rowSize, stride, res = 0;
+//
+//     fz_var(page);
+//     fz_var(pix);
+//     fz_try(d->ctx) {
+//         page = fz_load_page(d->ctx, d->doc, number);
+//         pix = fz_new_pixmap_from_page(d->ctx, page, fz_scale(sx, sy),
+//                                       gray ? fz_device_gray(d->ctx) : fz_device_rgb(d->ctx), 0);
+//         *width = fz_pixmap_width(d->ctx, pix);
+//         *height = fz_pixmap_height(d->ctx, pix);
+//         rowSize = *width * fz_pixmap_components(d->ctx, pix);
+//         stride = fz_pixmap_stride(d->ctx, pix);
+//         samples = fz_pixmap_samples(d->ctx, pix);
+//         *out = malloc((size_t)rowSize * *height);
+//         if (*out == NULL) {
+//             strcpy(message, "out of memory");
+//             res = -1;
+//         } else {
+//             for (y = 0; y < *height; y++) {
+//                 memcpy(*out + (size_t)y * rowSize, samples + (size_t)y * stride, rowSize);
+//             }
+//         }
+//     }
+//     fz_always(d->ctx) {
+//         fz_drop_pixmap(d->ctx, pix);
+//         fz_drop_page(d->ctx, page);
+//     }
+//     fz_catch(d->ctx) {
+//         goPdfMessage(d->ctx, message);
+//         res = -1;
+//     }
+//     return res;
+// }
+import "C"
+
+// PdfDPI is the resolution of the page sizes that ScaleFunc receives for PDF pages.
+// DefaultScale renders an A4 page at 150 DPI, like a scan of the same page.
+const PdfDPI = 300
+
+// pdfPage describes a page of a PDF document
+type pdfPage struct {
+	count         int
+	width, height float64
+}
+
+// pdfDocument is a PDF document opened by MuPDF. MuPDF reads the data while the document is open,
+// so it gets a copy in C memory.
+type pdfDocument struct {
+	doc  C.goPdfDocument
+	data unsafe.Pointer
+}
+
+// openPdf opens a PDF document, which must be closed
+func openPdf(data []byte) (*pdfDocument, error) {
+	if len(data) == 0 {
+		return nil, ErrEmptyInput
+	}
+	d := &pdfDocument{data: C.CBytes(data)}
+	var message [C.GO_PDF_MESSAGE_LENGTH]C.char
+	if C.goPdfOpen(&d.doc, (*C.uchar)(d.data), C.size_t(len(data)), &message[0]) != 0 {
+		d.close()
+		return nil, fmt.Errorf("could not open pdf: %v", C.GoString(&message[0]))
+	}
+	return d, nil
+}
+
+func (d *pdfDocument) close() {
+	C.goPdfClose(&d.doc)
+	C.free(d.data)
+}
+
+// info counts the pages and measures the page with the given index in points,
+// if the document has such a page
+func (d *pdfDocument) info(index int) (pdfPage, error) {
+	var count C.int
+	var width, height C.float
+	var message [C.GO_PDF_MESSAGE_LENGTH]C.char
+	if C.goPdfInfo(&d.doc, C.int(index), &count, &width, &height, &message[0]) != 0 {
+		return pdfPage{}, fmt.Errorf("could not read pdf: %v", C.GoString(&message[0]))
+	}
+	return pdfPage{count: int(count), width: float64(width), height: float64(height)}, nil
+}
+
+// pdfInfo opens a document to read its page count and the size of a page, see pdfDocument.info
+func pdfInfo(data []byte, index int) (pdfPage, error) {
+	d, err := openPdf(data)
+	if err != nil {
+		return pdfPage{}, err
+	}
+	defer d.close()
+	return d.info(index)
+}
+
+// size returns the size of the page at PdfDPI
+func (p pdfPage) size() (width, height int) {
+	return int(math.Round(p.width * PdfDPI / 72)), int(math.Round(p.height * PdfDPI / 72))
+}
+
+// PageCountPdf returns the number of pages of a PDF document
+func PageCountPdf(data []byte) (int, error) {
+	page, err := pdfInfo(data, -1)
+	return page.count, err
+}
+
+// ConfigPdf returns the size of the first page at PdfDPI
+func ConfigPdf(data []byte) (image.Config, string, error) {
+	page, err := pdfInfo(data, 0)
+	if err != nil {
+		return image.Config{}, string(Pdf), err
+	}
+	if page.count == 0 {
+		return image.Config{}, string(Pdf), errors.New("pdf has no pages")
+	}
+	width, height := page.size()
+	return image.Config{ColorModel: RGBModel, Width: width, Height: height}, string(Pdf), nil
+}
+
+// TransformPdf renders the first page of a PDF document to an image.Gray or RGBImage.
+// The page size at PdfDPI is passed to scale, and the page is rendered directly at the requested size.
+func TransformPdf(data []byte, grayscale bool, scale ScaleFunc) (out image.Image, width, height int, scaleFactor float64, err error) {
+	res, err := TransformPdfWithOptions(data, legacyOptions(grayscale, scale))
+	if err != nil {
+		return nil, 0, 0, 0, err
+	}
+	return res.Image, res.Width, res.Height, res.ScaleFactor, nil
+}
+
+// TransformPdfWithOptions is like TransformPdf but configured through TransformOptions
+func TransformPdfWithOptions(data []byte, opts TransformOptions) (TransformResult, error) {
+	return TransformPdfPage(data, 0, opts)
+}
+
+// TransformPdfPage renders the page with the given zero based index, see TransformPdf.
+// Limits apply to the rendered size, as the source has no pixel size of its own.
+func TransformPdfPage(data []byte, index int, opts TransformOptions) (TransformResult, error) {
+	opts = opts.withDefaults()
+	d, err := openPdf(data)
+	if err != nil {
+		return TransformResult{}, err
+	}
+	defer d.close()
+
+	page, err := d.info(index)
+	if err != nil {
+		return TransformResult{}, err
+	}
+	if index < 0 || index >= page.count {
+		return TransformResult{}, ErrPageOutOfRange
+	}
+	width, height := page.size()
+	if width == 0 || height == 0 {
+		return TransformResult{}, fmt.Errorf("page %d is empty", index)
+	}
+	scaledW, scaledH, scaleFactor := opts.Scale(width, height)
+	if !opts.resizes(scaleFactor) {
+		scaledW, scaledH, scaleFactor = width, height, 1
+	}
+	if err = opts.Limits.check(scaledW, scaledH); err != nil {
+		return TransformResult{}, err
+	}
+
+	// Scale from points to exactly the requested pixel size
+	grayscale := opts.ColorMode == ColorModeGray
+	var out *C.uchar
+	var outW, outH C.int
+	var message [C.GO_PDF_MESSAGE_LENGTH]C.char
+	res := C.goPdfRender(
+		&d.doc, C.int(index), C.float(float64(scaledW)/page.width), C.float(float64(scaledH)/page.height),
+		cBool(grayscale), &out, &outW, &outH, &message[0],
+	)
+	if out != nil {
+		defer C.free(unsafe.Pointer(out))
+	}
+	if res != 0 {
+		return TransformResult{}, fmt.Errorf("could not render pdf page %d: %v", index, C.GoString(&message[0]))
+	}
+
+	var img image.Image
+	rect := image.Rect(0, 0, int(outW), int(outH))
+	if grayscale {
+		img = &image.Gray{Pix: C.GoBytes(unsafe.Pointer(out), outW*outH), Stride: int(outW), Rect: rect}
+	} else {
+		img = &RGBImage{Pix: C.GoBytes(unsafe.Pointer(out), outW*outH*3), Stride: int(outW) * 3, Rect: rect}
+	}
+	result := TransformResult{
+		Format:      Pdf,
+		Width:       width,
+		Height:      height,
+		ScaleFactor: scaleFactor,
+		Orientation: TopLeft,
+		ColorModel:  RGBModel,
+	}
+	result.setImage(img)
+	result.setMapping(result.ScaleX, result.ScaleY)
+	return result, nil
+}
@@ -0,0 +1,26 @@
+//go:build !pdf
+// +build !pdf
+
+package imagecoding
+
+import "image"
+
+func PageCountPdf(data []byte) (int, error) {
+	return 0, image.ErrFormat
+}
+
+func ConfigPdf(data []byte) (image.Config, string, error) {
+	return image.Config{}, "", image.ErrFormat
+}
+
+func TransformPdf(data []byte, grayscale bool, scale ScaleFunc) (out image.Image, width, height int, scaleFactor float64, err error) {
+	return nil, 0, 0, 0, image.ErrFormat
+}
+
+func TransformPdfWithOptions(data []byte, opts TransformOptions) (TransformResult, error) {
+	return TransformResult{}, image.ErrFormat
+}
+
+func TransformPdfPage(data []byte, index int, opts TransformOptions) (TransformResult, error) {
+	return TransformResult{}, image.ErrFormat
+}
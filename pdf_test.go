@@ -0,0 +1,92 @@
+//go:build pdf
+// +build pdf
+
+package imagecoding
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// makePdf builds a PDF with an A4 page with a black square in the top left corner,
+// followed by the same page rotated by 90 degrees
+func makePdf() []byte {
+	content := "0 g 0 742 100 100 re f"
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R 4 0 R] /Count 2 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 595 842] /Contents 5 0 R >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 595 842] /Rotate 90 /Contents 5 0 R >>",
+		fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content),
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return buf.Bytes()
+}
+
+func TestPageCountPdf(t *testing.T) {
+	count, err := PageCountPdf(makePdf())
+	if assert.NoError(t, err) {
+		assert.Equal(t, 2, count)
+	}
+
+	_, err = PageCountPdf([]byte("%PDF-1.4\ngarbage"))
+	assert.Error(t, err)
+}
+
+func TestConfigPdf(t *testing.T) {
+	cfg, format, err := DecodeConfig(makePdf())
+	if assert.NoError(t, err) {
+		assert.Equal(t, string(Pdf), format)
+		assert.Equal(t, 2479, cfg.Width)
+		assert.Equal(t, 3508, cfg.Height)
+	}
+}
+
+func TestTransformPdfPage(t *testing.T) {
+	data := makePdf()
+
+	res, err := TransformWithOptions(data, TransformOptions{ColorMode: ColorModeGray})
+	if assert.NoError(t, err) {
+		assert.Equal(t, Pdf, res.Format)
+		assert.Equal(t, 2479, res.Width)
+		assert.Equal(t, 3508, res.Height)
+		assert.IsType(t, &image.Gray{}, res.Image)
+		// DefaultScale renders A4 at 150 DPI
+		assert.InDelta(t, 1240, res.OutWidth, 1)
+		assert.InDelta(t, 1754, res.OutHeight, 1)
+		gray := res.Image.(*image.Gray)
+		assert.Equal(t, uint8(0), gray.GrayAt(100, 100).Y)
+		assert.Equal(t, uint8(0xff), gray.GrayAt(1000, 1000).Y)
+	}
+
+	res, err = TransformPdfPage(data, 1, TransformOptions{})
+	if assert.NoError(t, err) {
+		assert.IsType(t, &RGBImage{}, res.Image)
+		assert.Equal(t, 3508, res.Width)
+		assert.Equal(t, 2479, res.Height)
+		// The square is in the top right corner once the page is rotated
+		rgb := res.Image.(*RGBImage)
+		assert.Equal(t, uint8(0), rgb.Pix[rgb.PixOffset(res.OutWidth-100, 100)])
+		assert.Equal(t, uint8(0xff), rgb.Pix[rgb.PixOffset(100, 100)])
+	}
+
+	_, err = TransformPdfPage(data, 2, TransformOptions{})
+	assert.Error(t, err)
+}
@@ -2,9 +2,9 @@ package imagecoding
 
 import (
 	"bytes"
-	"errors"
 	"fmt"
 	"image"
+	"image/color"
 	"unsafe"
 )
 
@@ -13,73 +13,415 @@ import (
 // #cgo pkg-config: libpng
 // #cgo CFLAGS: -Wno-incompatible-pointer-types
 // #include <stdlib.h>
+// #include <string.h>
 // #include <png.h>
-// int cpng_image_data_size(const png_image *image) {
-//	    return PNG_IMAGE_DATA_SIZE(*image);
+// #include <zlib.h>
+//
+// #define GO_PNG_MESSAGE_SIZE 256
+//
+// typedef struct {
+//     unsigned char *data;
+//     size_t size;
+//     size_t cap;
+// } goPngBuffer;
+//
+// typedef struct {
+//     int colorType;
+//     int bitDepth;
+//     int level;
+//     int strategy;
+//     int filters;
+//     int srgb;
+// } goPngOptions;
+//
+// static void goPngWrite(png_structp png, png_bytep data, png_size_t length) {
+//     goPngBuffer *out = (goPngBuffer *)png_get_io_ptr(png);
+//     if (out->size + length > out->cap) {
+//         size_t cap = out->cap * 2;
+//         unsigned char *grown;
+//         if (cap < out->size + length) {
+//             cap = out->size + length;
+//         }
+//         grown = realloc(out->data, cap);
+//         if (grown == NULL) {
+//             png_error(png, "out of memory");
+//         }
+//         out->data = grown;
+//         out->cap = cap;
+//     }
+//     memcpy(out->data + out->size, data, length);
+//     out->size += length;
+// }
+//
+// static void goPngFlush(png_structp png) {}
+//
+// static void goPngError(png_structp png, png_const_charp message) {
+//     strncpy((char *)png_get_error_ptr(png), message, GO_PNG_MESSAGE_SIZE - 1);
+//     png_longjmp(png, 1);
+// }
+//
+// static void goPngWarning(png_structp png, png_const_charp message) {}
+//
+// // goPngEncode writes rows of pixels as a PNG with the libpng write API. The output grows from
+// // sizeHint bytes and must be freed by the caller, also on failure.
+// int goPngEncode(const unsigned char *pix, int width, int height, int stride, goPngOptions *opts,
+//                 const png_color *palette, int paletteLen, const unsigned char *trans, int transLen,
+//                 goPngBuffer *out, size_t sizeHint, char *message) {
+//     png_structp png;
+//     png_infop info;
+//     int y;
+//
+//     png = png_create_write_struct(PNG_LIBPNG_VER_STRING, message, goPngError, goPngWarning);
+//     if (png == NULL) {
+//         strcpy(message, "could not create png write struct");
+//         return -1;
+//     }
+//     info = png_create_info_struct(png);
+//     if (info == NULL) {
+//         png_destroy_write_struct(&png, NULL);
+//         strcpy(message, "could not create png info struct");
+//         return -1;
+//     }
+//     if (setjmp(png_jmpbuf(png))) {
+//         png_destroy_write_struct(&png, &info);
+//         return -1;
+//     }
+//
+//     out->data = malloc(sizeHint);
+//     out->cap = out->data == NULL ? 0 : sizeHint;
+//     png_set_write_fn(png, out, goPngWrite, goPngFlush);
+//     png_set_compression_level(png, opts->level);
+//     if (opts->strategy >= 0) {
+//         png_set_compression_strategy(png, opts->strategy);
+//     }
+//     png_set_filter(png, PNG_FILTER_TYPE_BASE, opts->filters);
+//
+//     png_set_IHDR(png, info, width, height, opts->bitDepth, opts->colorType,
+//                  PNG_INTERLACE_NONE, PNG_COMPRESSION_TYPE_DEFAULT, PNG_FILTER_TYPE_DEFAULT);
+//     if (paletteLen > 0) {
+//         png_set_PLTE(png, info, palette, paletteLen);
+//     }
+//     if (transLen > 0) {
+//         png_set_tRNS(png, info, trans, transLen, NULL);
+//     }
+//     if (opts->srgb) {
+//         png_set_sRGB_gAMA_and_cHRM(png, info, PNG_sRGB_INTENT_PERCEPTUAL);
+//     }
+//     png_write_info(png, info);
+//     if (opts->bitDepth < 8) {
+//         // Indices are stored one per byte, pack them into the smaller bit depth
+//         png_set_packing(png);
+//     }
+//
+//     for (y = 0; y < height; y++) {
+//         png_write_row(png, pix + (size_t)y * stride);
+//     }
+//     png_write_end(png, NULL);
+//     png_destroy_write_struct(&png, &info);
+//     return 0;
 // }
 import "C"
 
-// EncodePng will encode an image.Gray to PNG bytes, using libpng's simplified API for performance
+// PngCompressionLevel selects how hard zlib works to compress the image data.
+// Besides the named levels, the zlib levels 1 to 9 can be given directly.
+type PngCompressionLevel int
+
+const (
+	// PngDefaultCompression uses zlib level 3, a balance of speed and size that EncodePng has always used
+	PngDefaultCompression PngCompressionLevel = 0
+	// PngNoCompression stores the image data uncompressed
+	PngNoCompression PngCompressionLevel = -1
+	// PngBestSpeed uses zlib level 1
+	PngBestSpeed PngCompressionLevel = -2
+	// PngBestCompression uses zlib level 9
+	PngBestCompression PngCompressionLevel = -3
+)
+
+// zlibLevel returns the zlib compression level
+func (l PngCompressionLevel) zlibLevel() (int, error) {
+	switch {
+	case l == PngDefaultCompression:
+		return 3, nil
+	case l == PngNoCompression:
+		return 0, nil
+	case l == PngBestSpeed:
+		return 1, nil
+	case l == PngBestCompression:
+		return 9, nil
+	case l >= 1 && l <= 9:
+		return int(l), nil
+	default:
+		return 0, fmt.Errorf("invalid png compression level %d", l)
+	}
+}
+
+// PngStrategy selects the zlib compression strategy
+type PngStrategy int
+
+const (
+	// PngStrategyDefault lets libpng choose, which is PngStrategyFiltered when rows are filtered
+	PngStrategyDefault PngStrategy = iota
+	// PngStrategyGeneral is zlib's default strategy, for data without a known structure
+	PngStrategyGeneral
+	// PngStrategyFiltered suits filtered rows with small values
+	PngStrategyFiltered
+	// PngStrategyHuffmanOnly skips string matching, fast and good for photos with PngFilterPaeth
+	PngStrategyHuffmanOnly
+	// PngStrategyRLE only matches runs, nearly as fast as PngStrategyHuffmanOnly but better for scans
+	PngStrategyRLE
+	// PngStrategyFixed uses fixed Huffman codes
+	PngStrategyFixed
+)
+
+// zlibStrategy returns the zlib strategy, or -1 to leave the choice to libpng
+func (s PngStrategy) zlibStrategy() (int, error) {
+	switch s {
+	case PngStrategyDefault:
+		return -1, nil
+	case PngStrategyGeneral:
+		return C.Z_DEFAULT_STRATEGY, nil
+	case PngStrategyFiltered:
+		return C.Z_FILTERED, nil
+	case PngStrategyHuffmanOnly:
+		return C.Z_HUFFMAN_ONLY, nil
+	case PngStrategyRLE:
+		return C.Z_RLE, nil
+	case PngStrategyFixed:
+		return C.Z_FIXED, nil
+	default:
+		return 0, fmt.Errorf("invalid png compression strategy %d", s)
+	}
+}
+
+// PngFilter is a set of row filters. libpng picks the best filter in the set for each row,
+// trying more filters makes the output smaller at the cost of speed.
+type PngFilter int
+
+const (
+	PngFilterNone  PngFilter = C.PNG_FILTER_NONE
+	PngFilterSub   PngFilter = C.PNG_FILTER_SUB
+	PngFilterUp    PngFilter = C.PNG_FILTER_UP
+	PngFilterAvg   PngFilter = C.PNG_FILTER_AVG
+	PngFilterPaeth PngFilter = C.PNG_FILTER_PAETH
+	// PngFilterFast tries the filters that are cheap to compute
+	PngFilterFast = PngFilterNone | PngFilterSub | PngFilterUp
+	// PngFilterAll tries every filter, like most PNG writers do by default
+	PngFilterAll = PngFilterFast | PngFilterAvg | PngFilterPaeth
+)
+
+// PngEncodeOptions configures EncodePngWithOptions.
+// The zero value matches EncodePng, which favors speed over size.
+type PngEncodeOptions struct {
+	// CompressionLevel of the image data
+	CompressionLevel PngCompressionLevel
+	// Strategy of the zlib compression
+	Strategy PngStrategy
+	// Filters that may be applied to the rows, zero writes unfiltered rows
+	Filters PngFilter
+	// Preserve16Bit writes *image.Gray16, *image.RGBA64 and *image.NRGBA64 with 16 bits
+	// per sample instead of reducing them to 8 bits
+	Preserve16Bit bool
+	// Indexed writes *image.Paletted as indexed color with the smallest bit depth that fits
+	// the palette, instead of converting it to RGB or RGBA
+	Indexed bool
+	// Metadata is written to iCCP, pHYs and eXIf chunks
+	Metadata EncodeMetadata
+}
+
+// pngPixels holds rows of pixels in a layout that libpng can write
+type pngPixels struct {
+	pix           []uint8
+	width, height int
+	stride        int
+	colorType     C.int
+	bitDepth      C.int
+	palette       []C.png_color
+	trans         []C.uchar
+}
+
+// EncodePng will encode an image to PNG bytes, using libpng for performance.
+// Gray, RGB and straight alpha RGBA images are written from their own pixels,
+// any other image is converted to RGB, or to straight alpha RGBA if it has transparency.
 func EncodePng(buf *bytes.Buffer, img image.Image) ([]byte, error) {
-	var pix []uint8
-	var stride int
-	var format C.png_uint_32
+	return EncodePngWithOptions(buf, img, PngEncodeOptions{})
+}
 
+// EncodePngWithOptions will encode an image to PNG bytes with the given options, see EncodePng
+func EncodePngWithOptions(buf *bytes.Buffer, img image.Image, opts PngEncodeOptions) ([]byte, error) {
+	if img.Bounds().Empty() {
+		return nil, ErrEmptyInput
+	}
+	level, err := opts.CompressionLevel.zlibLevel()
+	if err != nil {
+		return nil, err
+	}
+	strategy, err := opts.Strategy.zlibStrategy()
+	if err != nil {
+		return nil, err
+	}
+	filters := opts.Filters
+	if filters == 0 {
+		filters = PngFilterNone
+	}
+
+	px := pngPixels{width: img.Bounds().Dx(), height: img.Bounds().Dy(), bitDepth: 8}
 	switch v := img.(type) {
 	case *image.Gray:
-		pix = v.Pix
-		stride = v.Stride
-		format = C.PNG_FORMAT_GRAY
+		px.pix = v.Pix[v.PixOffset(v.Rect.Min.X, v.Rect.Min.Y):]
+		px.stride = v.Stride
+		px.colorType = C.PNG_COLOR_TYPE_GRAY
 	case *RGBImage:
-		pix = v.Pix
-		stride = v.Stride
-		format = C.PNG_FORMAT_RGB
+		px.pix = v.Pix[v.PixOffset(v.Rect.Min.X, v.Rect.Min.Y):]
+		px.stride = v.Stride
+		px.colorType = C.PNG_COLOR_TYPE_RGB
+	case *image.NRGBA:
+		px.pix = v.Pix[v.PixOffset(v.Rect.Min.X, v.Rect.Min.Y):]
+		px.stride = v.Stride
+		px.colorType = C.PNG_COLOR_TYPE_RGB_ALPHA
 	case *image.RGBA:
-		pix = v.Pix
-		stride = v.Stride
-		format = C.PNG_FORMAT_RGBA
+		if !v.Opaque() {
+			// PNG stores straight alpha
+			return EncodePngWithOptions(buf, toNRGBA(v), opts)
+		}
+		px.pix = v.Pix[v.PixOffset(v.Rect.Min.X, v.Rect.Min.Y):]
+		px.stride = v.Stride
+		px.colorType = C.PNG_COLOR_TYPE_RGB_ALPHA
+	case *image.Gray16:
+		if !opts.Preserve16Bit {
+			return EncodePngWithOptions(buf, toGray(v), opts)
+		}
+		// Go and PNG both store 16-bit samples big endian
+		px.pix = v.Pix[v.PixOffset(v.Rect.Min.X, v.Rect.Min.Y):]
+		px.stride = v.Stride
+		px.colorType = C.PNG_COLOR_TYPE_GRAY
+		px.bitDepth = 16
+	case *image.NRGBA64:
+		if !opts.Preserve16Bit {
+			return EncodePngWithOptions(buf, pngConvert(v), opts)
+		}
+		px.pix = v.Pix[v.PixOffset(v.Rect.Min.X, v.Rect.Min.Y):]
+		px.stride = v.Stride
+		px.colorType = C.PNG_COLOR_TYPE_RGB_ALPHA
+		px.bitDepth = 16
+	case *image.RGBA64:
+		if !opts.Preserve16Bit {
+			return EncodePngWithOptions(buf, pngConvert(v), opts)
+		}
+		if !v.Opaque() {
+			// PNG stores straight alpha
+			return EncodePngWithOptions(buf, toNRGBA64(v), opts)
+		}
+		px.pix = v.Pix[v.PixOffset(v.Rect.Min.X, v.Rect.Min.Y):]
+		px.stride = v.Stride
+		px.colorType = C.PNG_COLOR_TYPE_RGB_ALPHA
+		px.bitDepth = 16
+	case *image.Paletted:
+		if !opts.Indexed || len(v.Palette) == 0 || len(v.Palette) > 256 {
+			return EncodePngWithOptions(buf, pngConvert(v), opts)
+		}
+		if err := setPngPalette(&px, v); err != nil {
+			return nil, err
+		}
 	default:
-		return nil, errors.New("unsupported image type")
-	}
-
-	var op C.png_controlp
-	info := &C.png_image{
-		opaque:           op,
-		version:          C.PNG_IMAGE_VERSION,
-		width:            C.uint(img.Bounds().Dx()),
-		height:           C.uint(img.Bounds().Dy()),
-		format:           format,
-		flags:            C.PNG_IMAGE_FLAG_FAST,
-		colormap_entries: 0,
-	}
-	defer C.png_image_free(info)
-
-	// Get memory size
-	var result C.int
-	var imageBytes C.png_alloc_size_t
-
-	// Make a buffer of sufficient size
-	maxSize := C.cpng_image_data_size(info)
-
-	// Prepare a buffer
-	buf.Reset()
-	buf.Grow(int(maxSize))
-	imageBytes = C.png_alloc_size_t(maxSize)
-	buf.WriteByte(0)
-
-	// Write the actual PNG
-	result = C.png_image_write_to_memory(
-		info,
-		unsafe.Pointer(&(buf.Bytes())[0]),
-		&imageBytes,
-		0,
-		unsafe.Pointer(&pix[0]),
-		C.int(stride),
-		nil,
+		return EncodePngWithOptions(buf, pngConvert(img), opts)
+	}
+
+	// The samples are sRGB encoded at any bit depth, unless a profile says otherwise
+	srgb := len(opts.Metadata.ICCProfile) == 0
+	copts := C.goPngOptions{
+		colorType: px.colorType,
+		bitDepth:  px.bitDepth,
+		level:     C.int(level),
+		strategy:  C.int(strategy),
+		filters:   C.int(filters),
+		srgb:      cBool(srgb),
+	}
+	var palette *C.png_color
+	if len(px.palette) > 0 {
+		palette = &px.palette[0]
+	}
+	var trans *C.uchar
+	if len(px.trans) > 0 {
+		trans = &px.trans[0]
+	}
+
+	// Start from the size of the previous output, the buffer is reused the same way
+	sizeHint := buf.Cap()
+	if sizeHint == 0 {
+		sizeHint = px.stride * px.height / 4
+	}
+
+	var out C.goPngBuffer
+	var message [C.GO_PNG_MESSAGE_SIZE]C.char
+	res := C.goPngEncode(
+		(*C.uchar)(unsafe.Pointer(&px.pix[0])),
+		C.int(px.width), C.int(px.height), C.int(px.stride), &copts,
+		palette, C.int(len(px.palette)), trans, C.int(len(px.trans)),
+		&out, C.size_t(sizeHint), &message[0],
 	)
-	if result == 0 {
-		return nil, fmt.Errorf("libpng threw an error (%x) %q", info.warning_or_error, C.GoString(&info.message[:][0]))
+	if out.data != nil {
+		defer C.free(unsafe.Pointer(out.data))
+	}
+	if res != 0 {
+		return nil, fmt.Errorf("libpng threw an error %q", C.GoString(&message[0]))
 	}
 
-	return buf.Bytes()[:int(imageBytes)], nil
+	data := C.GoBytes(unsafe.Pointer(out.data), C.int(out.size))
+	if !opts.Metadata.empty() {
+		data, err = pngWithMetadata(data, opts.Metadata)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return setEncoded(buf, data), nil
+}
+
+// pngConvert converts an image to RGB, or to straight alpha RGBA if it has transparency
+func pngConvert(img image.Image) image.Image {
+	if isOpaque(img) {
+		return toRGB(img)
+	}
+	return toNRGBA(img)
+}
+
+// setPngPalette sets up indexed color output for a paletted image
+func setPngPalette(px *pngPixels, img *image.Paletted) error {
+	px.pix = img.Pix[img.PixOffset(img.Rect.Min.X, img.Rect.Min.Y):]
+	px.stride = img.Stride
+	px.colorType = C.PNG_COLOR_TYPE_PALETTE
+
+	for y := 0; y < px.height; y++ {
+		row := px.pix[y*px.stride : y*px.stride+px.width]
+		for _, i := range row {
+			if int(i) >= len(img.Palette) {
+				return fmt.Errorf("palette index %d out of range", i)
+			}
+		}
+	}
+
+	switch n := len(img.Palette); {
+	case n <= 2:
+		px.bitDepth = 1
+	case n <= 4:
+		px.bitDepth = 2
+	case n <= 16:
+		px.bitDepth = 4
+	default:
+		px.bitDepth = 8
+	}
+
+	// Only the entries up to the last transparent one need an alpha value
+	px.palette = make([]C.png_color, len(img.Palette))
+	alpha := make([]C.uchar, len(img.Palette))
+	last := -1
+	for i, c := range img.Palette {
+		nc := color.NRGBAModel.Convert(c).(color.NRGBA)
+		px.palette[i] = C.png_color{red: C.png_byte(nc.R), green: C.png_byte(nc.G), blue: C.png_byte(nc.B)}
+		alpha[i] = C.uchar(nc.A)
+		if nc.A != 0xff {
+			last = i
+		}
+	}
+	px.trans = alpha[:last+1]
+	return nil
 }
@@ -2,6 +2,10 @@ package imagecoding
 
 import (
 	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
 	"image/png"
 	"os"
 	"testing"
@@ -21,6 +25,194 @@ func TestEncodePng(t *testing.T) {
 	assert.Equal(t, ref, outputHash)
 }
 
+func TestEncodePngSRGB(t *testing.T) {
+	src := makeConvertTestImages()["rgb-image"]
+	for name, opts := range map[string]PngEncodeOptions{
+		"8-bit":  {},
+		"16-bit": {Preserve16Bit: true},
+	} {
+		var buf bytes.Buffer
+		data, err := EncodePngWithOptions(&buf, src, opts)
+		if assert.NoError(t, err, name) {
+			assert.Equal(t, []byte{0}, pngChunk(data, "sRGB"), name)
+			assert.NotNil(t, pngChunk(data, "gAMA"), name)
+		}
+	}
+
+	// An embedded profile replaces the sRGB chunk
+	var buf bytes.Buffer
+	data, err := EncodePngWithOptions(&buf, src, PngEncodeOptions{Metadata: testMetadata()})
+	if assert.NoError(t, err) {
+		assert.Nil(t, pngChunk(data, "sRGB"))
+		assert.NotNil(t, pngChunk(data, "iCCP"))
+	}
+}
+
+func TestEncodePngImageTypes(t *testing.T) {
+	for name, src := range makeConvertTestImages() {
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+			imgbytes, err := EncodePng(&buf, src)
+			if !assert.NoError(t, err) {
+				return
+			}
+			output, err := png.Decode(bytes.NewReader(imgbytes))
+			if assert.NoError(t, err) {
+				// Lossless, apart from rounding in the color conversions
+				assertSimilar(t, src, output, 1)
+			}
+		})
+	}
+}
+
+func TestEncodePngWithOptions(t *testing.T) {
+	src := makeConvertTestImages()["nrgba"]
+	tests := []struct {
+		name string
+		opts PngEncodeOptions
+	}{
+		{"no-compression", PngEncodeOptions{CompressionLevel: PngNoCompression}},
+		{"best-speed", PngEncodeOptions{CompressionLevel: PngBestSpeed}},
+		{"best-compression", PngEncodeOptions{CompressionLevel: PngBestCompression, Filters: PngFilterAll}},
+		{"level-6", PngEncodeOptions{CompressionLevel: 6, Filters: PngFilterFast}},
+		{"paeth-huffman", PngEncodeOptions{Strategy: PngStrategyHuffmanOnly, Filters: PngFilterPaeth}},
+		{"rle", PngEncodeOptions{Strategy: PngStrategyRLE, Filters: PngFilterSub | PngFilterUp}},
+		{"fixed", PngEncodeOptions{Strategy: PngStrategyFixed, Filters: PngFilterAvg}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			imgbytes, err := EncodePngWithOptions(&buf, src, tt.opts)
+			if !assert.NoError(t, err) {
+				return
+			}
+			output, err := png.Decode(bytes.NewReader(imgbytes))
+			if assert.NoError(t, err) {
+				assert.Equal(t, src, output)
+			}
+		})
+	}
+
+	t.Run("sizes", func(t *testing.T) {
+		var buf bytes.Buffer
+		stored, err := EncodePngWithOptions(&buf, img, PngEncodeOptions{CompressionLevel: PngNoCompression})
+		if !assert.NoError(t, err) {
+			return
+		}
+		storedLen := len(stored)
+		best, err := EncodePngWithOptions(&buf, img, PngEncodeOptions{CompressionLevel: PngBestCompression, Filters: PngFilterAll})
+		if assert.NoError(t, err) {
+			assert.Less(t, len(best), storedLen)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		var buf bytes.Buffer
+		_, err := EncodePngWithOptions(&buf, src, PngEncodeOptions{CompressionLevel: 10})
+		assert.Error(t, err)
+		_, err = EncodePngWithOptions(&buf, src, PngEncodeOptions{Strategy: 42})
+		assert.Error(t, err)
+	})
+}
+
+func TestEncodePng16Bit(t *testing.T) {
+	rect := image.Rect(0, 0, 29, 17)
+	gray16 := image.NewGray16(rect)
+	nrgba64 := image.NewNRGBA64(rect)
+	rgba64 := image.NewRGBA64(rect)
+	for y := 0; y < rect.Dy(); y++ {
+		for x := 0; x < rect.Dx(); x++ {
+			gray16.SetGray16(x, y, color.Gray16{Y: uint16(x*2011 + y*97)})
+			nrgba64.SetNRGBA64(x, y, color.NRGBA64{uint16(x * 2011), uint16(y * 3001), uint16(x*y + 1), uint16(0xffff - x*y*3)})
+			rgba64.SetRGBA64(x, y, color.RGBA64{uint16(x * 2011), uint16(y * 3001), uint16(x*y + 1), 0xffff})
+		}
+	}
+	translucent := toNRGBA64(nrgba64)
+	premultiplied := image.NewRGBA64(rect)
+	for y := 0; y < rect.Dy(); y++ {
+		for x := 0; x < rect.Dx(); x++ {
+			premultiplied.Set(x, y, translucent.At(x, y))
+		}
+	}
+
+	for name, src := range map[string]image.Image{
+		"gray16":        gray16,
+		"nrgba64":       nrgba64,
+		"rgba64":        rgba64,
+		"rgba64-alpha":  premultiplied,
+		"gray16-subimg": gray16.SubImage(image.Rect(3, 2, 20, 15)),
+	} {
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+			imgbytes, err := EncodePngWithOptions(&buf, src, PngEncodeOptions{Preserve16Bit: true})
+			if !assert.NoError(t, err) {
+				return
+			}
+			output, err := png.Decode(bytes.NewReader(imgbytes))
+			if !assert.NoError(t, err) {
+				return
+			}
+			b := src.Bounds()
+			for y := 0; y < b.Dy(); y++ {
+				for x := 0; x < b.Dx(); x++ {
+					expected := color.NRGBA64Model.Convert(src.At(b.Min.X+x, b.Min.Y+y))
+					if !assert.Equal(t, expected, color.NRGBA64Model.Convert(output.At(x, y)), "pixel (%d, %d)", x, y) {
+						t.FailNow()
+					}
+				}
+			}
+		})
+	}
+
+	// Without the option the samples are reduced to 8 bits like before
+	var buf bytes.Buffer
+	imgbytes, err := EncodePng(&buf, gray16)
+	if assert.NoError(t, err) {
+		output, err := png.Decode(bytes.NewReader(imgbytes))
+		if assert.NoError(t, err) {
+			assert.IsType(t, &image.Gray{}, output)
+		}
+	}
+}
+
+func TestEncodePngIndexed(t *testing.T) {
+	rect := image.Rect(0, 0, 33, 21)
+	for _, pal := range []color.Palette{
+		{color.Black, color.White},
+		{color.Black, color.White, color.NRGBA{255, 0, 0, 128}},
+		{color.Transparent, color.Black, color.White, color.NRGBA{0, 0, 255, 255}, color.NRGBA{0, 255, 0, 255}},
+		palette.WebSafe,
+		palette.Plan9,
+	} {
+		t.Run(fmt.Sprintf("%d-colors", len(pal)), func(t *testing.T) {
+			src := image.NewPaletted(rect, pal)
+			for i := range src.Pix {
+				src.Pix[i] = uint8(i * 7 % len(pal))
+			}
+			var buf bytes.Buffer
+			imgbytes, err := EncodePngWithOptions(&buf, src, PngEncodeOptions{Indexed: true, CompressionLevel: PngBestCompression})
+			if !assert.NoError(t, err) {
+				return
+			}
+			output, err := png.Decode(bytes.NewReader(imgbytes))
+			if !assert.NoError(t, err) || !assert.IsType(t, &image.Paletted{}, output) {
+				return
+			}
+			paletted := output.(*image.Paletted)
+			assert.Equal(t, src.Pix, paletted.Pix)
+			for i := range pal {
+				assert.Equal(t, color.NRGBAModel.Convert(pal[i]), color.NRGBAModel.Convert(paletted.Palette[i]))
+			}
+		})
+	}
+
+	src := image.NewPaletted(rect, color.Palette{color.Black})
+	src.Pix[5] = 3
+	var buf bytes.Buffer
+	_, err := EncodePngWithOptions(&buf, src, PngEncodeOptions{Indexed: true})
+	assert.Error(t, err)
+}
+
 func BenchmarkPNG(b *testing.B) {
 	var err error
 	var buf bytes.Buffer
@@ -0,0 +1,190 @@
+package imagecoding
+
+import (
+	"image"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+// resizePlanes resizes gray and YCbCr images plane by plane and keeps their pixel type,
+// so that the color conversion only runs at the output size. Other images are resized
+// with imaging.Resize.
+func resizePlanes(img image.Image, width, height int, filter imaging.ResampleFilter) image.Image {
+	switch v := img.(type) {
+	case *image.Gray:
+		return resizePlane(v, width, height, filter)
+	case *image.YCbCr:
+		out := image.NewYCbCr(image.Rect(0, 0, width, height), v.SubsampleRatio)
+		copyPlane(out.Y, out.YStride, resizePlane(&image.Gray{Pix: v.Y, Stride: v.YStride, Rect: v.Rect}, width, height, filter))
+
+		// The chroma planes keep their subsampling
+		if v.CStride > 0 && len(v.Cb) >= v.CStride {
+			chromaIn := image.Rect(0, 0, ycbcrChromaWidth(v), len(v.Cb)/v.CStride)
+			chromaW, chromaH := ycbcrChromaWidth(out), len(out.Cb)/out.CStride
+			copyPlane(out.Cb, out.CStride, resizePlane(&image.Gray{Pix: v.Cb, Stride: v.CStride, Rect: chromaIn}, chromaW, chromaH, filter))
+			copyPlane(out.Cr, out.CStride, resizePlane(&image.Gray{Pix: v.Cr, Stride: v.CStride, Rect: chromaIn}, chromaW, chromaH, filter))
+		}
+		return out
+	default:
+		return imaging.Resize(img, width, height, filter)
+	}
+}
+
+// resizePlane resizes a single 8-bit plane with the weights imaging uses, without converting
+// it to NRGBA and back
+func resizePlane(plane *image.Gray, width, height int, filter imaging.ResampleFilter) *image.Gray {
+	srcW, srcH := plane.Rect.Dx(), plane.Rect.Dy()
+	if filter.Support <= 0 {
+		return resizePlaneNearest(plane, width, height)
+	}
+	src := plane
+	if srcW != width {
+		src = resizePlaneHorizontal(src, width, filter)
+	}
+	if srcH != height {
+		src = resizePlaneVertical(src, height, filter)
+	}
+	if src == plane {
+		out := image.NewGray(image.Rect(0, 0, width, height))
+		copyPlane(out.Pix, out.Stride, plane)
+		return out
+	}
+	return src
+}
+
+// resizePlaneHorizontal resamples the rows of a plane to a width
+func resizePlaneHorizontal(plane *image.Gray, width int, filter imaging.ResampleFilter) *image.Gray {
+	srcW, srcH := plane.Rect.Dx(), plane.Rect.Dy()
+	out := image.NewGray(image.Rect(0, 0, width, srcH))
+	weights := planeWeights(width, srcW, filter)
+	for y := 0; y < srcH; y++ {
+		row := plane.Pix[plane.PixOffset(plane.Rect.Min.X, plane.Rect.Min.Y+y):]
+		dst := out.Pix[y*out.Stride:]
+		for x, ws := range weights {
+			var v, sum float64
+			for _, w := range ws {
+				v += float64(row[w.index]) * w.weight
+				sum += w.weight
+			}
+			if sum != 0 {
+				dst[x] = clampUint8(v * (1 / sum))
+			}
+		}
+	}
+	return out
+}
+
+// resizePlaneVertical resamples the columns of a plane to a height
+func resizePlaneVertical(plane *image.Gray, height int, filter imaging.ResampleFilter) *image.Gray {
+	srcW, srcH := plane.Rect.Dx(), plane.Rect.Dy()
+	out := image.NewGray(image.Rect(0, 0, srcW, height))
+	weights := planeWeights(height, srcH, filter)
+	base := plane.PixOffset(plane.Rect.Min.X, plane.Rect.Min.Y)
+	// Rows are accumulated whole to read the source in order
+	sums := make([]float64, srcW)
+	for y, ws := range weights {
+		for i := range sums {
+			sums[i] = 0
+		}
+		var sum float64
+		for _, w := range ws {
+			sum += w.weight
+			row := plane.Pix[base+w.index*plane.Stride : base+w.index*plane.Stride+srcW]
+			for x, v := range row {
+				sums[x] += float64(v) * w.weight
+			}
+		}
+		if sum == 0 {
+			continue
+		}
+		dst := out.Pix[y*out.Stride : y*out.Stride+srcW]
+		for x := range dst {
+			dst[x] = clampUint8(sums[x] * (1 / sum))
+		}
+	}
+	return out
+}
+
+// resizePlaneNearest picks the nearest source sample like imaging does for NearestNeighbor
+func resizePlaneNearest(plane *image.Gray, width, height int) *image.Gray {
+	out := image.NewGray(image.Rect(0, 0, width, height))
+	dx := float64(plane.Rect.Dx()) / float64(width)
+	dy := float64(plane.Rect.Dy()) / float64(height)
+	for y := 0; y < height; y++ {
+		row := plane.Pix[plane.PixOffset(plane.Rect.Min.X, plane.Rect.Min.Y+int((float64(y)+0.5)*dy)):]
+		dst := out.Pix[y*out.Stride:]
+		for x := 0; x < width; x++ {
+			dst[x] = row[int((float64(x)+0.5)*dx)]
+		}
+	}
+	return out
+}
+
+// planeWeight is the weight of a source sample in an output sample
+type planeWeight struct {
+	index  int
+	weight float64
+}
+
+// planeWeights returns the filter weights of the source samples for each output sample. They are
+// normalized and scaled by an opaque alpha, and divided by their sum again when applied, which is
+// the arithmetic of imaging and gives its results to the bit.
+func planeWeights(dstSize, srcSize int, filter imaging.ResampleFilter) [][]planeWeight {
+	du := float64(srcSize) / float64(dstSize)
+	scale := math.Max(du, 1)
+	ru := math.Ceil(scale * filter.Support)
+
+	out := make([][]planeWeight, dstSize)
+	for v := range out {
+		fu := (float64(v)+0.5)*du - 0.5
+		begin := int(math.Max(0, math.Ceil(fu-ru)))
+		end := int(math.Min(float64(srcSize-1), math.Floor(fu+ru)))
+		var ws []planeWeight
+		var sum float64
+		for u := begin; u <= end; u++ {
+			if w := filter.Kernel((float64(u) - fu) / scale); w != 0 {
+				sum += w
+				ws = append(ws, planeWeight{u, w})
+			}
+		}
+		if sum != 0 {
+			for i := range ws {
+				ws[i].weight = 255 * (ws[i].weight / sum)
+			}
+		}
+		out[v] = ws
+	}
+	return out
+}
+
+// clampUint8 rounds a sample to 8 bits
+func clampUint8(v float64) uint8 {
+	switch {
+	case v >= 254.5:
+		return 255
+	case v <= 0:
+		return 0
+	}
+	return uint8(v + 0.5)
+}
+
+// copyPlane copies a resized plane into the rows of a plane with the given stride
+func copyPlane(dst []uint8, stride int, src *image.Gray) {
+	for y := 0; y < src.Rect.Dy(); y++ {
+		copy(dst[y*stride:], src.Pix[y*src.Stride:y*src.Stride+src.Rect.Dx()])
+	}
+}
+
+// ycbcrChromaWidth returns the number of chroma samples in a row of a YCbCr image
+func ycbcrChromaWidth(img *image.YCbCr) int {
+	w := img.Rect.Dx()
+	switch img.SubsampleRatio {
+	case image.YCbCrSubsampleRatio422, image.YCbCrSubsampleRatio420:
+		return (w + 1) / 2
+	case image.YCbCrSubsampleRatio411, image.YCbCrSubsampleRatio410:
+		return (w + 3) / 4
+	default:
+		return w
+	}
+}
@@ -0,0 +1,67 @@
+package imagecoding
+
+import (
+	"image"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResizePlanes(t *testing.T) {
+	// Thin dark lines of text over smooth colors
+	ycbcr := image.NewYCbCr(image.Rect(0, 0, 60, 40), image.YCbCrSubsampleRatio420)
+	for y := 0; y < 40; y++ {
+		for x := 0; x < 60; x++ {
+			yi, ci := ycbcr.YOffset(x, y), ycbcr.COffset(x, y)
+			ycbcr.Y[yi] = uint8(120 + x + y)
+			if x%4 == 0 {
+				ycbcr.Y[yi] = 40
+			}
+			ycbcr.Cb[ci], ycbcr.Cr[ci] = uint8(108+x/2), uint8(148-y/2)
+		}
+	}
+
+	for _, filter := range []imaging.ResampleFilter{imaging.CatmullRom, imaging.Box, imaging.Lanczos} {
+		out := resizePlanes(ycbcr, 30, 20, filter)
+		if assert.IsType(t, &image.YCbCr{}, out) {
+			// Luma is resized exactly like imaging does, chroma at its own resolution
+			assert.Equal(t, toGray(imaging.Resize(toGray(ycbcr), 30, 20, filter)).Pix, toGray(out).Pix)
+			assertSimilar(t, imaging.Resize(ycbcr, 30, 20, filter), out, 12)
+		}
+
+		gray := resizePlanes(toGray(ycbcr), 30, 20, filter)
+		if assert.IsType(t, &image.Gray{}, gray) {
+			assert.Equal(t, toGray(out).Pix, gray.(*image.Gray).Pix)
+		}
+	}
+
+	rgb := resizePlanes(toRGB(ycbcr), 30, 20, imaging.CatmullRom)
+	assert.Equal(t, image.Rect(0, 0, 30, 20), rgb.Bounds())
+
+	// Planes are resampled directly with the results of imaging, also for sub-images and enlarging
+	plane := toGray(ycbcr).SubImage(image.Rect(3, 5, 57, 38)).(*image.Gray)
+	for _, filter := range []imaging.ResampleFilter{imaging.NearestNeighbor, imaging.Linear, imaging.CatmullRom, imaging.Lanczos} {
+		for _, size := range [][2]int{{27, 11}, {54, 20}, {80, 33}, {100, 70}} {
+			expected := toGray(imaging.Resize(plane, size[0], size[1], filter))
+			assert.Equal(t, expected.Pix, resizePlane(plane, size[0], size[1], filter).Pix, "%v", size)
+		}
+	}
+}
+
+func BenchmarkResizePlane(b *testing.B) {
+	plane := image.NewGray(image.Rect(0, 0, 2480, 3508))
+	for i := range plane.Pix {
+		plane.Pix[i] = uint8(i * 7)
+	}
+	b.Run("direct", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			resizePlane(plane, 1240, 1754, imaging.CatmullRom)
+		}
+	})
+	b.Run("imaging", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			toGray(imaging.Resize(plane, 1240, 1754, imaging.CatmullRom))
+		}
+	})
+}
@@ -0,0 +1,143 @@
+package imagecoding
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"math"
+
+	"github.com/h2non/filetype"
+)
+
+// Resolution is the physical resolution of an image in pixels per inch, zero when unknown
+type Resolution struct {
+	X, Y float64
+}
+
+// Known reports whether the resolution is known in both directions
+func (r Resolution) Known() bool {
+	return r.X > 0 && r.Y > 0
+}
+
+// ImageConfig is an image.Config with the format and the physical resolution of the image
+type ImageConfig struct {
+	image.Config
+	Format     string
+	Resolution Resolution
+}
+
+// DecodeImageConfig is DecodeConfig extended with the resolution of ImageResolution
+func DecodeImageConfig(content []byte) (ImageConfig, error) {
+	cfg, format, err := DecodeConfig(content)
+	if err != nil {
+		return ImageConfig{}, err
+	}
+	res, err := ImageResolution(content)
+	if err != nil {
+		return ImageConfig{}, err
+	}
+	return ImageConfig{Config: cfg, Format: format, Resolution: res}, nil
+}
+
+// ImageResolution returns the physical resolution of an image, zero when the file does not tell.
+// It is read from the JFIF density or EXIF of a JPEG, the pHYs chunk or EXIF of a PNG, the
+// resolution tags of the first page of a TIFF file and the EXIF of WebP, HEIF and AVIF files.
+// Resolutions that only give the aspect ratio of the pixels are unknown.
+func ImageResolution(data []byte) (Resolution, error) {
+	if len(data) == 0 {
+		return Resolution{}, ErrEmptyInput
+	}
+	kind, err := filetype.Match(data)
+	if err != nil {
+		return Resolution{}, errors.New("could not determine file type")
+	}
+	switch ImgFormat(kind.Extension) {
+	case Jpeg:
+		segments, err := jpegSegments(data)
+		if err != nil {
+			return Resolution{}, err
+		}
+		return jpegResolution(segments), nil
+	case Png:
+		if phys := pngChunk(data, "pHYs"); len(phys) == 9 && phys[8] == 1 {
+			// Pixels per metre
+			return Resolution{
+				X: float64(binary.BigEndian.Uint32(phys)) * 0.0254,
+				Y: float64(binary.BigEndian.Uint32(phys[4:])) * 0.0254,
+			}, nil
+		}
+		return exifResolution(pngChunk(data, "eXIf")), nil
+	case Webp:
+		return exifResolution(webpChunk(data, "EXIF")), nil
+	case Tiff:
+		ifds, err := tiffIFDs(data)
+		if err != nil {
+			return Resolution{}, err
+		}
+		return tiffResolution(data, ifds[0]), nil
+	case Heif, Avif:
+		id, err := heifPrimaryItem(data)
+		if err != nil {
+			return Resolution{}, err
+		}
+		info, err := parseHeifItem(data, id)
+		return exifResolution(info.exif), err
+	default:
+		return Resolution{}, nil
+	}
+}
+
+// jpegResolution reads the density of the JFIF marker, or the resolution of the EXIF marker
+// when the density is an aspect ratio
+func jpegResolution(segments []jpegSegment) Resolution {
+	for _, s := range segments {
+		if s.marker != jpegAPP0 || len(s.payload) < 12 || !bytes.HasPrefix(s.payload, []byte("JFIF\x00")) {
+			continue
+		}
+		x, y := float64(binary.BigEndian.Uint16(s.payload[8:])), float64(binary.BigEndian.Uint16(s.payload[10:]))
+		switch s.payload[7] {
+		case 1:
+			return Resolution{X: x, Y: y}
+		case 2:
+			// Dots per centimeter
+			return Resolution{X: x * 2.54, Y: y * 2.54}
+		}
+	}
+	for _, s := range segments {
+		if s.marker == jpegAPP1 && bytes.HasPrefix(s.payload, []byte("Exif\x00\x00")) {
+			return exifResolution(s.payload)
+		}
+	}
+	return Resolution{}
+}
+
+// exifResolution reads the resolution of the first directory of EXIF data
+func exifResolution(exif []byte) Resolution {
+	exif = bytes.TrimPrefix(exif, []byte("Exif\x00\x00"))
+	order := tiffByteOrder(exif)
+	if order == nil {
+		return Resolution{}
+	}
+	ifd := order.Uint32(exif[4:])
+	if uint64(ifd)+2 > uint64(len(exif)) || uint64(ifd)+2+12*uint64(order.Uint16(exif[ifd:])) > uint64(len(exif)) {
+		return Resolution{}
+	}
+	return tiffResolution(exif, ifd)
+}
+
+// DPIScale returns a ScaleFunc that resamples an image of the source resolution to target pixels
+// per inch, keeping its physical size, so a small card scanned at a high resolution is not rendered
+// like a page. Like DefaultScale it never enlarges an image, and it falls back to DefaultScale when
+// the source resolution is unknown. It scales uniformly like the other ScaleFuncs: pixels that are
+// not square stay so, and the higher of the two resolutions is brought down to target. That makes
+// the factor the same for images the EXIF orientation turns by 90 degrees.
+func DPIScale(target float64, source Resolution) ScaleFunc {
+	if !source.Known() || target <= 0 {
+		return DefaultScale
+	}
+	factor := math.Min(1, target/math.Max(source.X, source.Y))
+	return factorScale(func(w, h float64) float64 {
+		return factor
+	})
+}
@@ -0,0 +1,134 @@
+package imagecoding
+
+import (
+	"bytes"
+	"image"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/image/tiff"
+)
+
+func TestImageResolution(t *testing.T) {
+	src := makeP3Red()
+	meta := EncodeMetadata{DPI: 300}
+	var buf bytes.Buffer
+	jpegData, err := EncodeJpegWithOptions(&buf, src, JpegEncodeOptions{Metadata: meta})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	jpegData = append([]byte{}, jpegData...)
+	cmykData, err := EncodeJpegWithOptions(&buf, image.NewCMYK(src.Rect), JpegEncodeOptions{Metadata: meta})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	cmykData = append([]byte{}, cmykData...)
+	pngData, err := EncodePngWithOptions(&buf, src, PngEncodeOptions{Metadata: meta})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	pngData = append([]byte{}, pngData...)
+	webpData, err := EncodeWebPWithOptions(&buf, src, WebPEncodeOptions{Metadata: meta})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	webpData = append([]byte{}, webpData...)
+	// The Go encoder writes 72 dpi
+	var tiffBuf bytes.Buffer
+	if !assert.NoError(t, tiff.Encode(&tiffBuf, src, nil)) {
+		t.FailNow()
+	}
+
+	// JFIF density in dots per centimeter
+	metric := append([]byte{}, jpegData...)
+	copy(metric[2+2+2+7:], []byte{2, 0, 59, 0, 118})
+	plain, err := EncodeJpeg(&buf, src, 90)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	plain = append([]byte{}, plain...)
+
+	tests := []struct {
+		name     string
+		data     []byte
+		expected Resolution
+	}{
+		{"jpeg", jpegData, Resolution{300, 300}},
+		{"jpeg exif", cmykData, Resolution{300, 300}},
+		{"jpeg metric", metric, Resolution{59 * 2.54, 118 * 2.54}},
+		{"jpeg aspect ratio", plain, Resolution{}},
+		{"png", pngData, Resolution{300, 300}},
+		{"webp", webpData, Resolution{300, 300}},
+		{"tiff", tiffBuf.Bytes(), Resolution{72, 72}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := ImageResolution(tt.data)
+			if assert.NoError(t, err) {
+				assert.InDelta(t, tt.expected.X, res.X, 0.05)
+				assert.InDelta(t, tt.expected.Y, res.Y, 0.05)
+				assert.Equal(t, tt.expected.Known(), res.Known())
+			}
+		})
+	}
+
+	cfg, err := DecodeImageConfig(pngData)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 8, cfg.Width)
+		assert.Equal(t, string(Png), cfg.Format)
+		assert.InDelta(t, 300, cfg.Resolution.X, 0.05)
+	}
+	_, err = ImageResolution(nil)
+	assert.Equal(t, ErrEmptyInput, err)
+}
+
+func TestDPIScale(t *testing.T) {
+	// A business card scanned at 600 dpi keeps its size at 150 dpi
+	w, h, s := DPIScale(150, Resolution{600, 600})(2100, 1200)
+	assert.Equal(t, []int{525, 300}, []int{w, h})
+	assert.Equal(t, 0.25, s)
+
+	// Low resolutions are not enlarged
+	w, h, s = DPIScale(150, Resolution{72, 72})(800, 600)
+	assert.Equal(t, []int{800, 600}, []int{w, h})
+	assert.Equal(t, 1.0, s)
+
+	// Fax pixels keep their aspect ratio, the fine direction is brought down to the target,
+	// also when the page is turned
+	w, h, s = DPIScale(98, Resolution{204, 98})(1728, 1100)
+	assert.Equal(t, []int{830, 528}, []int{w, h})
+	assert.InDelta(t, 98.0/204, s, 1e-9)
+	w, h, _ = DPIScale(98, Resolution{204, 98})(1100, 1728)
+	assert.Equal(t, []int{528, 830}, []int{w, h})
+
+	// An unknown resolution scales like DefaultScale
+	w, h, s = DPIScale(150, Resolution{})(4000, 3000)
+	dw, dh, ds := DefaultScale(4000, 3000)
+	assert.Equal(t, []int{dw, dh}, []int{w, h})
+	assert.Equal(t, ds, s)
+
+	// Every transform path gives the physical size
+	src := image.NewGray(image.Rect(0, 0, 400, 240))
+	var buf bytes.Buffer
+	for _, format := range []ImgFormat{Jpeg, Png} {
+		var data []byte
+		var err error
+		if format == Jpeg {
+			data, err = EncodeJpegWithOptions(&buf, src, JpegEncodeOptions{Metadata: EncodeMetadata{DPI: 600}})
+		} else {
+			data, err = EncodePngWithOptions(&buf, src, PngEncodeOptions{Metadata: EncodeMetadata{DPI: 600}})
+		}
+		if !assert.NoError(t, err) {
+			continue
+		}
+		cfg, err := DecodeImageConfig(data)
+		if !assert.NoError(t, err) {
+			continue
+		}
+		res, err := TransformWithOptions(data, TransformOptions{Scale: DPIScale(150, cfg.Resolution)})
+		if assert.NoError(t, err, format) {
+			assert.Equal(t, 100, res.OutWidth, format)
+			assert.Equal(t, 60, res.OutHeight, format)
+		}
+	}
+}
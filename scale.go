@@ -0,0 +1,138 @@
+package imagecoding
+
+import "math"
+
+// The ScaleFuncs below scale uniformly: the sizes they return are the source size times the scale
+// factor, rounded. JPEGs only use the factor and are decoded at the closest DCT scale factor, a
+// multiple of 1/8 up to 2, so they get the returned size only when the factor is one of those.
+// Targets may enlarge images, wrap them in NoUpscale to prevent that.
+
+// PaperSize is the size of a sheet of paper in millimetres, the short side first
+type PaperSize struct {
+	Short, Long float64
+}
+
+// Paper sizes for FitPaper
+var (
+	PaperA3     = PaperSize{297, 420}
+	PaperA4     = PaperSize{210, 297}
+	PaperA5     = PaperSize{148, 210}
+	PaperLetter = PaperSize{215.9, 279.4}
+	PaperLegal  = PaperSize{215.9, 355.6}
+)
+
+// Pixels returns the size of the paper in pixels at a resolution in pixels per inch
+func (p PaperSize) Pixels(ppi float64) (short, long float64) {
+	return p.Short / 25.4 * ppi, p.Long / 25.4 * ppi
+}
+
+// scaleBy returns the rounded size of an image scaled by a factor, at least a pixel
+func scaleBy(width, height int, factor float64) (imgWidth, imgHeight int, scaleFactor float64) {
+	imgWidth = int(math.Max(1, math.Round(float64(width)*factor)))
+	imgHeight = int(math.Max(1, math.Round(float64(height)*factor)))
+	return imgWidth, imgHeight, factor
+}
+
+// factorScale makes a ScaleFunc of a function from the source size to a scale factor
+func factorScale(factor func(w, h float64) float64) ScaleFunc {
+	return func(pageWidth, pageHeight int) (imgWidth, imgHeight int, scaleFactor float64) {
+		if pageWidth <= 0 || pageHeight <= 0 {
+			return pageWidth, pageHeight, 1
+		}
+		return scaleBy(pageWidth, pageHeight, factor(float64(pageWidth), float64(pageHeight)))
+	}
+}
+
+// FitWithin scales images to fit within width x height pixels
+func FitWithin(width, height int) ScaleFunc {
+	return factorScale(func(w, h float64) float64 {
+		return math.Min(float64(width)/w, float64(height)/h)
+	})
+}
+
+// FitPaper scales images to fit a sheet of paper at ppi pixels per inch, in portrait or landscape
+// like the image. DefaultScale is NoUpscale(FitPaper(PaperA4, 150)).
+func FitPaper(paper PaperSize, ppi float64) ScaleFunc {
+	short, long := paper.Pixels(ppi)
+	return factorScale(func(w, h float64) float64 {
+		return math.Min(short/math.Min(w, h), long/math.Max(w, h))
+	})
+}
+
+// ExactWidth scales images to a width
+func ExactWidth(width int) ScaleFunc {
+	return factorScale(func(w, h float64) float64 {
+		return float64(width) / w
+	})
+}
+
+// ExactHeight scales images to a height
+func ExactHeight(height int) ScaleFunc {
+	return factorScale(func(w, h float64) float64 {
+		return float64(height) / h
+	})
+}
+
+// ShortSide scales images so their shorter side has a length
+func ShortSide(pixels int) ScaleFunc {
+	return factorScale(func(w, h float64) float64 {
+		return float64(pixels) / math.Min(w, h)
+	})
+}
+
+// Megapixels scales images to an area of mp million pixels
+func Megapixels(mp float64) ScaleFunc {
+	return factorScale(func(w, h float64) float64 {
+		return math.Sqrt(mp * 1e6 / (w * h))
+	})
+}
+
+// ClampScale keeps the scale factor of f between lo and hi
+func ClampScale(f ScaleFunc, lo, hi float64) ScaleFunc {
+	return func(pageWidth, pageHeight int) (imgWidth, imgHeight int, scaleFactor float64) {
+		imgWidth, imgHeight, scaleFactor = f(pageWidth, pageHeight)
+		switch {
+		case scaleFactor < lo:
+			return scaleBy(pageWidth, pageHeight, lo)
+		case scaleFactor > hi:
+			return scaleBy(pageWidth, pageHeight, hi)
+		}
+		return imgWidth, imgHeight, scaleFactor
+	}
+}
+
+// NoUpscale keeps images at their size where f would enlarge them
+func NoUpscale(f ScaleFunc) ScaleFunc {
+	return ClampScale(f, 0, 1)
+}
+
+// NoDownscale keeps images at their size where f would reduce them
+func NoDownscale(f ScaleFunc) ScaleFunc {
+	return ClampScale(f, 1, math.Inf(1))
+}
+
+// Smallest uses the ScaleFunc with the smallest scale factor, so the limits of all of them hold,
+// like a megapixel cap on a paper size
+func Smallest(fs ...ScaleFunc) ScaleFunc {
+	return pickScale(fs, func(a, b float64) bool { return a < b })
+}
+
+// Largest uses the ScaleFunc with the largest scale factor, like a minimum size for OCR
+// on a paper size
+func Largest(fs ...ScaleFunc) ScaleFunc {
+	return pickScale(fs, func(a, b float64) bool { return a > b })
+}
+
+// pickScale uses the result of the ScaleFunc whose scale factor is preferred over the others
+func pickScale(fs []ScaleFunc, prefer func(a, b float64) bool) ScaleFunc {
+	return func(pageWidth, pageHeight int) (imgWidth, imgHeight int, scaleFactor float64) {
+		imgWidth, imgHeight, scaleFactor = pageWidth, pageHeight, 1
+		for i, f := range fs {
+			w, h, s := f(pageWidth, pageHeight)
+			if i == 0 || prefer(s, scaleFactor) {
+				imgWidth, imgHeight, scaleFactor = w, h, s
+			}
+		}
+		return imgWidth, imgHeight, scaleFactor
+	}
+}
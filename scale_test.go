@@ -0,0 +1,101 @@
+package imagecoding
+
+import (
+	"bytes"
+	"image"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScaleFuncs(t *testing.T) {
+	tests := []struct {
+		name          string
+		scale         ScaleFunc
+		width, height int
+		expected      [2]int
+	}{
+		{"fit within", FitWithin(800, 600), 4000, 2000, [2]int{800, 400}},
+		{"fit within enlarges", FitWithin(800, 600), 400, 400, [2]int{600, 600}},
+		{"a4 portrait", FitPaper(PaperA4, 150), 2480, 3508, [2]int{1240, 1754}},
+		{"a4 landscape", FitPaper(PaperA4, 150), 3508, 2480, [2]int{1754, 1240}},
+		{"letter", FitPaper(PaperLetter, 200), 5100, 6600, [2]int{1700, 2200}},
+		{"legal", FitPaper(PaperLegal, 100), 850, 1400, [2]int{850, 1400}},
+		{"a3", FitPaper(PaperA3, 100), 2000, 2000, [2]int{1169, 1169}},
+		{"a5", FitPaper(PaperA5, 100), 1000, 2000, [2]int{413, 827}},
+		{"exact width", ExactWidth(500), 2000, 1000, [2]int{500, 250}},
+		{"exact height", ExactHeight(500), 2000, 1000, [2]int{1000, 500}},
+		{"short side", ShortSide(1000), 3000, 2000, [2]int{1500, 1000}},
+		{"megapixels", Megapixels(2), 4000, 2000, [2]int{2000, 1000}},
+		{"no upscale", NoUpscale(ExactWidth(500)), 250, 100, [2]int{250, 100}},
+		{"no downscale", NoDownscale(ExactWidth(500)), 1000, 100, [2]int{1000, 100}},
+		{"clamp", ClampScale(ExactWidth(100), 0.5, 2), 1000, 1000, [2]int{500, 500}},
+		{"smallest", Smallest(FitPaper(PaperA4, 300), Megapixels(1)), 4000, 3000, [2]int{1155, 866}},
+		{"largest", Largest(DefaultScale, NoUpscale(ShortSide(1600))), 4000, 3000, [2]int{2133, 1600}},
+		{"largest small", Largest(DefaultScale, NoUpscale(ShortSide(1600))), 1000, 500, [2]int{1000, 500}},
+		{"at least a pixel", ExactWidth(10), 1000, 10, [2]int{10, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, h, s := tt.scale(tt.width, tt.height)
+			assert.Equal(t, tt.expected, [2]int{w, h})
+			// The sizes follow from the scale factor, which is all the JPEG DCT scaling uses
+			assert.Equal(t, int(math.Max(1, math.Round(float64(tt.width)*s))), w)
+			assert.Equal(t, int(math.Max(1, math.Round(float64(tt.height)*s))), h)
+		})
+	}
+
+	// An empty page stays as it is
+	w, h, s := Megapixels(1)(0, 0)
+	assert.Equal(t, []float64{0, 0, 1}, []float64{float64(w), float64(h), s})
+}
+
+func TestFitPaperDefaultScale(t *testing.T) {
+	scale := NoUpscale(FitPaper(PaperA4, 150))
+	for _, size := range [][2]int{{100, 100}, {1204, 1754}, {2480, 3508}, {3508, 2480}, {5000, 1000}, {1000, 5000}, {1300, 1300}} {
+		w, h, s := scale(size[0], size[1])
+		dw, dh, ds := DefaultScale(size[0], size[1])
+		assert.Equal(t, [2]int{dw, dh}, [2]int{w, h}, "%v", size)
+		assert.InDelta(t, ds, s, 1e-9, "%v", size)
+	}
+}
+
+func TestScaleFuncTransforms(t *testing.T) {
+	src := image.NewGray(image.Rect(0, 0, 400, 240))
+	var buf bytes.Buffer
+	jpegData, err := EncodeJpeg(&buf, src, 90)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	jpegData = append([]byte{}, jpegData...)
+	pngData, err := EncodePng(&buf, src)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	// Factors the DCT scaling has give the same size on every path
+	for _, scale := range []ScaleFunc{FitWithin(200, 200), ExactWidth(100), Megapixels(0.024), ExactHeight(240)} {
+		for _, data := range [][]byte{jpegData, pngData} {
+			res, err := TransformWithOptions(data, TransformOptions{Scale: scale, ColorMode: ColorModeGray})
+			if assert.NoError(t, err) {
+				w, h, s := scale(400, 240)
+				assert.Equal(t, [2]int{w, h}, [2]int{res.OutWidth, res.OutHeight}, "%s", res.Format)
+				assert.Equal(t, s, res.ScaleFactor)
+			}
+		}
+	}
+
+	// Other factors give JPEGs the size of the closest DCT factor, 3/8 for 0.325
+	scale := ExactWidth(130)
+	res, err := TransformWithOptions(pngData, TransformOptions{Scale: scale, ColorMode: ColorModeGray})
+	if assert.NoError(t, err) {
+		assert.Equal(t, [2]int{130, 78}, [2]int{res.OutWidth, res.OutHeight})
+		assert.Equal(t, 0.325, res.ScaleFactor)
+	}
+	res, err = TransformWithOptions(jpegData, TransformOptions{Scale: scale, ColorMode: ColorModeGray})
+	if assert.NoError(t, err) {
+		assert.Equal(t, [2]int{150, 90}, [2]int{res.OutWidth, res.OutHeight})
+		assert.Equal(t, 0.375, res.ScaleFactor)
+	}
+}
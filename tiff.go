@@ -0,0 +1,62 @@
+package imagecoding
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"golang.org/x/image/tiff"
+)
+
+// tiffPage returns a copy of a TIFF file whose first directory is the page with the given index.
+// The other offsets in a TIFF file are absolute, so the page decodes like a single page file.
+func tiffPage(data []byte, index int) ([]byte, error) {
+	ifds, err := tiffIFDs(data)
+	if err != nil {
+		return nil, err
+	}
+	if index < 0 || index >= len(ifds) {
+		return nil, ErrPageOutOfRange
+	}
+	if index == 0 {
+		return data, nil
+	}
+	page := make([]byte, len(data))
+	copy(page, data)
+	if page[0] == 'I' {
+		binary.LittleEndian.PutUint32(page[4:], ifds[index])
+	} else {
+		binary.BigEndian.PutUint32(page[4:], ifds[index])
+	}
+	return page, nil
+}
+
+// transformTiffPageGo decodes a page of a TIFF file with the pure Go decoder, each page has its own orientation
+func transformTiffPageGo(data []byte, index int, opts TransformOptions) (TransformResult, error) {
+	page, err := tiffPage(data, index)
+	if err != nil {
+		return TransformResult{}, err
+	}
+
+	// Check the limits before decoding the full image
+	conf, err := tiff.DecodeConfig(bytes.NewReader(page))
+	if err != nil {
+		return TransformResult{}, err
+	}
+	if err = opts.Limits.check(conf.Width, conf.Height); err != nil {
+		return TransformResult{}, err
+	}
+
+	orient := TopLeft
+	if opts.Orientation == OrientationAuto {
+		orient = GetOrientation(bytes.NewReader(page))
+	}
+	img, err := tiff.Decode(bytes.NewReader(page))
+	if err != nil {
+		return TransformResult{}, err
+	}
+	var profile []byte
+	if opts.ConvertToSRGB {
+		profile = tiffICCProfile(page, 0)
+	}
+	return finishTransform(FixOrientation(img, orient), Tiff, orient, profile, opts), nil
+}
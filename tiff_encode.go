@@ -0,0 +1,328 @@
+//go:build libtiff
+// +build libtiff
+
+package imagecoding
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"unsafe"
+)
+
+// #cgo pkg-config: libtiff-4
+// #include <stdio.h>
+// #include <stdlib.h>
+// #include <string.h>
+// #include <tiffio.h>
+//
+// #define GO_TIFF_MESSAGE_LENGTH 256
+//
+// // Defined in tiff_libtiff.go
+// TIFF *goTiffClientOpen(const char *mode, thandle_t client, char *message, TIFFReadWriteProc read,
+//                        TIFFReadWriteProc write, TIFFSeekProc seek, TIFFSizeProc size,
+//                        TIFFMapFileProc map, TIFFUnmapFileProc unmap);
+// void goTiffClientClose(TIFF *tif);
+//
+// // goTiffWriter collects the output of a TIFF handle in memory
+// typedef struct {
+//     char message[GO_TIFF_MESSAGE_LENGTH];
+//     uint8_t *data;
+//     toff_t size;
+//     toff_t capacity;
+//     toff_t offset;
+// } goTiffWriter;
+//
+// // libtiff reads back the directories it wrote to link the next page
+// static tmsize_t goTiffWriterRead(thandle_t h, void *buf, tmsize_t n) {
+//     goTiffWriter *w = (goTiffWriter *)h;
+//     if (w->offset >= w->size) {
+//         return 0;
+//     }
+//     if ((toff_t)n > w->size - w->offset) {
+//         n = (tmsize_t)(w->size - w->offset);
+//     }
+//     memcpy(buf, w->data + w->offset, n);
+//     w->offset += n;
+//     return n;
+// }
+//
+// // libtiff seeks back to patch offsets, so writes can land anywhere in the buffer
+// static tmsize_t goTiffWrite(thandle_t h, void *buf, tmsize_t n) {
+//     goTiffWriter *w = (goTiffWriter *)h;
+//     toff_t end = w->offset + n;
+//     uint8_t *grown;
+//
+//     if (end > w->capacity) {
+//         toff_t capacity = w->capacity * 2 > end ? w->capacity * 2 : end;
+//         grown = realloc(w->data, capacity);
+//         if (grown == NULL) {
+//             return -1;
+//         }
+//         w->data = grown;
+//         w->capacity = capacity;
+//     }
+//     if (w->offset > w->size) {
+//         memset(w->data + w->size, 0, w->offset - w->size);
+//     }
+//     memcpy(w->data + w->offset, buf, n);
+//     w->offset = end;
+//     if (end > w->size) {
+//         w->size = end;
+//     }
+//     return n;
+// }
+//
+// static toff_t goTiffWriterSeek(thandle_t h, toff_t off, int whence) {
+//     goTiffWriter *w = (goTiffWriter *)h;
+//     switch (whence) {
+//     case SEEK_CUR:
+//         off += w->offset;
+//         break;
+//     case SEEK_END:
+//         off += w->size;
+//         break;
+//     }
+//     w->offset = off;
+//     return off;
+// }
+//
+// static toff_t goTiffWriterSize(thandle_t h) {
+//     return ((goTiffWriter *)h)->size;
+// }
+//
+// static int goTiffNoMap(thandle_t h, void **base, toff_t *size) {
+//     return 0;
+// }
+//
+// static void goTiffNoUnmap(thandle_t h, void *base, toff_t size) {}
+//
+// TIFF *goTiffCreate(goTiffWriter *w) {
+//     return goTiffClientOpen("w", (thandle_t)w, w->message, goTiffWriterRead, goTiffWrite, goTiffWriterSeek,
+//                             goTiffWriterSize, goTiffNoMap, goTiffNoUnmap);
+// }
+//
+// typedef struct {
+//     int bits;
+//     int channels;
+//     int compression;
+//     double dpi;
+//     int page, pages;
+// } goTiffPageOptions;
+//
+// // goTiffMetadata holds the tags written to every page, NULL and zero values are left out
+// typedef struct {
+//     uint8_t *icc;
+//     uint32_t iccSize;
+//     int orientation;
+//     char *description, *make, *model, *software, *dateTime, *artist, *copyright;
+// } goTiffMetadata;
+//
+// static void goTiffSetText(TIFF *tif, ttag_t tag, const char *value) {
+//     if (value != NULL) {
+//         TIFFSetField(tif, tag, value);
+//     }
+// }
+//
+// // goTiffWritePage writes a page of packed 1-bit rows, 1 is black, or of 8-bit gray or RGB pixels
+// int goTiffWritePage(TIFF *tif, const uint8_t *pix, int width, int height, int stride, goTiffPageOptions *opts,
+//                     goTiffMetadata *meta) {
+//     size_t rowSize = opts->bits == 1 ? (width + 7) / 8 : (size_t)width * opts->channels;
+//     uint8_t *row;
+//     int y, res = 0;
+//
+//     TIFFSetField(tif, TIFFTAG_IMAGEWIDTH, width);
+//     TIFFSetField(tif, TIFFTAG_IMAGELENGTH, height);
+//     TIFFSetField(tif, TIFFTAG_BITSPERSAMPLE, opts->bits);
+//     TIFFSetField(tif, TIFFTAG_SAMPLESPERPIXEL, opts->channels);
+//     TIFFSetField(tif, TIFFTAG_PLANARCONFIG, PLANARCONFIG_CONTIG);
+//     TIFFSetField(tif, TIFFTAG_PHOTOMETRIC, opts->bits == 1 ? PHOTOMETRIC_MINISWHITE :
+//                  opts->channels == 1 ? PHOTOMETRIC_MINISBLACK : PHOTOMETRIC_RGB);
+//     TIFFSetField(tif, TIFFTAG_COMPRESSION, opts->compression);
+//     if (opts->bits == 8 && (opts->compression == COMPRESSION_LZW || opts->compression == COMPRESSION_ADOBE_DEFLATE)) {
+//         TIFFSetField(tif, TIFFTAG_PREDICTOR, PREDICTOR_HORIZONTAL);
+//     }
+//     // Fax readers expect a G4 page in a single strip
+//     TIFFSetField(tif, TIFFTAG_ROWSPERSTRIP, opts->compression == COMPRESSION_CCITTFAX4 ? height :
+//                  TIFFDefaultStripSize(tif, 0));
+//     if (opts->dpi > 0) {
+//         TIFFSetField(tif, TIFFTAG_XRESOLUTION, opts->dpi);
+//         TIFFSetField(tif, TIFFTAG_YRESOLUTION, opts->dpi);
+//         TIFFSetField(tif, TIFFTAG_RESOLUTIONUNIT, RESUNIT_INCH);
+//     }
+//     if (meta->iccSize > 0) {
+//         TIFFSetField(tif, TIFFTAG_ICCPROFILE, meta->iccSize, meta->icc);
+//     }
+//     if (meta->orientation > 0) {
+//         TIFFSetField(tif, TIFFTAG_ORIENTATION, meta->orientation);
+//     }
+//     goTiffSetText(tif, TIFFTAG_IMAGEDESCRIPTION, meta->description);
+//     goTiffSetText(tif, TIFFTAG_MAKE, meta->make);
+//     goTiffSetText(tif, TIFFTAG_MODEL, meta->model);
+//     goTiffSetText(tif, TIFFTAG_SOFTWARE, meta->software);
+//     goTiffSetText(tif, TIFFTAG_DATETIME, meta->dateTime);
+//     goTiffSetText(tif, TIFFTAG_ARTIST, meta->artist);
+//     goTiffSetText(tif, TIFFTAG_COPYRIGHT, meta->copyright);
+//     if (opts->pages > 1) {
+//         TIFFSetField(tif, TIFFTAG_SUBFILETYPE, FILETYPE_PAGE);
+//         TIFFSetField(tif, TIFFTAG_PAGENUMBER, opts->page, opts->pages);
+//     }
+//
+//     // The predictor and the CCITT encoder work in place, keep the pixels of the caller intact
+//     row = malloc(rowSize);
+//     if (row == NULL) {
+//         return -1;
+//     }
+//     for (y = 0; y < height && res == 0; y++) {
+//         memcpy(row, pix + (size_t)y * stride, rowSize);
+//         if (TIFFWriteScanline(tif, row, y, 0) < 0) {
+//             res = -1;
+//         }
+//     }
+//     free(row);
+//     if (res != 0 || !TIFFWriteDirectory(tif)) {
+//         return -1;
+//     }
+//     return 0;
+// }
+import "C"
+
+// EncodeTiff will encode images to the pages of a TIFF file using libtiff.
+// Bilevel pages are written as 1-bit black and white, gray pages as 8-bit gray and any other
+// image as 8-bit RGB, see TiffEncodeOptions for the compression.
+func EncodeTiff(buf *bytes.Buffer, imgs []image.Image, opts TiffEncodeOptions) ([]byte, error) {
+	if len(imgs) == 0 {
+		return nil, ErrEmptyInput
+	}
+	for _, img := range imgs {
+		if img.Bounds().Empty() {
+			return nil, ErrEmptyInput
+		}
+	}
+	opts = opts.withDefaults()
+
+	w := (*C.goTiffWriter)(C.calloc(1, C.sizeof_goTiffWriter))
+	defer func() {
+		C.free(unsafe.Pointer(w.data))
+		C.free(unsafe.Pointer(w))
+	}()
+	tif := C.goTiffCreate(w)
+	if tif == nil {
+		return nil, fmt.Errorf("could not create tiff: %v", C.GoString(&w.message[0]))
+	}
+
+	meta := newTiffMetadata(opts.Metadata)
+	defer freeTiffMetadata(&meta)
+	dpi := opts.DPI
+	if dpi == 0 {
+		dpi = opts.Metadata.DPI
+	}
+
+	for i, img := range imgs {
+		pix, stride, popts := tiffPagePixels(img, opts)
+		popts.dpi = C.double(dpi)
+		popts.page = C.int(i)
+		popts.pages = C.int(len(imgs))
+		res := C.goTiffWritePage(
+			tif, (*C.uint8_t)(unsafe.Pointer(&pix[0])),
+			C.int(img.Bounds().Dx()), C.int(img.Bounds().Dy()), C.int(stride), &popts, &meta,
+		)
+		if res != 0 {
+			C.goTiffClientClose(tif)
+			return nil, fmt.Errorf("could not encode tiff page %d: %v", i, C.GoString(&w.message[0]))
+		}
+	}
+	C.goTiffClientClose(tif)
+	if w.message[0] != 0 {
+		return nil, fmt.Errorf("could not encode tiff: %v", C.GoString(&w.message[0]))
+	}
+
+	buf.Reset()
+	buf.Write(C.GoBytes(unsafe.Pointer(w.data), C.int(w.size)))
+	return buf.Bytes(), nil
+}
+
+// newTiffMetadata copies the metadata to C memory, which freeTiffMetadata releases
+func newTiffMetadata(meta EncodeMetadata) C.goTiffMetadata {
+	var cmeta C.goTiffMetadata
+	if len(meta.ICCProfile) > 0 {
+		cmeta.icc = (*C.uint8_t)(C.CBytes(meta.ICCProfile))
+		cmeta.iccSize = C.uint32_t(len(meta.ICCProfile))
+	}
+	if meta.Exif == nil {
+		return cmeta
+	}
+	text := func(s string) *C.char {
+		if s == "" {
+			return nil
+		}
+		return C.CString(s)
+	}
+	tags := meta.Exif
+	cmeta.orientation = C.int(tags.Orientation)
+	cmeta.description = text(tags.ImageDescription)
+	cmeta.make = text(tags.Make)
+	cmeta.model = text(tags.Model)
+	cmeta.software = text(tags.Software)
+	if !tags.DateTime.IsZero() {
+		cmeta.dateTime = text(tags.DateTime.Format(exifDateLayout))
+	}
+	cmeta.artist = text(tags.Artist)
+	cmeta.copyright = text(tags.Copyright)
+	return cmeta
+}
+
+func freeTiffMetadata(meta *C.goTiffMetadata) {
+	C.free(unsafe.Pointer(meta.icc))
+	for _, s := range []*C.char{meta.description, meta.make, meta.model, meta.software, meta.dateTime, meta.artist, meta.copyright} {
+		C.free(unsafe.Pointer(s))
+	}
+}
+
+// tiffPagePixels prepares the pixels of a page for libtiff, converting the image if required
+func tiffPagePixels(img image.Image, opts TiffEncodeOptions) ([]uint8, int, C.goTiffPageOptions) {
+	popts := C.goTiffPageOptions{bits: 8, channels: 1}
+	switch opts.Compression {
+	case TiffCompressionNone:
+		popts.compression = C.COMPRESSION_NONE
+	case TiffCompressionDeflate:
+		popts.compression = C.COMPRESSION_ADOBE_DEFLATE
+	case TiffCompressionG4:
+		popts.compression = C.COMPRESSION_CCITTFAX4
+	case TiffCompressionLZW:
+		popts.compression = C.COMPRESSION_LZW
+	default:
+		popts.compression = C.COMPRESSION_LZW
+		if isBilevel(img) {
+			popts.compression = C.COMPRESSION_CCITTFAX4
+		}
+	}
+
+	if popts.compression == C.COMPRESSION_CCITTFAX4 {
+		popts.bits = 1
+		return packBilevel(toGray(img), opts.Threshold), (img.Bounds().Dx() + 7) / 8, popts
+	}
+	if isGray(img) {
+		gray := toGray(img)
+		return gray.Pix[gray.PixOffset(gray.Rect.Min.X, gray.Rect.Min.Y):], gray.Stride, popts
+	}
+	popts.channels = 3
+	rgb := toRGB(img)
+	return rgb.Pix[rgb.PixOffset(rgb.Rect.Min.X, rgb.Rect.Min.Y):], rgb.Stride, popts
+}
+
+// packBilevel packs gray pixels to rows of 1-bit pixels, 1 is black
+func packBilevel(img *image.Gray, threshold uint8) []uint8 {
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	stride := (width + 7) / 8
+	pix := make([]uint8, stride*height)
+	for y := 0; y < height; y++ {
+		row := img.Pix[img.PixOffset(img.Rect.Min.X, img.Rect.Min.Y+y):]
+		for x := 0; x < width; x++ {
+			if row[x] < threshold {
+				pix[y*stride+x/8] |= 0x80 >> (x % 8)
+			}
+		}
+	}
+	return pix
+}
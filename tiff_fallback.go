@@ -0,0 +1,138 @@
+//go:build !libtiff
+// +build !libtiff
+
+package imagecoding
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"image"
+)
+
+// tiffPageCount returns the number of pages of a TIFF file
+func tiffPageCount(data []byte) (int, error) {
+	ifds, err := tiffIFDs(data)
+	return len(ifds), err
+}
+
+// transformTiffPage decodes a page of a TIFF file with the pure Go decoder
+func transformTiffPage(data []byte, index int, opts TransformOptions) (TransformResult, error) {
+	return transformTiffPageGo(data, index, opts)
+}
+
+// EncodeTiff will encode images to the pages of a TIFF file with a pure Go writer.
+// Gray pages are written as 8-bit gray and any other image as 8-bit RGB, uncompressed or with Deflate.
+// CCITT G4 and LZW need the libtiff build tag, without it they return ErrTiffEncodeUnsupported.
+func EncodeTiff(buf *bytes.Buffer, imgs []image.Image, opts TiffEncodeOptions) ([]byte, error) {
+	if len(imgs) == 0 {
+		return nil, ErrEmptyInput
+	}
+	for _, img := range imgs {
+		if img.Bounds().Empty() {
+			return nil, ErrEmptyInput
+		}
+	}
+	deflate := false
+	switch opts.Compression {
+	case TiffCompressionAuto, TiffCompressionDeflate:
+		deflate = true
+	case TiffCompressionG4, TiffCompressionLZW:
+		return nil, ErrTiffEncodeUnsupported
+	}
+	meta := opts.Metadata
+	if opts.DPI != 0 {
+		meta.DPI = opts.DPI
+	}
+
+	out := []byte("MM\x00\x2a\x00\x00\x00\x00")
+	// next is the position of the offset to the next directory
+	next := 4
+	for i, img := range imgs {
+		strip, entries := tiffPageStrip(img, deflate)
+		entries = append(entries, meta.exifEntries(true)...)
+		if len(meta.ICCProfile) > 0 {
+			entries = append(entries, exifEntry{34675, 7, uint32(len(meta.ICCProfile)), meta.ICCProfile})
+		}
+		if len(imgs) > 1 {
+			pageNumber := binary.BigEndian.AppendUint16(binary.BigEndian.AppendUint16(nil, uint16(i)), uint16(len(imgs)))
+			entries = append(entries, tiffLong(254, 2), exifEntry{297, 3, 2, pageNumber})
+		}
+
+		entries = append(entries, tiffLong(273, uint32(len(out))), tiffLong(279, uint32(len(strip))))
+		out = append(out, strip...)
+		// Directories start on a word boundary
+		if len(out)%2 == 1 {
+			out = append(out, 0)
+		}
+		binary.BigEndian.PutUint32(out[next:], uint32(len(out)))
+		next = len(out) + 2 + 12*len(entries)
+		out = append(out, tiffDirectory(entries, uint32(len(out)))...)
+	}
+	return setEncoded(buf, out), nil
+}
+
+// tiffLong returns a directory entry with a single 32-bit value
+func tiffLong(tag uint16, v uint32) exifEntry {
+	return exifEntry{tag, 4, 1, binary.BigEndian.AppendUint32(nil, v)}
+}
+
+// tiffPageStrip returns the pixels of a page as a single strip and the tags that describe them
+func tiffPageStrip(img image.Image, deflate bool) ([]byte, []exifEntry) {
+	var pix []byte
+	var stride, channels int
+	if isGray(img) {
+		gray := toGray(img)
+		pix, stride, channels = gray.Pix[gray.PixOffset(gray.Rect.Min.X, gray.Rect.Min.Y):], gray.Stride, 1
+	} else {
+		rgb := toRGB(img)
+		pix, stride, channels = rgb.Pix[rgb.PixOffset(rgb.Rect.Min.X, rgb.Rect.Min.Y):], rgb.Stride, 3
+	}
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+	rowSize := width * channels
+
+	strip := make([]byte, 0, rowSize*height)
+	for y := 0; y < height; y++ {
+		row := pix[y*stride : y*stride+rowSize]
+		if deflate {
+			// The horizontal predictor stores the difference to the pixel on the left
+			strip = append(strip, row[:channels]...)
+			for x := channels; x < rowSize; x++ {
+				strip = append(strip, row[x]-row[x-channels])
+			}
+			continue
+		}
+		strip = append(strip, row...)
+	}
+
+	bits := make([]byte, 0, 2*channels)
+	for c := 0; c < channels; c++ {
+		bits = binary.BigEndian.AppendUint16(bits, 8)
+	}
+	photometric, compression := uint16(1), uint16(1)
+	if channels == 3 {
+		photometric = 2
+	}
+	short := func(tag, v uint16) exifEntry {
+		return exifEntry{tag, 3, 1, binary.BigEndian.AppendUint16(nil, v)}
+	}
+	entries := []exifEntry{
+		tiffLong(256, uint32(width)),
+		tiffLong(257, uint32(height)),
+		{258, 3, uint32(channels), bits},
+		short(262, photometric),
+		short(277, uint16(channels)),
+		tiffLong(278, uint32(height)),
+		short(284, 1),
+	}
+	if deflate {
+		var z bytes.Buffer
+		w := zlib.NewWriter(&z)
+		w.Write(strip)
+		w.Close()
+		strip = z.Bytes()
+		compression = 8
+		entries = append(entries, short(317, 2))
+	}
+	return strip, append(entries, short(259, compression))
+}
@@ -0,0 +1,41 @@
+//go:build !libtiff
+// +build !libtiff
+
+package imagecoding
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTiffIFDLoop(t *testing.T) {
+	data := makeMultiPageTiff([]*image.Gray{makeMarkedPage(2, 2)}, []Orientation{TopLeft})
+	// Point the next directory offset back at the first directory
+	binary.LittleEndian.PutUint32(data[8+2+12*10:], 8)
+	_, err := PageCount(data)
+	assert.Error(t, err)
+}
+
+func TestEncodeTiffUnsupported(t *testing.T) {
+	pages := []image.Image{makeMarkedPage(16, 8)}
+	var buf bytes.Buffer
+	for _, compression := range []TiffCompression{TiffCompressionG4, TiffCompressionLZW} {
+		_, err := EncodeTiff(&buf, pages, TiffEncodeOptions{Compression: compression})
+		assert.Equal(t, ErrTiffEncodeUnsupported, err)
+	}
+
+	// Without libtiff bilevel pages are written as 8-bit gray with Deflate
+	data, err := EncodeTiff(&buf, pages, TiffEncodeOptions{})
+	if assert.NoError(t, err) {
+		ifds, err := tiffIFDs(data)
+		if assert.NoError(t, err) {
+			compression, _ := tiffTagShort(data, ifds[0], 259)
+			assert.Equal(t, uint16(8), compression)
+		}
+		assertTiffPages(t, data, pages)
+	}
+}
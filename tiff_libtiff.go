@@ -0,0 +1,405 @@
+//go:build libtiff
+// +build libtiff
+
+package imagecoding
+
+import (
+	"fmt"
+	"image"
+	"unsafe"
+)
+
+// #cgo pkg-config: libtiff-4
+// #include <pthread.h>
+// #include <stdio.h>
+// #include <stdlib.h>
+// #include <string.h>
+// #include <tiffio.h>
+//
+// #define GO_TIFF_MESSAGE_LENGTH 256
+//
+// // Keep the first error of a handle, the later ones are consequences of it
+// static void goTiffMessage(char *message, const char *fmt, va_list ap) {
+//     if (message[0] == 0) {
+//         vsnprintf(message, GO_TIFF_MESSAGE_LENGTH, fmt, ap);
+//     }
+// }
+//
+// static int goTiffClose(thandle_t h) {
+//     return 0;
+// }
+//
+// #if TIFFLIB_VERSION >= 20221213
+// // libtiff 4.5 and newer report to the handlers in the open options of a handle
+// static int goTiffError(TIFF *tif, void *message, const char *module, const char *fmt, va_list ap) {
+//     goTiffMessage((char *)message, fmt, ap);
+//     return 1;
+// }
+//
+// // Warnings are about files libtiff reads anyway, keep them off stderr
+// static int goTiffWarning(TIFF *tif, void *data, const char *module, const char *fmt, va_list ap) {
+//     return 1;
+// }
+//
+// // goTiffClientOpen opens a handle that writes its first error to message, which holds
+// // GO_TIFF_MESSAGE_LENGTH bytes. goTiffClientClose closes it.
+// TIFF *goTiffClientOpen(const char *mode, thandle_t client, char *message, TIFFReadWriteProc read,
+//                        TIFFReadWriteProc write, TIFFSeekProc seek, TIFFSizeProc size,
+//                        TIFFMapFileProc map, TIFFUnmapFileProc unmap) {
+//     TIFFOpenOptions *opts = TIFFOpenOptionsAlloc();
+//     TIFF *tif;
+//
+//     if (opts == NULL) {
+//         strcpy(message, "out of memory");
+//         return NULL;
+//     }
+//     TIFFOpenOptionsSetErrorHandlerExtR(opts, goTiffError, message);
+//     TIFFOpenOptionsSetWarningHandlerExtR(opts, goTiffWarning, NULL);
+//     tif = TIFFClientOpenExt("memory", mode, client, read, write, seek, goTiffClose, size, map, unmap, opts);
+//     TIFFOpenOptionsFree(opts);
+//     return tif;
+// }
+//
+// void goTiffClientClose(TIFF *tif) {
+//     TIFFClose(tif);
+// }
+// #else
+// // Older versions only have a process wide handler. It writes the errors of the handles opened here
+// // to their message and passes the others on, their client data is not necessarily a pointer.
+// // The default handler still prints to stderr, replacing it would silence every other libtiff user.
+// typedef struct goTiffHandle {
+//     thandle_t client;
+//     char *message;
+//     struct goTiffHandle *next;
+// } goTiffHandle;
+//
+// static pthread_mutex_t goTiffLock = PTHREAD_MUTEX_INITIALIZER;
+// static pthread_once_t goTiffOnce = PTHREAD_ONCE_INIT;
+// static goTiffHandle *goTiffHandles;
+// static TIFFErrorHandlerExt goTiffNextError;
+//
+// static void goTiffError(thandle_t client, const char *module, const char *fmt, va_list ap) {
+//     goTiffHandle *h;
+//
+//     pthread_mutex_lock(&goTiffLock);
+//     for (h = goTiffHandles; h != NULL && h->client != client; h = h->next) {
+//     }
+//     if (h != NULL) {
+//         goTiffMessage(h->message, fmt, ap);
+//     }
+//     pthread_mutex_unlock(&goTiffLock);
+//     if (h == NULL && goTiffNextError != NULL) {
+//         goTiffNextError(client, module, fmt, ap);
+//     }
+// }
+//
+// static void goTiffInstall(void) {
+//     goTiffNextError = TIFFSetErrorHandlerExt(goTiffError);
+// }
+//
+// static void goTiffRelease(thandle_t client) {
+//     goTiffHandle **p, *h;
+//
+//     pthread_mutex_lock(&goTiffLock);
+//     for (p = &goTiffHandles; *p != NULL; p = &(*p)->next) {
+//         if ((*p)->client == client) {
+//             h = *p;
+//             *p = h->next;
+//             free(h);
+//             break;
+//         }
+//     }
+//     pthread_mutex_unlock(&goTiffLock);
+// }
+//
+// TIFF *goTiffClientOpen(const char *mode, thandle_t client, char *message, TIFFReadWriteProc read,
+//                        TIFFReadWriteProc write, TIFFSeekProc seek, TIFFSizeProc size,
+//                        TIFFMapFileProc map, TIFFUnmapFileProc unmap) {
+//     goTiffHandle *h = malloc(sizeof(goTiffHandle));
+//     TIFF *tif;
+//
+//     if (h == NULL) {
+//         strcpy(message, "out of memory");
+//         return NULL;
+//     }
+//     pthread_once(&goTiffOnce, goTiffInstall);
+//     h->client = client;
+//     h->message = message;
+//     pthread_mutex_lock(&goTiffLock);
+//     h->next = goTiffHandles;
+//     goTiffHandles = h;
+//     pthread_mutex_unlock(&goTiffLock);
+//
+//     tif = TIFFClientOpen("memory", mode, client, read, write, seek, goTiffClose, size, map, unmap);
+//     if (tif == NULL) {
+//         goTiffRelease(client);
+//     }
+//     return tif;
+// }
+//
+// void goTiffClientClose(TIFF *tif) {
+//     thandle_t client = TIFFClientdata(tif);
+//     TIFFClose(tif);
+//     goTiffRelease(client);
+// }
+// #endif
+//
+// // goTiffClient reads a TIFF file from memory
+// typedef struct {
+//     char message[GO_TIFF_MESSAGE_LENGTH];
+//     const uint8_t *data;
+//     toff_t size;
+//     toff_t offset;
+// } goTiffClient;
+//
+// static tmsize_t goTiffRead(thandle_t h, void *buf, tmsize_t n) {
+//     goTiffClient *c = (goTiffClient *)h;
+//     if (c->offset >= c->size) {
+//         return 0;
+//     }
+//     if ((toff_t)n > c->size - c->offset) {
+//         n = (tmsize_t)(c->size - c->offset);
+//     }
+//     memcpy(buf, c->data + c->offset, n);
+//     c->offset += n;
+//     return n;
+// }
+//
+// static tmsize_t goTiffNoWrite(thandle_t h, void *buf, tmsize_t n) {
+//     return 0;
+// }
+//
+// static toff_t goTiffSeek(thandle_t h, toff_t off, int whence) {
+//     goTiffClient *c = (goTiffClient *)h;
+//     switch (whence) {
+//     case SEEK_CUR:
+//         off += c->offset;
+//         break;
+//     case SEEK_END:
+//         off += c->size;
+//         break;
+//     }
+//     c->offset = off;
+//     return off;
+// }
+//
+// static toff_t goTiffSize(thandle_t h) {
+//     return ((goTiffClient *)h)->size;
+// }
+//
+// // The data is in memory already, opening with mode "r" makes libtiff read the strips in place
+// static int goTiffMap(thandle_t h, void **base, toff_t *size) {
+//     goTiffClient *c = (goTiffClient *)h;
+//     *base = (void *)c->data;
+//     *size = c->size;
+//     return 1;
+// }
+//
+// static void goTiffUnmap(thandle_t h, void *base, toff_t size) {}
+//
+// static TIFF *goTiffOpen(goTiffClient *c, const uint8_t *data, size_t size) {
+//     memset(c, 0, sizeof(*c));
+//     c->data = data;
+//     c->size = size;
+//     return goTiffClientOpen("r", (thandle_t)c, c->message, goTiffRead, goTiffNoWrite, goTiffSeek, goTiffSize,
+//                             goTiffMap, goTiffUnmap);
+// }
+//
+// // goTiffPageCount counts the directories, it fails on a directory loop
+// int goTiffPageCount(const uint8_t *data, size_t size, int *count, char *message) {
+//     goTiffClient c;
+//     TIFF *tif = goTiffOpen(&c, data, size);
+//     if (tif != NULL) {
+//         *count = TIFFNumberOfDirectories(tif);
+//         goTiffClientClose(tif);
+//     }
+//     strcpy(message, c.message);
+//     return tif == NULL || c.message[0] != 0 ? -1 : 0;
+// }
+//
+// typedef struct {
+//     uint32_t width, height;
+//     uint16_t orientation;
+//     uint16_t samples;
+//     uint16_t photometric;
+// } goTiffPage;
+//
+// static int goTiffReadPage(TIFF *tif, int index, goTiffPage *page) {
+//     if (!TIFFSetDirectory(tif, index)) {
+//         return -1;
+//     }
+//     TIFFGetField(tif, TIFFTAG_IMAGEWIDTH, &page->width);
+//     TIFFGetField(tif, TIFFTAG_IMAGELENGTH, &page->height);
+//     TIFFGetFieldDefaulted(tif, TIFFTAG_ORIENTATION, &page->orientation);
+//     TIFFGetFieldDefaulted(tif, TIFFTAG_SAMPLESPERPIXEL, &page->samples);
+//     if (!TIFFGetField(tif, TIFFTAG_PHOTOMETRIC, &page->photometric)) {
+//         page->photometric = page->samples < 3 ? PHOTOMETRIC_MINISBLACK : PHOTOMETRIC_RGB;
+//     }
+//     return 0;
+// }
+//
+// // goTiffInfo reads the directory of a page
+// int goTiffInfo(const uint8_t *data, size_t size, int index, goTiffPage *page, char *message) {
+//     goTiffClient c;
+//     TIFF *tif = goTiffOpen(&c, data, size);
+//     int res = -1;
+//     if (tif != NULL) {
+//         res = goTiffReadPage(tif, index, page);
+//         goTiffClientClose(tif);
+//     }
+//     strcpy(message, c.message);
+//     return res;
+// }
+//
+// // goTiffDecode decodes a page in its stored orientation to packed gray or RGB pixels.
+// // libtiff converts any photometric, compression and bit depth. out must be freed by the caller.
+// int goTiffDecode(const uint8_t *data, size_t size, int index, int gray, goTiffPage *page,
+//                  uint8_t **out, char *message) {
+//     goTiffClient c;
+//     TIFF *tif = goTiffOpen(&c, data, size);
+//     uint32_t *raster = NULL, v;
+//     uint8_t *px;
+//     size_t i, n;
+//     int res = -1;
+//
+//     if (tif == NULL || goTiffReadPage(tif, index, page) != 0) {
+//         goto done;
+//     }
+//     // libtiff would only flip some orientations, the caller applies all of them
+//     TIFFSetField(tif, TIFFTAG_ORIENTATION, ORIENTATION_TOPLEFT);
+//     n = (size_t)page->width * page->height;
+//     raster = malloc(n * sizeof(uint32_t));
+//     *out = malloc(n * (gray ? 1 : 3));
+//     if (raster == NULL || *out == NULL) {
+//         strcpy(c.message, "out of memory");
+//         goto done;
+//     }
+//     if (!TIFFReadRGBAImageOriented(tif, page->width, page->height, raster, ORIENTATION_TOPLEFT, 0)) {
+//         goto done;
+//     }
+//
+//     // The raster holds premultiplied ABGR, dropping alpha composites on black like toRGB
+//     px = *out;
+//     for (i = 0; i < n; i++) {
+//         v = raster[i];
+//         if (gray) {
+//             *px++ = (19595 * (v & 0xff) + 38470 * ((v >> 8) & 0xff) + 7471 * ((v >> 16) & 0xff) + (1 << 15)) >> 16;
+//         } else {
+//             *px++ = v & 0xff;
+//             *px++ = (v >> 8) & 0xff;
+//             *px++ = (v >> 16) & 0xff;
+//         }
+//     }
+//     res = 0;
+//
+// done:
+//     free(raster);
+//     if (tif != NULL) {
+//         goTiffClientClose(tif);
+//     }
+//     strcpy(message, c.message);
+//     return res;
+// }
+import "C"
+
+// libtiffError is an error of libtiff, the pure Go decoder may still read the file
+type libtiffError struct {
+	message string
+}
+
+func (e libtiffError) Error() string {
+	return e.message
+}
+
+// tiffPageCount returns the number of pages of a TIFF file
+func tiffPageCount(data []byte) (int, error) {
+	count, err := libtiffPageCount(data)
+	if err != nil {
+		if ifds, goErr := tiffIFDs(data); goErr == nil {
+			return len(ifds), nil
+		}
+	}
+	return count, err
+}
+
+// libtiffPageCount counts the directories of a TIFF file with libtiff
+func libtiffPageCount(data []byte) (int, error) {
+	var count C.int
+	var message [C.GO_TIFF_MESSAGE_LENGTH]C.char
+	res := C.goTiffPageCount((*C.uint8_t)(unsafe.Pointer(&data[0])), C.size_t(len(data)), &count, &message[0])
+	if res != 0 {
+		return 0, libtiffError{fmt.Sprintf("could not read tiff: %v", C.GoString(&message[0]))}
+	}
+	return int(count), nil
+}
+
+// transformTiffPage decodes a page of a TIFF file with libtiff, each page has its own orientation.
+// libtiff reads the compressions and layouts of real scanners that the pure Go decoder does not,
+// like old-style JPEG, CCITT G3/G4, tiles and subsampled YCbCr. Files libtiff rejects are retried
+// with the pure Go decoder, which is more lenient with some malformed directories.
+func transformTiffPage(data []byte, index int, opts TransformOptions) (TransformResult, error) {
+	res, err := libtiffTransformPage(data, index, opts)
+	if _, ok := err.(libtiffError); ok {
+		if goRes, goErr := transformTiffPageGo(data, index, opts); goErr == nil {
+			return goRes, nil
+		}
+	}
+	return res, err
+}
+
+// libtiffTransformPage decodes a page of a TIFF file with libtiff
+func libtiffTransformPage(data []byte, index int, opts TransformOptions) (TransformResult, error) {
+	count, err := libtiffPageCount(data)
+	if err != nil {
+		return TransformResult{}, err
+	}
+	if index < 0 || index >= count {
+		return TransformResult{}, ErrPageOutOfRange
+	}
+
+	// Check the limits before decoding the full image
+	var page C.goTiffPage
+	var message [C.GO_TIFF_MESSAGE_LENGTH]C.char
+	if C.goTiffInfo((*C.uint8_t)(unsafe.Pointer(&data[0])), C.size_t(len(data)), C.int(index), &page, &message[0]) != 0 {
+		return TransformResult{}, libtiffError{fmt.Sprintf("could not read tiff page %d: %v", index, C.GoString(&message[0]))}
+	}
+	if err = opts.Limits.check(int(page.width), int(page.height)); err != nil {
+		return TransformResult{}, err
+	}
+
+	var profile []byte
+	if opts.ConvertToSRGB {
+		profile = tiffICCProfile(data, index)
+	}
+
+	// Decode gray sources to gray, even when RGB output is requested.
+	// Colors to convert to sRGB are kept until after the conversion.
+	graySource := page.samples < 3 && (page.photometric == C.PHOTOMETRIC_MINISBLACK || page.photometric == C.PHOTOMETRIC_MINISWHITE)
+	grayscale := graySource || (opts.ColorMode == ColorModeGray && iccColorSpace(profile) != "RGB ")
+	var out *C.uint8_t
+	res := C.goTiffDecode(
+		(*C.uint8_t)(unsafe.Pointer(&data[0])), C.size_t(len(data)), C.int(index), cBool(grayscale),
+		&page, &out, &message[0],
+	)
+	if out != nil {
+		defer C.free(unsafe.Pointer(out))
+	}
+	if res != 0 {
+		return TransformResult{}, libtiffError{fmt.Sprintf("could not decode tiff page %d: %v", index, C.GoString(&message[0]))}
+	}
+
+	width, height := int(page.width), int(page.height)
+	rect := image.Rect(0, 0, width, height)
+	var img image.Image
+	if grayscale {
+		img = &image.Gray{Pix: C.GoBytes(unsafe.Pointer(out), C.int(width*height)), Stride: width, Rect: rect}
+	} else {
+		img = &RGBImage{Pix: C.GoBytes(unsafe.Pointer(out), C.int(width*height*3)), Stride: width * 3, Rect: rect}
+	}
+
+	orient := TopLeft
+	if opts.Orientation == OrientationAuto && page.orientation >= C.uint16_t(TopLeft) && page.orientation <= C.uint16_t(LeftBottom) {
+		orient = Orientation(page.orientation)
+	}
+	return finishTransform(FixOrientation(img, orient), Tiff, orient, profile, opts), nil
+}
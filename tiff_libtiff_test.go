@@ -0,0 +1,146 @@
+//go:build libtiff
+// +build libtiff
+
+package imagecoding
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// makePlanarTiff writes an RGB image with a separate strip for each color plane,
+// which the pure Go decoder does not support
+func makePlanarTiff(w, h int, r, g, b uint8) []byte {
+	entries := [][3]uint32{
+		{256, 3, uint32(w)}, // ImageWidth
+		{257, 3, uint32(h)}, // ImageLength
+		{258, 3, 8},         // BitsPerSample
+		{259, 3, 1},         // Compression
+		{262, 3, 2},         // PhotometricInterpretation
+		{273, 4, 0},         // StripOffsets, filled in below
+		{277, 3, 3},         // SamplesPerPixel
+		{278, 3, uint32(h)}, // RowsPerStrip
+		{279, 4, 0},         // StripByteCounts, filled in below
+		{284, 3, 2},         // PlanarConfiguration
+	}
+	ifdSize := 2 + 12*len(entries) + 4
+	offsets := 8 + ifdSize
+	counts := offsets + 3*4
+	pixels := counts + 3*4
+	entries[5] = [3]uint32{273, 4, uint32(offsets)}
+	entries[8] = [3]uint32{279, 4, uint32(counts)}
+
+	var buf bytes.Buffer
+	buf.WriteString("II*\x00")
+	binary.Write(&buf, binary.LittleEndian, uint32(8))
+	binary.Write(&buf, binary.LittleEndian, uint16(len(entries)))
+	for _, e := range entries {
+		count := uint32(1)
+		if e[0] == 273 || e[0] == 279 {
+			count = 3
+		}
+		binary.Write(&buf, binary.LittleEndian, uint16(e[0]))
+		binary.Write(&buf, binary.LittleEndian, uint16(e[1]))
+		binary.Write(&buf, binary.LittleEndian, count)
+		binary.Write(&buf, binary.LittleEndian, e[2])
+	}
+	binary.Write(&buf, binary.LittleEndian, uint32(0))
+	for i := 0; i < 3; i++ {
+		binary.Write(&buf, binary.LittleEndian, uint32(pixels+i*w*h))
+	}
+	for i := 0; i < 3; i++ {
+		binary.Write(&buf, binary.LittleEndian, uint32(w*h))
+	}
+	for _, v := range []uint8{r, g, b} {
+		buf.Write(bytes.Repeat([]byte{v}, w*h))
+	}
+	return buf.Bytes()
+}
+
+func TestTransformTiffPlanar(t *testing.T) {
+	res, err := TransformWithOptions(makePlanarTiff(4, 2, 0xff, 0x80, 0), TransformOptions{})
+	if assert.NoError(t, err) {
+		assert.Equal(t, Tiff, res.Format)
+		rgb, ok := res.Image.(*RGBImage)
+		if assert.True(t, ok) {
+			assert.Equal(t, 4, rgb.Bounds().Dx())
+			assert.Equal(t, []uint8{0xff, 0x80, 0}, rgb.Pix[:3])
+		}
+	}
+}
+
+func TestTransformTiffError(t *testing.T) {
+	data := makePlanarTiff(4, 2, 0, 0, 0)
+	_, err := TransformWithOptions(data[:len(data)-20], TransformOptions{})
+	assert.Error(t, err)
+}
+
+func TestEncodeTiffLibtiff(t *testing.T) {
+	pages := testTiffPages()
+	for _, compression := range []TiffCompression{TiffCompressionAuto, TiffCompressionLZW, TiffCompressionG4} {
+		var buf bytes.Buffer
+		data, err := EncodeTiff(&buf, pages[:1], TiffEncodeOptions{Compression: compression})
+		if assert.NoError(t, err, compression) {
+			assertTiffPages(t, data, pages[:1])
+		}
+	}
+	var buf bytes.Buffer
+	data, err := EncodeTiff(&buf, pages, TiffEncodeOptions{Compression: TiffCompressionLZW, DPI: 200})
+	if assert.NoError(t, err) {
+		assertTiffPages(t, data, pages)
+		resolution, err := ImageResolution(data)
+		assert.NoError(t, err)
+		assert.Equal(t, Resolution{200, 200}, resolution)
+	}
+}
+
+func TestEncodeTiffG4Threshold(t *testing.T) {
+	gray := image.NewGray(image.Rect(0, 0, 16, 1))
+	for x := range gray.Pix {
+		gray.Pix[x] = uint8(x * 16)
+	}
+
+	var buf bytes.Buffer
+	data, err := EncodeTiff(&buf, []image.Image{gray}, TiffEncodeOptions{Compression: TiffCompressionG4, Threshold: 100})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	res, err := TransformPage(data, 0, TransformOptions{ColorMode: ColorModeGray, Scale: noScale})
+	if assert.NoError(t, err) {
+		pix := res.Image.(*image.Gray).Pix
+		for x, p := range pix {
+			if x*16 < 100 {
+				assert.Equal(t, uint8(0), p)
+			} else {
+				assert.Equal(t, uint8(0xff), p)
+			}
+		}
+	}
+}
+
+func TestTiffIFDLoopLibtiff(t *testing.T) {
+	data := makeMultiPageTiff([]*image.Gray{makeMarkedPage(2, 2)}, []Orientation{TopLeft})
+	// Point the next directory offset back at the first directory
+	binary.LittleEndian.PutUint32(data[8+2+12*10:], 8)
+	// libtiff stops at the first directory it has seen before
+	count, err := PageCount(data)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 1, count)
+	}
+	_, err = TransformPage(data, 0, TransformOptions{})
+	assert.NoError(t, err)
+}
+
+func TestTransformTiffGoFallback(t *testing.T) {
+	data := makeMultiPageTiff([]*image.Gray{makeMarkedPage(4, 2)}, []Orientation{TopLeft})
+	// Claim three samples per pixel for a gray strip, libtiff reads past the strip
+	binary.LittleEndian.PutUint32(data[8+2+12*7+8:], 3)
+	res, err := TransformPage(data, 0, TransformOptions{ColorMode: ColorModeGray, Scale: noScale})
+	if assert.NoError(t, err) {
+		assert.Equal(t, makeMarkedPage(4, 2).Pix, res.Image.(*image.Gray).Pix)
+	}
+}
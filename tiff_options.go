@@ -0,0 +1,86 @@
+package imagecoding
+
+import (
+	"errors"
+	"image"
+	"image/color"
+)
+
+// DefaultTiffThreshold is the gray level below which a pixel turns black in bilevel output
+const DefaultTiffThreshold = 128
+
+// ErrTiffEncodeUnsupported is returned by EncodeTiff for CCITT G4 and LZW without the libtiff build tag
+var ErrTiffEncodeUnsupported = errors.New("tiff compression requires libtiff")
+
+// TiffCompression selects the compression of the pages written by EncodeTiff
+type TiffCompression int
+
+const (
+	// TiffCompressionAuto uses CCITT G4 for bilevel images and LZW for the others,
+	// or Deflate for every page without libtiff
+	TiffCompressionAuto TiffCompression = iota
+	// TiffCompressionNone writes the pixels as they are
+	TiffCompressionNone
+	// TiffCompressionLZW is lossless and read by every TIFF reader, it requires libtiff
+	TiffCompressionLZW
+	// TiffCompressionDeflate is lossless and usually smaller than LZW
+	TiffCompressionDeflate
+	// TiffCompressionG4 is CCITT Group 4 fax compression, pages that are not bilevel are thresholded.
+	// It requires libtiff.
+	TiffCompressionG4
+)
+
+// TiffEncodeOptions configures EncodeTiff
+type TiffEncodeOptions struct {
+	// Compression of every page
+	Compression TiffCompression
+	// DPI is written as the horizontal and vertical resolution, zero writes no resolution
+	DPI float64
+	// Threshold is the gray level below which a pixel turns black when a page that is not bilevel
+	// is written with CCITT G4, zero uses DefaultTiffThreshold
+	Threshold uint8
+	// Metadata is written to the ICC profile and baseline tags of every page.
+	// Its DPI is used when DPI is zero.
+	Metadata EncodeMetadata
+}
+
+func (o TiffEncodeOptions) withDefaults() TiffEncodeOptions {
+	if o.Threshold == 0 {
+		o.Threshold = DefaultTiffThreshold
+	}
+	return o
+}
+
+// isBilevel reports whether an image has black and white pixels only
+func isBilevel(img image.Image) bool {
+	switch v := img.(type) {
+	case *image.Gray:
+		for y := v.Rect.Min.Y; y < v.Rect.Max.Y; y++ {
+			for _, p := range v.Pix[v.PixOffset(v.Rect.Min.X, y):v.PixOffset(v.Rect.Max.X, y)] {
+				if p != 0 && p != 0xff {
+					return false
+				}
+			}
+		}
+		return true
+	case *image.Paletted:
+		for _, c := range v.Palette {
+			r, g, b, a := c.RGBA()
+			if a != 0xffff || r != g || g != b || (r != 0 && r != 0xffff) {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+// isGray reports whether an image has a gray color model
+func isGray(img image.Image) bool {
+	switch img.ColorModel() {
+	case color.GrayModel, color.Gray16Model:
+		return true
+	}
+	return false
+}
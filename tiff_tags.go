@@ -0,0 +1,155 @@
+package imagecoding
+
+import (
+	"encoding/binary"
+	"errors"
+	"image"
+)
+
+// The directories of TIFF files are read in Go for the page offsets and the tags that the decoders skip
+
+const (
+	tiffTagXResolution    = 282
+	tiffTagYResolution    = 283
+	tiffTagResolutionUnit = 296
+	// tiffTagICCProfile holds the embedded ICC profile of a page
+	tiffTagICCProfile = 34675
+)
+
+// tiffByteOrder returns the byte order of a TIFF header, nil when it is not one
+func tiffByteOrder(data []byte) binary.ByteOrder {
+	if len(data) < 8 {
+		return nil
+	}
+	switch string(data[:4]) {
+	case "II*\x00":
+		return binary.LittleEndian
+	case "MM\x00*":
+		return binary.BigEndian
+	}
+	return nil
+}
+
+// tiffIFDs returns the offsets of the image file directories of a TIFF file, one for each page
+func tiffIFDs(data []byte) ([]uint32, error) {
+	if len(data) < 8 {
+		return nil, image.ErrFormat
+	}
+	order := tiffByteOrder(data)
+	if order == nil {
+		return nil, image.ErrFormat
+	}
+
+	var ifds []uint32
+	seen := make(map[uint32]bool)
+	offset := order.Uint32(data[4:])
+	for offset != 0 {
+		// A directory is an entry count, 12 bytes per entry and the offset of the next directory
+		if seen[offset] || uint64(offset)+2 > uint64(len(data)) {
+			return nil, errors.New("invalid tiff directory offset")
+		}
+		end := uint64(offset) + 2 + 12*uint64(order.Uint16(data[offset:]))
+		if end+4 > uint64(len(data)) {
+			return nil, errors.New("invalid tiff directory offset")
+		}
+		seen[offset] = true
+		ifds = append(ifds, offset)
+		offset = order.Uint32(data[end:])
+	}
+	if len(ifds) == 0 {
+		return nil, errors.New("tiff has no pages")
+	}
+	return ifds, nil
+}
+
+// tiffICCProfile returns the ICC profile of a page, nil when it has none or the directories can not be read
+func tiffICCProfile(data []byte, index int) []byte {
+	ifds, err := tiffIFDs(data)
+	if err != nil || index < 0 || index >= len(ifds) {
+		return nil
+	}
+	return tiffTagBytes(data, ifds[index], tiffTagICCProfile)
+}
+
+// tiffEntry returns the 12 byte entry of a tag in a directory, nil when it is missing
+func tiffEntry(data []byte, ifd uint32, tag uint16) []byte {
+	order := tiffByteOrder(data)
+	count := uint32(order.Uint16(data[ifd:]))
+	for i := uint32(0); i < count; i++ {
+		entry := data[ifd+2+12*i:]
+		if order.Uint16(entry) == tag {
+			return entry[:12]
+		}
+	}
+	return nil
+}
+
+// tiffTagBytes returns the data of a BYTE or UNDEFINED tag of a directory, nil when it is missing
+func tiffTagBytes(data []byte, ifd uint32, tag uint16) []byte {
+	order := tiffByteOrder(data)
+	entry := tiffEntry(data, ifd, tag)
+	if entry == nil {
+		return nil
+	}
+	if typ := order.Uint16(entry[2:]); typ != 1 && typ != 7 {
+		return nil
+	}
+	// Values of up to 4 bytes are stored in place of the offset
+	size := uint64(order.Uint32(entry[4:]))
+	if size <= 4 {
+		return entry[8 : 8+size]
+	}
+	offset := uint64(order.Uint32(entry[8:]))
+	if offset+size > uint64(len(data)) {
+		return nil
+	}
+	return data[offset : offset+size]
+}
+
+// tiffTagShort returns the first value of a SHORT tag of a directory
+func tiffTagShort(data []byte, ifd uint32, tag uint16) (uint16, bool) {
+	order := tiffByteOrder(data)
+	entry := tiffEntry(data, ifd, tag)
+	if entry == nil || order.Uint16(entry[2:]) != 3 || order.Uint32(entry[4:]) == 0 {
+		return 0, false
+	}
+	return order.Uint16(entry[8:]), true
+}
+
+// tiffTagRational returns the first value of a RATIONAL tag of a directory
+func tiffTagRational(data []byte, ifd uint32, tag uint16) (float64, bool) {
+	order := tiffByteOrder(data)
+	entry := tiffEntry(data, ifd, tag)
+	if entry == nil || order.Uint16(entry[2:]) != 5 || order.Uint32(entry[4:]) == 0 {
+		return 0, false
+	}
+	offset := uint64(order.Uint32(entry[8:]))
+	if offset+8 > uint64(len(data)) {
+		return 0, false
+	}
+	num, den := order.Uint32(data[offset:]), order.Uint32(data[offset+4:])
+	if den == 0 {
+		return 0, false
+	}
+	return float64(num) / float64(den), true
+}
+
+// tiffResolution reads the resolution tags of a directory, the unit defaults to inches
+func tiffResolution(data []byte, ifd uint32) Resolution {
+	x, okX := tiffTagRational(data, ifd, tiffTagXResolution)
+	y, okY := tiffTagRational(data, ifd, tiffTagYResolution)
+	if !okX || !okY {
+		return Resolution{}
+	}
+	unit, ok := tiffTagShort(data, ifd, tiffTagResolutionUnit)
+	switch {
+	case !ok || unit == 2:
+		return Resolution{X: x, Y: y}
+	case unit == 3:
+		// Centimeters
+		return Resolution{X: x * 2.54, Y: y * 2.54}
+	default:
+		// No absolute unit, the resolutions are an aspect ratio
+		return Resolution{}
+	}
+}
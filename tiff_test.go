@@ -0,0 +1,201 @@
+package imagecoding
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/disintegration/imaging"
+	"github.com/rwcarlsen/goexif/exif"
+	"github.com/stretchr/testify/assert"
+)
+
+// makeMultiPageTiff writes uncompressed gray pages, each with its own orientation tag
+func makeMultiPageTiff(pages []*image.Gray, orients []Orientation) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("II*\x00")
+	binary.Write(&buf, binary.LittleEndian, uint32(8))
+	for i, page := range pages {
+		w, h := page.Rect.Dx(), page.Rect.Dy()
+		entries := [][3]uint32{
+			{256, 3, uint32(w)},          // ImageWidth
+			{257, 3, uint32(h)},          // ImageLength
+			{258, 3, 8},                  // BitsPerSample
+			{259, 3, 1},                  // Compression
+			{262, 3, 1},                  // PhotometricInterpretation
+			{273, 4, 0},                  // StripOffsets, filled in below
+			{274, 3, uint32(orients[i])}, // Orientation
+			{277, 3, 1},                  // SamplesPerPixel
+			{278, 3, uint32(h)},          // RowsPerStrip
+			{279, 4, uint32(w * h)},      // StripByteCounts
+		}
+		ifdSize := 2 + 12*len(entries) + 4
+		entries[5][2] = uint32(buf.Len() + ifdSize)
+		next := uint32(0)
+		if i < len(pages)-1 {
+			next = uint32(buf.Len() + ifdSize + w*h)
+		}
+
+		binary.Write(&buf, binary.LittleEndian, uint16(len(entries)))
+		for _, e := range entries {
+			binary.Write(&buf, binary.LittleEndian, uint16(e[0]))
+			binary.Write(&buf, binary.LittleEndian, uint16(e[1]))
+			binary.Write(&buf, binary.LittleEndian, uint32(1))
+			binary.Write(&buf, binary.LittleEndian, e[2])
+		}
+		binary.Write(&buf, binary.LittleEndian, next)
+		buf.Write(page.Pix)
+	}
+	return buf.Bytes()
+}
+
+// makeMarkedPage returns a white page with a black pixel in the top left corner
+func makeMarkedPage(w, h int) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, w, h))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+	img.SetGray(0, 0, color.Gray{})
+	return img
+}
+
+func TestTiffPages(t *testing.T) {
+	data := makeMultiPageTiff(
+		[]*image.Gray{makeMarkedPage(40, 30), makeMarkedPage(20, 10), makeMarkedPage(16, 8)},
+		[]Orientation{TopLeft, RightTop, BottomRight},
+	)
+
+	count, err := PageCount(data)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 3, count)
+	}
+
+	tests := []struct {
+		index         int
+		width, height int
+		orient        Orientation
+		black         image.Point
+	}{
+		{0, 40, 30, TopLeft, image.Pt(0, 0)},
+		{1, 10, 20, RightTop, image.Pt(9, 0)},
+		{2, 16, 8, BottomRight, image.Pt(15, 7)},
+	}
+	for _, tt := range tests {
+		res, err := TransformPage(data, tt.index, TransformOptions{ColorMode: ColorModeGray})
+		if !assert.NoError(t, err) {
+			continue
+		}
+		assert.Equal(t, Tiff, res.Format)
+		assert.Equal(t, tt.orient, res.Orientation)
+		assert.Equal(t, tt.width, res.Width)
+		assert.Equal(t, tt.height, res.Height)
+		gray := res.Image.(*image.Gray)
+		assert.Equal(t, uint8(0), gray.GrayAt(tt.black.X, tt.black.Y).Y, "page %d", tt.index)
+	}
+
+	_, err = TransformPage(data, 3, TransformOptions{})
+	assert.Equal(t, ErrPageOutOfRange, err)
+	_, err = TransformPage(data, -1, TransformOptions{})
+	assert.Equal(t, ErrPageOutOfRange, err)
+}
+
+func TestPageCountSinglePage(t *testing.T) {
+	var buf bytes.Buffer
+	data, err := EncodePng(&buf, makeMarkedPage(4, 4))
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	count, err := PageCount(data)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 1, count)
+	}
+	_, err = TransformPage(data, 1, TransformOptions{})
+	assert.Equal(t, ErrPageOutOfRange, err)
+}
+
+// testTiffPages are a bilevel, a gray and an RGB page
+func testTiffPages() []image.Image {
+	gray := image.NewGray(image.Rect(0, 0, 50, 40))
+	for i := range gray.Pix {
+		gray.Pix[i] = uint8(i)
+	}
+	return []image.Image{makeMarkedPage(100, 80), gray, toRGB(imaging.Resize(img, 60, 0, imaging.Box))}
+}
+
+// assertTiffPages checks that the pages of a TIFF file decode to the encoded images
+func assertTiffPages(t *testing.T, data []byte, pages []image.Image) {
+	count, err := PageCount(data)
+	if assert.NoError(t, err) {
+		assert.Equal(t, len(pages), count)
+	}
+	for i, page := range pages {
+		if isGray(page) {
+			res, err := TransformPage(data, i, TransformOptions{ColorMode: ColorModeGray, Scale: noScale})
+			if assert.NoError(t, err) {
+				assert.True(t, bytes.Equal(toGray(page).Pix, res.Image.(*image.Gray).Pix), "page %d", i)
+			}
+			continue
+		}
+		res, err := TransformPage(data, i, TransformOptions{Scale: noScale})
+		if assert.NoError(t, err) {
+			assert.True(t, bytes.Equal(toRGB(page).Pix, res.Image.(*RGBImage).Pix), "page %d", i)
+		}
+	}
+}
+
+func TestEncodeTiff(t *testing.T) {
+	pages := testTiffPages()
+	for _, compression := range []TiffCompression{TiffCompressionNone, TiffCompressionDeflate} {
+		var buf bytes.Buffer
+		data, err := EncodeTiff(&buf, pages, TiffEncodeOptions{Compression: compression, DPI: 200})
+		if !assert.NoError(t, err) {
+			continue
+		}
+		data = append([]byte(nil), data...)
+		assertTiffPages(t, data, pages)
+
+		x, err := exif.Decode(bytes.NewReader(data))
+		if assert.NoError(t, err) {
+			res, err := x.Get(exif.XResolution)
+			if assert.NoError(t, err) {
+				num, denom, _ := res.Rat2(0)
+				assert.Equal(t, float64(200), float64(num)/float64(denom))
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	_, err := EncodeTiff(&buf, nil, TiffEncodeOptions{})
+	assert.Equal(t, ErrEmptyInput, err)
+}
+
+func TestEncodeTiffMetadata(t *testing.T) {
+	meta := testMetadata()
+	var buf bytes.Buffer
+	data, err := EncodeTiff(&buf, []image.Image{makeP3Red(), makeP3Red()}, TiffEncodeOptions{Metadata: meta})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assertExif(t, data, 150)
+	for page := 0; page < 2; page++ {
+		assert.Equal(t, meta.ICCProfile, tiffICCProfile(data, page))
+	}
+
+	res, err := TransformPage(data, 1, TransformOptions{Scale: noScale, ConvertToSRGB: true})
+	if assert.NoError(t, err) {
+		assert.Equal(t, RightTop, res.Orientation)
+		c := res.Image.(*RGBImage).RGBAAt(4, 4)
+		assertRGB(t, [3]uint8{255, 0, 0}, c.R, c.G, c.B, 4)
+	}
+
+	// The resolution of the options wins
+	data, err = EncodeTiff(&buf, []image.Image{makeP3Red()}, TiffEncodeOptions{DPI: 300, Metadata: meta})
+	if assert.NoError(t, err) {
+		assertExif(t, data, 300)
+		resolution, err := ImageResolution(data)
+		assert.NoError(t, err)
+		assert.Equal(t, Resolution{300, 300}, resolution)
+	}
+}
@@ -3,30 +3,221 @@ package imagecoding
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"image"
 	"image/color"
 	"image/gif"
 	"image/png"
+	"math"
 
 	"github.com/disintegration/imaging"
 	"github.com/h2non/filetype"
 	"golang.org/x/image/bmp"
-	"golang.org/x/image/tiff"
 	"golang.org/x/image/webp"
 )
 
+// ColorMode selects the pixel layout of a transformed image
+type ColorMode int
+
+const (
+	// ColorModeRGB produces an *RGBImage
+	ColorModeRGB ColorMode = iota
+	// ColorModeGray produces an *image.Gray
+	ColorModeGray
+)
+
+// OrientationPolicy controls how orientation metadata is applied during a transform
+type OrientationPolicy int
+
+const (
+	// OrientationAuto rotates and flips the image upright according to its EXIF orientation
+	OrientationAuto OrientationPolicy = iota
+	// OrientationIgnore keeps the image as it is stored, ignoring EXIF orientation
+	OrientationIgnore
+)
+
+// DefaultNoResizeTolerance is how far the scale factor may stray from 1 before an image is resized
+const DefaultNoResizeTolerance = 0.1
+
+var ErrImageTooLarge = errors.New("image exceeds size limits")
+
+// Limits bounds the size of the source images a transform will accept.
+// A zero value means no limit.
+type Limits struct {
+	MaxWidth  int
+	MaxHeight int
+	MaxPixels int
+}
+
+func (l Limits) check(width, height int) error {
+	if l.MaxWidth > 0 && width > l.MaxWidth {
+		return ErrImageTooLarge
+	}
+	if l.MaxHeight > 0 && height > l.MaxHeight {
+		return ErrImageTooLarge
+	}
+	if l.MaxPixels > 0 && width*height > l.MaxPixels {
+		return ErrImageTooLarge
+	}
+	return nil
+}
+
+// TransformOptions configures TransformWithOptions and the format specific transforms.
+// The zero value produces an RGB image scaled with DefaultScale.
+type TransformOptions struct {
+	// ColorMode selects gray or RGB output
+	ColorMode ColorMode
+	// Scale calculates the output size, DefaultScale is used when nil
+	Scale ScaleFunc
+	// Filter is the resampling filter used when resizing, imaging.CatmullRom is used when unset.
+	// JPEGs are scaled with DCT factors and do not use the filter.
+	Filter imaging.ResampleFilter
+	// NoResizeTolerance skips resizing when the scale factor is within 1±NoResizeTolerance.
+	// Zero uses DefaultNoResizeTolerance, a negative value resizes whenever the scale factor differs from 1.
+	NoResizeTolerance float64
+	// Orientation controls whether EXIF orientation is applied.
+	// HEIF images always have their irot/imir transformations applied, their EXIF orientation
+	// only counts when they have none.
+	Orientation OrientationPolicy
+	// Limits rejects source images that are too large with ErrImageTooLarge
+	Limits Limits
+	// ConvertToSRGB converts images with an embedded ICC profile to sRGB, see ConvertToSRGB.
+	// Unsupported profiles leave the colors as they are with a warning. HEIF images are converted
+	// with their ICC or nclx profile, CMYK JPEGs always use their profile.
+	ConvertToSRGB bool
+}
+
+// withDefaults fills in the unset fields of the options
+func (o TransformOptions) withDefaults() TransformOptions {
+	if o.Scale == nil {
+		o.Scale = DefaultScale
+	}
+	if o.Filter.Kernel == nil {
+		o.Filter = imaging.CatmullRom
+	}
+	if o.NoResizeTolerance == 0 {
+		o.NoResizeTolerance = DefaultNoResizeTolerance
+	} else if o.NoResizeTolerance < 0 {
+		o.NoResizeTolerance = 0
+	}
+	return o
+}
+
+// resizes reports whether a scale factor is far enough from 1 to warrant resizing
+func (o TransformOptions) resizes(scaleFactor float64) bool {
+	return math.Abs(scaleFactor-1) > o.NoResizeTolerance
+}
+
+// TransformResult describes a transformed image and how it was derived from the source
+type TransformResult struct {
+	// Image is the transformed image
+	Image image.Image
+	// Format is the detected format of the source
+	Format ImgFormat
+	// Width and Height are the dimensions of the source after orientation has been applied
+	Width, Height int
+	// OutWidth and OutHeight are the dimensions of Image
+	OutWidth, OutHeight int
+	// ScaleFactor is the uniform scale factor that was applied, for JPEGs this is the DCT scale factor
+	ScaleFactor float64
+	// ScaleX and ScaleY are the effective per-axis scale factors after rounding to whole pixels
+	ScaleX, ScaleY float64
+	// Orientation is the EXIF orientation that was applied to make the image upright
+	Orientation Orientation
+	// ColorModel is the color model of the source
+	ColorModel color.Model
+	// Warnings holds non-fatal messages reported by the decoder
+	Warnings []string
+	// ToOutput maps coordinates in the source, as stored before orientation, to coordinates in Image
+	ToOutput Affine
+	// ToSource maps coordinates in Image back to the source as stored, the inverse of ToOutput
+	ToSource Affine
+}
+
+// setImage stores the output image and derives the output dimensions and per-axis scale factors
+func (r *TransformResult) setImage(img image.Image) {
+	r.Image = img
+	r.OutWidth = img.Bounds().Dx()
+	r.OutHeight = img.Bounds().Dy()
+	if r.Width > 0 && r.Height > 0 {
+		r.ScaleX = float64(r.OutWidth) / float64(r.Width)
+		r.ScaleY = float64(r.OutHeight) / float64(r.Height)
+	}
+}
+
+// setMapping composes the orientation and the scaling by sx and sy into ToOutput and ToSource
+func (r *TransformResult) setMapping(sx, sy float64) {
+	storedW, storedH := r.Width, r.Height
+	if r.Orientation >= LeftTop && r.Orientation <= LeftBottom {
+		storedW, storedH = storedH, storedW
+	}
+	r.ToOutput = orientationAffine(r.Orientation, storedW, storedH).Then(scaleAffine(sx, sy))
+	r.ToSource, _ = r.ToOutput.Invert()
+}
+
+// legacyOptions maps the positional arguments of Transform to TransformOptions
+func legacyOptions(grayscale bool, scale ScaleFunc) TransformOptions {
+	opts := TransformOptions{Scale: scale}
+	if grayscale {
+		opts.ColorMode = ColorModeGray
+	}
+	return opts
+}
+
 // Transform scales, colormaps and orients an image according to input param
 func Transform(data []byte, grayscale bool, scale ScaleFunc) (out image.Image, width, height int, scaleFactor float64, err error) {
+	res, err := TransformWithOptions(data, legacyOptions(grayscale, scale))
+	if err != nil {
+		return nil, 0, 0, 0, err
+	}
+	return res.Image, res.Width, res.Height, res.ScaleFactor, nil
+}
+
+// TransformWithOptions scales, colormaps and orients an image according to the options.
+// The output is always an *image.Gray or an *RGBImage, ready for the encoders.
+func TransformWithOptions(data []byte, opts TransformOptions) (TransformResult, error) {
 	if len(data) == 0 {
-		return nil, 0, 0, 0, ErrEmptyInput
+		return TransformResult{}, ErrEmptyInput
 	}
+	opts = opts.withDefaults()
+
 	// Look at the magic bytes to determine the file type
 	kind, err := filetype.Match(data)
 	if err != nil {
-		return nil, 0, 0, 0, errors.New("could not determine file type")
+		return TransformResult{}, errors.New("could not determine file type")
 	}
 	format := ImgFormat(kind.Extension)
 
+	// Early return for the formats with their own fast path
+	switch format {
+	case Jpeg:
+		return TransformJpegWithOptions(data, opts)
+	case Heif:
+		return TransformHeifWithOptions(data, opts)
+	case Avif:
+		return TransformAvifWithOptions(data, opts)
+	case Jxl:
+		return TransformJxlWithOptions(data, opts)
+	case Jp2, J2k:
+		return TransformJp2WithOptions(data, opts)
+	case Pdf:
+		return TransformPdfWithOptions(data, opts)
+	case Tiff:
+		return transformTiffPage(data, 0, opts)
+	case Webp:
+		// x/image/webp only decodes still images
+		if isAnimatedWebP(data) {
+			return TransformFrame(data, 0, opts)
+		}
+	}
+
+	// Check the limits before decoding the full image
+	if conf, _, cerr := image.DecodeConfig(bytes.NewReader(data)); cerr == nil {
+		if err = opts.Limits.check(conf.Width, conf.Height); err != nil {
+			return TransformResult{}, err
+		}
+	}
+
 	var img image.Image
 	imagefile := bytes.NewReader(data)
 
@@ -35,49 +226,73 @@ func Transform(data []byte, grayscale bool, scale ScaleFunc) (out image.Image, w
 		img, err = webp.Decode(imagefile)
 	case Png:
 		img, err = png.Decode(imagefile)
-	case Jpeg:
-		// Early return for JPEG fast path
-		return TransformJpeg(data, grayscale, scale)
-	case Tiff:
-		orient := GetOrientation(imagefile)
-		img, err = tiff.Decode(imagefile)
-		img = FixOrientation(img, orient)
 	case Gif:
 		img, err = gif.Decode(imagefile)
 	case Bmp:
 		img, err = bmp.Decode(imagefile)
-	case Heif:
-		return TransformHeif(data, grayscale, scale)
 	default:
 		err = image.ErrFormat
 	}
 	if err != nil {
-		return nil, 0, 0, 0, err
+		return TransformResult{}, err
+	}
+
+	var profile []byte
+	var warnings []string
+	if opts.ConvertToSRGB {
+		switch format {
+		case Png:
+			if profile, err = pngICCProfile(data); err != nil {
+				warnings = append(warnings, fmt.Sprintf("could not read the color profile: %v", err))
+			}
+		case Webp:
+			profile = webpICCProfile(data)
+		}
+	}
+	result := finishTransform(img, format, TopLeft, profile, opts)
+	result.Warnings = append(warnings, result.Warnings...)
+	return result, nil
+}
+
+// finishTransform scales and colormaps an upright decoded image for the decoders
+// without a scaling fast path. orient is the orientation that was already applied,
+// profile the embedded ICC profile for TransformOptions.ConvertToSRGB.
+func finishTransform(img image.Image, format ImgFormat, orient Orientation, profile []byte, opts TransformOptions) TransformResult {
+	result := TransformResult{
+		Format:      format,
+		Width:       img.Bounds().Dx(),
+		Height:      img.Bounds().Dy(),
+		Orientation: orient,
+		ColorModel:  img.ColorModel(),
 	}
-	width = img.Bounds().Dx()
-	height = img.Bounds().Dy()
 
 	// Scale the image
-	imgWidth, imgHeight, scaleFactor := scale(img.Bounds().Dx(), img.Bounds().Dy())
-	if scaleFactor > 1.1 || scaleFactor < 0.9 {
-		img = imaging.Resize(img, imgWidth, imgHeight, imaging.CatmullRom)
+	imgWidth, imgHeight, scaleFactor := opts.Scale(result.Width, result.Height)
+	if opts.resizes(scaleFactor) {
+		img = imaging.Resize(img, imgWidth, imgHeight, opts.Filter)
 	} else {
 		scaleFactor = 1
 	}
+	result.ScaleFactor = scaleFactor
 
-	if grayscale {
-		// Drop the channels we don't need by converting to image.Gray
-		bounds := img.Bounds()
-		imgGray := image.NewGray(bounds)
-		for y := 0; y < bounds.Max.Y; y++ {
-			for x := 0; x < bounds.Max.X; x++ {
-				oldPixel := img.At(x, y)
-				pixel := color.GrayModel.Convert(oldPixel)
-				imgGray.Set(x, y, pixel)
-			}
+	// Convert the colors at the output size and before dropping them for gray output
+	if opts.ConvertToSRGB && profile != nil {
+		converted, err := ConvertToSRGB(img, profile)
+		if err != nil {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("could not convert the color profile to sRGB: %v", err))
+		} else {
+			img = converted
 		}
-		img = imgGray
 	}
 
-	return img, width, height, scaleFactor, nil
+	// Normalize the pixel type to match the JPEG path
+	if opts.ColorMode == ColorModeGray {
+		// Drop the channels we don't need by converting to image.Gray
+		img = toGray(img)
+	} else {
+		img = toRGB(img)
+	}
+	result.setImage(img)
+	result.setMapping(result.ScaleX, result.ScaleY)
+	return result
 }
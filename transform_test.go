@@ -1,10 +1,17 @@
 package imagecoding
 
 import (
+	"bytes"
+	"image"
+	"image/gif"
+	"image/png"
 	"os"
 	"testing"
 
+	"github.com/disintegration/imaging"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
 )
 
 func TestTransform(t *testing.T) {
@@ -50,6 +57,126 @@ func TestTransformEmpty(t *testing.T) {
 	}
 }
 
+func TestTransformWithOptions(t *testing.T) {
+	sample, err := os.ReadFile("testdata/gamer.png")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	t.Run("rgb", func(t *testing.T) {
+		res, err := TransformWithOptions(sample, TransformOptions{Filter: imaging.Lanczos})
+		if assert.NoError(t, err) {
+			assert.Equal(t, 1240, res.Image.Bounds().Dx())
+			assert.Equal(t, 1317, res.Image.Bounds().Dy())
+		}
+	})
+	t.Run("tolerance", func(t *testing.T) {
+		opts := TransformOptions{ColorMode: ColorModeGray, NoResizeTolerance: 0.5}
+		res, err := TransformWithOptions(sample, opts)
+		if assert.NoError(t, err) {
+			assert.IsType(t, &image.Gray{}, res.Image)
+			assert.Equal(t, 1.0, res.ScaleFactor)
+			assert.Equal(t, res.Width, res.Image.Bounds().Dx())
+			assert.Equal(t, res.Height, res.Image.Bounds().Dy())
+		}
+	})
+	t.Run("limits", func(t *testing.T) {
+		opts := TransformOptions{Limits: Limits{MaxPixels: 1000 * 1000}}
+		_, err := TransformWithOptions(sample, opts)
+		assert.Equal(t, ErrImageTooLarge, err)
+	})
+}
+
+func TestTransformJpegOrientation(t *testing.T) {
+	sample, err := os.ReadFile("testdata/f6-exif.jpg")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	res, err := TransformWithOptions(sample, TransformOptions{})
+	if assert.NoError(t, err) {
+		assert.Equal(t, image.Rect(0, 0, 40, 80), res.Image.Bounds())
+		assert.Equal(t, RightTop, res.Orientation)
+	}
+	res, err = TransformWithOptions(sample, TransformOptions{Orientation: OrientationIgnore})
+	if assert.NoError(t, err) {
+		assert.Equal(t, image.Rect(0, 0, 80, 40), res.Image.Bounds())
+		assert.Equal(t, TopLeft, res.Orientation)
+	}
+}
+
+func TestTransformResult(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+		format   ImgFormat
+		width    int
+		height   int
+		warnings bool
+	}{
+		{"png", "testdata/gamer.png", Png, 1240, 1317, false},
+		{"gif", "testdata/rose_grey.gif", Gif, 70, 46, false},
+		{"jpeg", "testdata/world-political.jpg", Jpeg, 1890, 1080, false},
+		{"jpeg-warning-invalid-sos", "testdata/samsung-invalid-sos.jpg", Jpeg, 440, 500, true},
+	}
+	for _, tt := range tests {
+		sample, err := os.ReadFile(tt.filename)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := TransformWithOptions(sample, TransformOptions{ColorMode: ColorModeGray})
+			if assert.NoError(t, err) {
+				assert.Equal(t, tt.format, res.Format)
+				assert.Equal(t, tt.width, res.OutWidth)
+				assert.Equal(t, tt.height, res.OutHeight)
+				assert.InDelta(t, res.ScaleFactor, res.ScaleX, 0.01)
+				assert.InDelta(t, res.ScaleFactor, res.ScaleY, 0.01)
+				assert.NotNil(t, res.ColorModel)
+				assert.Equal(t, tt.warnings, len(res.Warnings) > 0)
+			}
+		})
+	}
+}
+
+func TestTransformPixelTypes(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 64, 48))
+	for i := range src.Pix {
+		src.Pix[i] = uint8(i)
+	}
+	encoders := map[string]func(*bytes.Buffer) error{
+		"bmp":  func(buf *bytes.Buffer) error { return bmp.Encode(buf, src) },
+		"gif":  func(buf *bytes.Buffer) error { return gif.Encode(buf, src, nil) },
+		"png":  func(buf *bytes.Buffer) error { return png.Encode(buf, src) },
+		"tiff": func(buf *bytes.Buffer) error { return tiff.Encode(buf, src, nil) },
+		"webp": func(buf *bytes.Buffer) error { _, err := EncodeWebP(buf, src); return err },
+	}
+	for name, encode := range encoders {
+		var sample bytes.Buffer
+		if !assert.NoError(t, encode(&sample)) {
+			t.FailNow()
+		}
+		t.Run(name, func(t *testing.T) {
+			for _, mode := range []ColorMode{ColorModeGray, ColorModeRGB} {
+				res, err := TransformWithOptions(sample.Bytes(), TransformOptions{ColorMode: mode})
+				if !assert.NoError(t, err) {
+					continue
+				}
+				if mode == ColorModeGray {
+					assert.IsType(t, &image.Gray{}, res.Image)
+				} else {
+					assert.IsType(t, &RGBImage{}, res.Image)
+				}
+				var buf bytes.Buffer
+				_, err = EncodeJpeg(&buf, res.Image, 80)
+				assert.NoError(t, err)
+				_, err = EncodePng(&buf, res.Image)
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
 func BenchmarkPNGTransform(b *testing.B) {
 	sample, err := os.ReadFile("testdata/gamer.png")
 	if !assert.NoError(b, err) {
@@ -1,26 +1,199 @@
 package imagecoding
 
 import (
-	"bufio"
 	"bytes"
+	"fmt"
 	"image"
+	"unsafe"
+)
+
+// #cgo pkg-config: libwebp
+// #include <stdlib.h>
+// #include <webp/encode.h>
+//
+// typedef struct {
+//     int lossy;
+//     int preset;
+//     float quality;
+//     int method;
+//     int alphaQuality;
+//     int nearLossless;
+// } goWebPOptions;
+//
+// // goWebPEncode encodes RGB or RGBA pixels into writer, which must be cleared by the caller.
+// // It returns VP8_ENC_OK or the libwebp error code.
+// int goWebPEncode(const uint8_t *pix, int width, int height, int stride, int alpha,
+//                  goWebPOptions *opts, WebPMemoryWriter *writer) {
+//     WebPConfig config;
+//     WebPPicture pic;
+//     int ok;
+//
+//     WebPMemoryWriterInit(writer);
+//     if (!WebPConfigPreset(&config, (WebPPreset)opts->preset, opts->quality)) {
+//         return VP8_ENC_ERROR_INVALID_CONFIGURATION;
+//     }
+//     config.method = opts->method;
+//     if (opts->lossy) {
+//         config.alpha_quality = opts->alphaQuality;
+//     } else {
+//         config.lossless = 1;
+//         config.near_lossless = opts->nearLossless;
+//     }
+//     if (!WebPValidateConfig(&config) || !WebPPictureInit(&pic)) {
+//         return VP8_ENC_ERROR_INVALID_CONFIGURATION;
+//     }
+//
+//     // Lossless encodes ARGB, lossy converts to YUV while importing
+//     pic.use_argb = !opts->lossy;
+//     pic.width = width;
+//     pic.height = height;
+//     ok = alpha ? WebPPictureImportRGBA(&pic, pix, stride) : WebPPictureImportRGB(&pic, pix, stride);
+//     if (!ok) {
+//         WebPPictureFree(&pic);
+//         return VP8_ENC_ERROR_OUT_OF_MEMORY;
+//     }
+//     pic.writer = WebPMemoryWrite;
+//     pic.custom_ptr = writer;
+//     ok = WebPEncode(&config, &pic);
+//     WebPPictureFree(&pic);
+//     return ok ? VP8_ENC_OK : pic.error_code;
+// }
+import "C"
+
+// DefaultWebPQuality is the lossy quality used when WebPEncodeOptions.Quality is zero
+const DefaultWebPQuality = 75
+
+// WebPMode selects lossless or lossy WebP compression
+type WebPMode int
+
+const (
+	// WebPLossless keeps every pixel intact
+	WebPLossless WebPMode = iota
+	// WebPLossy compresses like JPEG, much smaller for photos and scans
+	WebPLossy
+)
+
+// WebPPreset tunes the lossy encoder for a kind of image
+type WebPPreset int
 
-	"github.com/kolesa-team/go-webp/encoder"
-	newwebp "github.com/kolesa-team/go-webp/webp"
+const (
+	WebPPresetDefault WebPPreset = C.WEBP_PRESET_DEFAULT
+	// WebPPresetPicture suits digital pictures, like portraits and inner shots
+	WebPPresetPicture WebPPreset = C.WEBP_PRESET_PICTURE
+	// WebPPresetPhoto suits outdoor photographs with natural lighting
+	WebPPresetPhoto WebPPreset = C.WEBP_PRESET_PHOTO
+	// WebPPresetDrawing suits drawings with high contrast details
+	WebPPresetDrawing WebPPreset = C.WEBP_PRESET_DRAWING
+	// WebPPresetIcon suits small colorful images
+	WebPPresetIcon WebPPreset = C.WEBP_PRESET_ICON
+	// WebPPresetText suits text like documents and receipts
+	WebPPresetText WebPPreset = C.WEBP_PRESET_TEXT
 )
 
+// WebPEncodeOptions configures EncodeWebPWithOptions.
+// The zero value matches EncodeWebP, fast lossless compression.
+type WebPEncodeOptions struct {
+	// Mode selects lossless or lossy compression
+	Mode WebPMode
+	// Quality ranges from 0 to 100. Lossy compression uses DefaultWebPQuality when zero,
+	// for lossless compression it is the effort spent on making the file smaller.
+	Quality float32
+	// Method ranges from 0 (fastest) to 6 (slowest, smallest files), libwebp's own default is 4
+	Method int
+	// Preset tunes the encoder settings for a kind of image
+	Preset WebPPreset
+	// AlphaQuality of lossy compression ranges from 1 to 100, zero keeps the alpha channel lossless
+	AlphaQuality int
+	// NearLossless of lossless compression ranges from 1 (smallest files) to 99 and adjusts
+	// pixel values to compress better, zero or 100 turn it off
+	NearLossless int
+	// Metadata is written to ICCP and EXIF chunks of an extended file, the DPI to EXIF
+	Metadata EncodeMetadata
+}
+
+// EncodeWebP will encode an image to lossless WebP bytes using libwebp.
+// RGB and straight alpha RGBA images are imported from their own pixels,
+// any other image is converted to straight alpha RGBA first.
 func EncodeWebP(buf *bytes.Buffer, img image.Image) ([]byte, error) {
-	options, err := encoder.NewLosslessEncoderOptions(encoder.PresetDefault, 0)
-	if err != nil {
-		return nil, err
+	return EncodeWebPWithOptions(buf, img, WebPEncodeOptions{})
+}
+
+// EncodeWebPWithOptions will encode an image to WebP bytes with the given options, see EncodeWebP
+func EncodeWebPWithOptions(buf *bytes.Buffer, img image.Image, opts WebPEncodeOptions) ([]byte, error) {
+	if img.Bounds().Empty() {
+		return nil, ErrEmptyInput
+	}
+
+	var pix []uint8
+	var stride int
+	var alpha C.int
+	switch v := img.(type) {
+	case *RGBImage:
+		pix = v.Pix[v.PixOffset(v.Rect.Min.X, v.Rect.Min.Y):]
+		stride = v.Stride
+	case *image.NRGBA:
+		pix = v.Pix[v.PixOffset(v.Rect.Min.X, v.Rect.Min.Y):]
+		stride = v.Stride
+		alpha = 1
+	default:
+		return EncodeWebPWithOptions(buf, toNRGBA(img), opts)
+	}
+
+	copts := C.goWebPOptions{
+		lossy:        cBool(opts.Mode == WebPLossy),
+		preset:       C.int(opts.Preset),
+		quality:      C.float(opts.Quality),
+		method:       C.int(opts.Method),
+		alphaQuality: C.int(opts.AlphaQuality),
+		nearLossless: C.int(opts.NearLossless),
+	}
+	if opts.Mode == WebPLossy {
+		if opts.Quality == 0 {
+			copts.quality = DefaultWebPQuality
+		}
+		if opts.AlphaQuality == 0 {
+			copts.alphaQuality = 100
+		}
+	} else if opts.NearLossless == 0 {
+		copts.nearLossless = 100
+	}
+
+	var writer C.WebPMemoryWriter
+	res := C.goWebPEncode(
+		(*C.uint8_t)(unsafe.Pointer(&pix[0])),
+		C.int(img.Bounds().Dx()), C.int(img.Bounds().Dy()), C.int(stride), alpha,
+		&copts, &writer,
+	)
+	defer C.WebPMemoryWriterClear(&writer)
+	if res != C.VP8_ENC_OK {
+		return nil, fmt.Errorf("could not encode webp: %v", webpErrorString(res))
 	}
 
-	pageWriter := bufio.NewWriter(buf)
+	data := C.GoBytes(unsafe.Pointer(writer.mem), C.int(writer.size))
+	if !opts.Metadata.empty() {
+		var err error
+		data, err = webpWithMetadata(data, opts.Metadata, img.Bounds().Dx(), img.Bounds().Dy())
+		if err != nil {
+			return nil, err
+		}
+	}
+	return setEncoded(buf, data), nil
+}
 
-	err = newwebp.Encode(pageWriter, img, options)
-	if err != nil {
-		return nil, err
+// webpErrorString describes a libwebp encoding error code
+func webpErrorString(code C.int) string {
+	switch code {
+	case C.VP8_ENC_ERROR_OUT_OF_MEMORY, C.VP8_ENC_ERROR_BITSTREAM_OUT_OF_MEMORY:
+		return "out of memory"
+	case C.VP8_ENC_ERROR_INVALID_CONFIGURATION:
+		return "invalid configuration"
+	case C.VP8_ENC_ERROR_BAD_DIMENSION:
+		return "bad dimension"
+	case C.VP8_ENC_ERROR_PARTITION0_OVERFLOW, C.VP8_ENC_ERROR_PARTITION_OVERFLOW:
+		return "partition overflow"
+	case C.VP8_ENC_ERROR_FILE_TOO_BIG:
+		return "file too big"
+	default:
+		return fmt.Sprintf("error code %d", code)
 	}
-	pageWriter.Flush()
-	return buf.Bytes(), nil
 }
@@ -0,0 +1,154 @@
+package imagecoding
+
+import (
+	"errors"
+	"image"
+	"unsafe"
+)
+
+// #cgo pkg-config: libwebpdemux
+// #include <stdlib.h>
+// #include <webp/demux.h>
+//
+// // goWebPAnimNew creates a decoder of straight alpha RGBA canvases, data must outlive the decoder.
+// // Still images are decoded as an animation with a single frame.
+// WebPAnimDecoder *goWebPAnimNew(const uint8_t *data, size_t size, WebPAnimInfo *info) {
+//     WebPAnimDecoderOptions opts;
+//     WebPData webp = {data, size};
+//     WebPAnimDecoder *dec;
+//
+//     if (!WebPAnimDecoderOptionsInit(&opts)) {
+//         return NULL;
+//     }
+//     opts.color_mode = MODE_RGBA;
+//     dec = WebPAnimDecoderNew(&webp, &opts);
+//     if (dec != NULL && !WebPAnimDecoderGetInfo(dec, info)) {
+//         WebPAnimDecoderDelete(dec);
+//         return NULL;
+//     }
+//     return dec;
+// }
+//
+// // goWebPFrameCount counts the frames of a WebP file from its chunks, -1 when they are invalid.
+// // Still images have a single frame.
+// int goWebPFrameCount(const uint8_t *data, size_t size) {
+//     WebPData webp = {data, size};
+//     WebPDemuxer *demux = WebPDemux(&webp);
+//     int count;
+//
+//     if (demux == NULL) {
+//         return -1;
+//     }
+//     count = (int)WebPDemuxGetI(demux, WEBP_FF_FRAME_COUNT);
+//     WebPDemuxDelete(demux);
+//     return count;
+// }
+import "C"
+
+// webpAnim decodes the frames of a WebP file in order, libwebp blends and disposes of the frames
+type webpAnim struct {
+	data   unsafe.Pointer
+	dec    *C.WebPAnimDecoder
+	info   C.WebPAnimInfo
+	canvas *C.uint8_t
+}
+
+// openWebPAnim parses the headers of a WebP file, the decoder must be closed.
+// The canvas size is checked against the limits before the decoder allocates the canvas.
+func openWebPAnim(data []byte, limits Limits) (*webpAnim, error) {
+	var features C.WebPBitstreamFeatures
+	if C.WebPGetFeatures((*C.uint8_t)(unsafe.Pointer(&data[0])), C.size_t(len(data)), &features) != C.VP8_STATUS_OK {
+		return nil, errors.New("could not decode webp")
+	}
+	if err := limits.check(int(features.width), int(features.height)); err != nil {
+		return nil, err
+	}
+
+	// The decoder keeps a pointer to the data, which Go memory must not be
+	a := &webpAnim{data: C.CBytes(data)}
+	a.dec = C.goWebPAnimNew((*C.uint8_t)(a.data), C.size_t(len(data)), &a.info)
+	if a.dec == nil {
+		C.free(a.data)
+		return nil, errors.New("could not decode webp")
+	}
+	return a, nil
+}
+
+func (a *webpAnim) close() {
+	C.WebPAnimDecoderDelete(a.dec)
+	C.free(a.data)
+}
+
+func (a *webpAnim) frameCount() int {
+	return int(a.info.frame_count)
+}
+
+func (a *webpAnim) size() (width, height int) {
+	return int(a.info.canvas_width), int(a.info.canvas_height)
+}
+
+// next decodes the next frame onto the canvas
+func (a *webpAnim) next() error {
+	var timestamp C.int
+	if C.WebPAnimDecoderGetNext(a.dec, &a.canvas, &timestamp) == 0 {
+		return errors.New("could not decode webp frame")
+	}
+	return nil
+}
+
+// image copies the canvas of the last decoded frame
+func (a *webpAnim) image() *image.NRGBA {
+	width, height := a.size()
+	return &image.NRGBA{
+		Pix:    C.GoBytes(unsafe.Pointer(a.canvas), C.int(width*height*4)),
+		Stride: width * 4,
+		Rect:   image.Rect(0, 0, width, height),
+	}
+}
+
+// isAnimatedWebP looks for the animation flag of the extended WebP header
+func isAnimatedWebP(data []byte) bool {
+	return len(data) > 20 && string(data[12:16]) == "VP8X" && data[20]&0x02 != 0
+}
+
+// webpFrameCount returns the number of frames of a WebP file without decoding them
+func webpFrameCount(data []byte) (int, error) {
+	count := C.goWebPFrameCount((*C.uint8_t)(unsafe.Pointer(&data[0])), C.size_t(len(data)))
+	if count < 0 {
+		return 0, errors.New("could not decode webp")
+	}
+	return int(count), nil
+}
+
+// transformWebPFrames transforms the frames from first to last of a WebP file, all when last is negative
+func transformWebPFrames(data []byte, first, last int, opts TransformOptions) ([]TransformResult, error) {
+	a, err := openWebPAnim(data, opts.Limits)
+	if err != nil {
+		return nil, err
+	}
+	defer a.close()
+
+	if last < 0 {
+		last = a.frameCount() - 1
+	}
+	if first < 0 || last >= a.frameCount() {
+		return nil, ErrFrameOutOfRange
+	}
+
+	var profile []byte
+	if opts.ConvertToSRGB {
+		profile = webpICCProfile(data)
+	}
+
+	// Every frame builds on the canvas of the one before
+	var results []TransformResult
+	for i := 0; i <= last; i++ {
+		if err = a.next(); err != nil {
+			return nil, err
+		}
+		if i >= first {
+			results = append(results, finishTransform(a.image(), Webp, TopLeft, profile, opts))
+		}
+	}
+	return results, nil
+}
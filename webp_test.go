@@ -2,10 +2,14 @@ package imagecoding
 
 import (
 	"bytes"
-	"golang.org/x/image/webp"
+	"image"
+	"os"
 	"testing"
 
+	"golang.org/x/image/webp"
+
 	"github.com/Nr90/imgsim"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestEncodeWebp(t *testing.T) {
@@ -25,6 +29,91 @@ func TestEncodeWebp(t *testing.T) {
 	}
 }
 
+func TestEncodeWebpImageTypes(t *testing.T) {
+	for name, src := range makeConvertTestImages() {
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+			imgbytes, err := EncodeWebP(&buf, src)
+			if !assert.NoError(t, err) {
+				return
+			}
+			output, err := webp.Decode(bytes.NewReader(imgbytes))
+			if assert.NoError(t, err) {
+				assertSimilar(t, src, output, 1)
+			}
+		})
+	}
+}
+
+func TestEncodeWebpWithOptions(t *testing.T) {
+	sample, err := os.ReadFile("testdata/world-political.jpg")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	photo, _, _, _, err := TransformJpeg(sample, false, func(w, h int) (int, int, float64) { return w / 4, h / 4, 0.25 })
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	var buf bytes.Buffer
+	lossless, err := EncodeWebP(&buf, photo)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	losslessLen := len(lossless)
+
+	// Lossy output is compared by its average hash, in which a block close to the mean of the
+	// photo may flip
+	tests := []struct {
+		name     string
+		src      image.Image
+		opts     WebPEncodeOptions
+		delta    int
+		distance int
+	}{
+		{"lossy", photo, WebPEncodeOptions{Mode: WebPLossy}, 0, 1},
+		{"lossy-text", photo, WebPEncodeOptions{Mode: WebPLossy, Quality: 90, Method: 6, Preset: WebPPresetText}, 0, 1},
+		{"lossy-alpha", makeConvertTestImages()["nrgba"], WebPEncodeOptions{Mode: WebPLossy, Quality: 100, AlphaQuality: 50}, 0, 0},
+		{"lossless-effort", makeConvertTestImages()["nrgba"], WebPEncodeOptions{Quality: 100, Method: 6}, 1, 0},
+		{"near-lossless", makeConvertTestImages()["rgb-image"], WebPEncodeOptions{NearLossless: 60}, 8, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			imgbytes, err := EncodeWebPWithOptions(&buf, tt.src, tt.opts)
+			if !assert.NoError(t, err) {
+				return
+			}
+			if tt.src == photo {
+				assert.Less(t, len(imgbytes), losslessLen/2)
+			}
+			output, err := webp.Decode(bytes.NewReader(imgbytes))
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, tt.src.Bounds(), output.Bounds())
+			if tt.delta > 0 {
+				assertSimilar(t, tt.src, output, tt.delta)
+			} else {
+				assert.LessOrEqual(t, imgsim.Distance(imgsim.AverageHash(tt.src), imgsim.AverageHash(output)), tt.distance)
+			}
+		})
+	}
+
+	t.Run("buffer", func(t *testing.T) {
+		var buf bytes.Buffer
+		buf.WriteString("stale data")
+		imgbytes, err := EncodeWebP(&buf, img)
+		if assert.NoError(t, err) {
+			assert.Equal(t, "RIFF", string(imgbytes[:4]))
+			assert.Equal(t, buf.Len(), len(imgbytes))
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := EncodeWebPWithOptions(&buf, photo, WebPEncodeOptions{Method: 7})
+		assert.Error(t, err)
+	})
+}
+
 func BenchmarkWebp(b *testing.B) {
 	var err error
 	var buf bytes.Buffer